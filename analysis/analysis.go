@@ -0,0 +1,92 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/token"
+)
+
+type (
+	// An Analyzer is a single, independent check over a parsed program.
+	Analyzer struct {
+		Name string
+		Doc  string
+		Run  func(*Pass)
+	}
+
+	// A Pass is the interface between the driver and one Analyzer run.
+	Pass struct {
+		Analyzer *Analyzer
+		Program  *ast.Program
+
+		resolution  *resolution
+		diagnostics *[]Diagnostic
+	}
+
+	Diagnostic struct {
+		Pos      token.Position
+		Analyzer string
+		Message  string
+	}
+)
+
+var All = []*Analyzer{
+	UnusedLet,
+	Shadow,
+	Unreachable,
+	ConstCond,
+	SelfCompare,
+	DupParam,
+}
+
+func Lookup(name string) *Analyzer {
+	for _, a := range All {
+		if a.Name == name {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// Run applies each analyzer to program and returns their diagnostics
+// ordered by position. The program must be free of parse errors.
+func Run(program *ast.Program, analyzers ...*Analyzer) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	res := resolve(program)
+
+	for _, a := range analyzers {
+		pass := &Pass{
+			Analyzer:    a,
+			Program:     program,
+			resolution:  res,
+			diagnostics: &diagnostics,
+		}
+
+		a.Run(pass)
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Pos.Offset < diagnostics[j].Pos.Offset
+	})
+
+	return diagnostics
+}
+
+func (p *Pass) Report(d Diagnostic) {
+	if d.Analyzer == "" {
+		d.Analyzer = p.Analyzer.Name
+	}
+
+	*p.diagnostics = append(*p.diagnostics, d)
+}
+
+func (p *Pass) Reportf(pos token.Position, format string, args ...interface{}) {
+	p.Report(Diagnostic{Pos: pos, Message: fmt.Sprintf(format, args...)})
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Pos, d.Message, d.Analyzer)
+}
@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/parser"
+	"github.com/stretchr/testify/suite"
+)
+
+type AnalysisTestSuite struct {
+	suite.Suite
+}
+
+func TestAnalysisTestSuite(t *testing.T) {
+	suite.Run(t, new(AnalysisTestSuite))
+}
+
+func (s *AnalysisTestSuite) TestAnalyzers() {
+	expectations := []struct {
+		Analyzer *Analyzer
+		Input    string
+		Expected []string
+	}{
+		{
+			UnusedLet,
+			"let f = fn() { let x = 1; let y = 2; y };",
+			[]string{"1:20: x declared and not used (unusedlet)"},
+		},
+		{
+			UnusedLet,
+			"let x = 1; let f = fn() { let g = fn() { h() }; let h = fn() { x }; g() };",
+			[]string{},
+		},
+		{
+			UnusedLet,
+			"if (true) { let x = 1; }",
+			[]string{"1:17: x declared and not used (unusedlet)"},
+		},
+		{
+			Shadow,
+			"let x = 1; let f = fn(x, y) { fn(y) { x } };",
+			[]string{
+				"1:23: parameter x shadows declaration at 1:5 (shadow)",
+				"1:34: parameter y shadows declaration at 1:26 (shadow)",
+			},
+		},
+		{
+			Unreachable,
+			"fn() { return 1; 2; 3 }; return 4; 5",
+			[]string{
+				"1:18: unreachable code (unreachable)",
+				"1:36: unreachable code (unreachable)",
+			},
+		},
+		{
+			ConstCond,
+			"if (true) { 1 } else { 2 }; if (x) { 1 }",
+			[]string{"1:5: condition is always true (constcond)"},
+		},
+		{
+			SelfCompare,
+			"x == x; a + 1 != a + 1; f() == f(); x == y",
+			[]string{
+				"1:1: self-comparison (x == x) is always true (selfcompare)",
+				"1:9: self-comparison ((a + 1) != (a + 1)) is always false (selfcompare)",
+			},
+		},
+		{
+			DupParam,
+			"fn(a, b, a) { a + b }",
+			[]string{"1:10: duplicate parameter a (dupparam)"},
+		},
+	}
+
+	for _, e := range expectations {
+		p := parser.New(lexer.New(e.Input))
+		program := p.ParseProgram()
+		s.Empty(p.Errors())
+
+		diagnostics := []string{}
+		for _, d := range Run(program, e.Analyzer) {
+			diagnostics = append(diagnostics, d.String())
+		}
+
+		s.Equal(e.Expected, diagnostics, e.Input)
+	}
+}
+
+func (s *AnalysisTestSuite) TestRunOrdersDiagnostics() {
+	input := `
+		let f = fn(a, a) {
+			if (a == a) { return 1; 2 }
+		};
+	`
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	analyzers := []string{}
+	for _, d := range Run(program, All...) {
+		analyzers = append(analyzers, d.Analyzer)
+	}
+
+	s.Equal([]string{"dupparam", "selfcompare", "unreachable"}, analyzers)
+}
@@ -0,0 +1,158 @@
+package analysis
+
+import (
+	"github.com/marcel/monkey/ast"
+)
+
+var (
+	UnusedLet = &Analyzer{
+		Name: "unusedlet",
+		Doc:  "report let bindings inside functions and blocks that are never used",
+		Run:  runUnusedLet,
+	}
+
+	Shadow = &Analyzer{
+		Name: "shadow",
+		Doc:  "report function parameters that shadow a name from an enclosing scope",
+		Run:  runShadow,
+	}
+
+	Unreachable = &Analyzer{
+		Name: "unreachable",
+		Doc:  "report statements that follow a return statement",
+		Run:  runUnreachable,
+	}
+
+	ConstCond = &Analyzer{
+		Name: "constcond",
+		Doc:  "report if expressions whose condition is a boolean literal",
+		Run:  runConstCond,
+	}
+
+	SelfCompare = &Analyzer{
+		Name: "selfcompare",
+		Doc:  "report comparisons of an expression with itself",
+		Run:  runSelfCompare,
+	}
+
+	DupParam = &Analyzer{
+		Name: "dupparam",
+		Doc:  "report function literals that declare the same parameter twice",
+		Run:  runDupParam,
+	}
+)
+
+// Top-level bindings are never reported: the host may look them up after
+// the program has run.
+func runUnusedLet(pass *Pass) {
+	for _, b := range pass.resolution.bindings {
+		if b.kind != letBinding || b.used || b.scope.global || b.ident.Value == "_" {
+			continue
+		}
+
+		pass.Reportf(b.ident.Pos(), "%s declared and not used", b.ident.Value)
+	}
+}
+
+func runShadow(pass *Pass) {
+	for _, s := range pass.resolution.shadowings {
+		pass.Reportf(
+			s.param.Pos(),
+			"parameter %s shadows declaration at %s",
+			s.param.Value,
+			s.shadow.ident.Pos(),
+		)
+	}
+}
+
+func runUnreachable(pass *Pass) {
+	check := func(statements []ast.Statement) {
+		for i, stmt := range statements {
+			if _, ok := stmt.(*ast.ReturnStatement); ok && i+1 < len(statements) {
+				pass.Reportf(statements[i+1].Pos(), "unreachable code")
+				return
+			}
+		}
+	}
+
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Program:
+			check(n.Statements)
+		case *ast.BlockStatement:
+			check(n.Statements)
+		}
+
+		return true
+	})
+}
+
+func runConstCond(pass *Pass) {
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		if ie, ok := n.(*ast.IfExpression); ok {
+			if b, ok := ie.Condition.(*ast.Boolean); ok {
+				pass.Reportf(b.Pos(), "condition is always %t", b.Value)
+			}
+		}
+
+		return true
+	})
+}
+
+func runSelfCompare(pass *Pass) {
+	results := map[string]bool{"==": true, "!=": false, "<": false, ">": false}
+
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		ie, ok := n.(*ast.InfixExpression)
+		if !ok {
+			return true
+		}
+
+		result, ok := results[ie.Operator]
+		if !ok || !isPure(ie.Left) || ie.Left.String() != ie.Right.String() {
+			return true
+		}
+
+		pass.Reportf(ie.Pos(), "self-comparison %s is always %t", ie.String(), result)
+
+		return true
+	})
+}
+
+func runDupParam(pass *Pass) {
+	ast.Inspect(pass.Program, func(n ast.Node) bool {
+		fl, ok := n.(*ast.FunctionLiteral)
+		if !ok {
+			return true
+		}
+
+		seen := map[string]bool{}
+		for _, p := range fl.Parameters {
+			if seen[p.Value] {
+				pass.Reportf(p.Pos(), "duplicate parameter %s", p.Value)
+			}
+
+			seen[p.Value] = true
+		}
+
+		return true
+	})
+}
+
+// isPure reports whether evaluating exp twice is guaranteed to produce the
+// same value: it contains no calls and creates no functions, arrays or
+// hashes, which compare by identity.
+func isPure(exp ast.Expression) bool {
+	pure := true
+
+	ast.Inspect(exp, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.CallExpression, *ast.FunctionLiteral, *ast.ArrayLiteral, *ast.HashLiteral:
+			pure = false
+		}
+
+		return pure
+	})
+
+	return pure
+}
@@ -0,0 +1,179 @@
+package analysis
+
+import (
+	"github.com/marcel/monkey/ast"
+)
+
+const (
+	letBinding bindingKind = iota
+	paramBinding
+)
+
+type (
+	bindingKind int
+
+	binding struct {
+		ident *ast.Identifier
+		kind  bindingKind
+		scope *scope
+		used  bool
+	}
+
+	scope struct {
+		outer    *scope
+		names    map[string]*binding
+		global   bool
+		function bool
+		pending  []pendingFunction
+	}
+
+	// Function bodies run when they are called, not where they are defined,
+	// so they may refer to bindings declared after them in an enclosing
+	// scope. Resolving them once their enclosing function (or the program)
+	// has been fully declared mirrors that.
+	pendingFunction struct {
+		scope   *scope
+		literal *ast.FunctionLiteral
+	}
+
+	shadowing struct {
+		param  *ast.Identifier
+		shadow *binding
+	}
+
+	resolution struct {
+		bindings   []*binding
+		shadowings []shadowing
+	}
+)
+
+func resolve(program *ast.Program) *resolution {
+	res := &resolution{}
+
+	global := newScope(nil)
+	global.global = true
+	global.function = true
+
+	res.statements(global, program.Statements)
+	res.close(global)
+
+	return res
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{outer: outer, names: make(map[string]*binding)}
+}
+
+func (s *scope) lookup(name string) *binding {
+	for ; s != nil; s = s.outer {
+		if b, ok := s.names[name]; ok {
+			return b
+		}
+	}
+
+	return nil
+}
+
+func (s *scope) enclosingFunction() *scope {
+	for !s.function {
+		s = s.outer
+	}
+
+	return s
+}
+
+func (r *resolution) declare(s *scope, ident *ast.Identifier, kind bindingKind) {
+	b := &binding{ident: ident, kind: kind, scope: s}
+	s.names[ident.Value] = b
+	r.bindings = append(r.bindings, b)
+}
+
+func (r *resolution) close(s *scope) {
+	for len(s.pending) > 0 {
+		pf := s.pending[0]
+		s.pending = s.pending[1:]
+
+		r.function(pf.scope, pf.literal)
+	}
+}
+
+func (r *resolution) function(outer *scope, fl *ast.FunctionLiteral) {
+	s := newScope(outer)
+	s.function = true
+
+	for _, p := range fl.Parameters {
+		if b := outer.lookup(p.Value); b != nil {
+			r.shadowings = append(r.shadowings, shadowing{param: p, shadow: b})
+		}
+
+		r.declare(s, p, paramBinding)
+	}
+
+	if fl.Body != nil {
+		r.statements(s, fl.Body.Statements)
+	}
+
+	r.close(s)
+}
+
+func (r *resolution) statements(s *scope, statements []ast.Statement) {
+	for _, stmt := range statements {
+		r.statement(s, stmt)
+	}
+}
+
+func (r *resolution) statement(s *scope, stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		r.expression(s, stmt.Value)
+		r.declare(s, stmt.Name, letBinding)
+	case *ast.ReturnStatement:
+		r.expression(s, stmt.ReturnValue)
+	case *ast.ExpressionStatement:
+		r.expression(s, stmt.Expression)
+	case *ast.BlockStatement:
+		r.statements(newScope(s), stmt.Statements)
+	}
+}
+
+func (r *resolution) expression(s *scope, exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if b := s.lookup(exp.Value); b != nil {
+			b.used = true
+		}
+	case *ast.PrefixExpression:
+		r.expression(s, exp.Right)
+	case *ast.InfixExpression:
+		r.expression(s, exp.Left)
+		r.expression(s, exp.Right)
+	case *ast.CallExpression:
+		r.expression(s, exp.Function)
+		for _, a := range exp.Arguments {
+			r.expression(s, a)
+		}
+	case *ast.ArrayLiteral:
+		for _, e := range exp.Elements {
+			r.expression(s, e)
+		}
+	case *ast.HashLiteral:
+		for _, p := range exp.Pairs {
+			r.expression(s, p.Key)
+			r.expression(s, p.Value)
+		}
+	case *ast.IndexExpression:
+		r.expression(s, exp.Left)
+		r.expression(s, exp.Index)
+	case *ast.IfExpression:
+		r.expression(s, exp.Condition)
+		if exp.Consequence != nil {
+			r.statement(s, exp.Consequence)
+		}
+		if exp.Alternative != nil {
+			r.statement(s, exp.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		fn := s.enclosingFunction()
+		fn.pending = append(fn.pending, pendingFunction{scope: s, literal: exp})
+	}
+}
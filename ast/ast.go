@@ -11,6 +11,7 @@ type (
 	Node interface {
 		TokenLiteral() string
 		String() string
+		Pos() token.Position
 	}
 
 	Statement interface {
@@ -37,8 +38,29 @@ type (
 		Value int64
 	}
 
+	StringLiteral struct {
+		token.Token
+		Value string
+	}
+
+	ArrayLiteral struct {
+		token.Token
+		Elements []Expression
+	}
+
+	HashLiteral struct {
+		token.Token
+		Pairs []HashPair
+	}
+
+	HashPair struct {
+		Key   Expression
+		Value Expression
+	}
+
 	FunctionLiteral struct {
 		token.Token
+		Name       string
 		Parameters []*Identifier
 		Body       *BlockStatement
 	}
@@ -90,6 +112,12 @@ type (
 		Arguments []Expression
 	}
 
+	IndexExpression struct {
+		token.Token
+		Left  Expression
+		Index Expression
+	}
+
 	ReturnStatement struct {
 		token.Token
 		ReturnValue Expression
@@ -104,6 +132,14 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -120,6 +156,41 @@ func (i *Identifier) String() string { return i.Value }
 func (*IntegerLiteral) expressionNode()   {}
 func (il *IntegerLiteral) String() string { return il.Literal }
 
+func (*StringLiteral) expressionNode()   {}
+func (sl *StringLiteral) String() string { return sl.Literal }
+
+func (*ArrayLiteral) expressionNode() {}
+func (al *ArrayLiteral) String() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range al.Elements {
+		elements = append(elements, e.String())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+func (*HashLiteral) expressionNode() {}
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, p := range hl.Pairs {
+		pairs = append(pairs, p.Key.String()+":"+p.Value.String())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
 func (*FunctionLiteral) expressionNode() {}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
@@ -203,6 +274,14 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+
+	return ie.Position
+}
+
 func (*IfExpression) expressionNode() {}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
@@ -237,6 +316,35 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+
+	return ce.Position
+}
+
+func (*IndexExpression) expressionNode() {}
+func (ie *IndexExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ie.Left.String())
+	out.WriteString("[")
+	out.WriteString(ie.Index.String())
+	out.WriteString("])")
+
+	return out.String()
+}
+
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+
+	return ie.Position
+}
+
 func (*ReturnStatement) statementNode() {}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
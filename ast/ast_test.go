@@ -41,3 +41,40 @@ func (s *ASTTestSuite) TestString() {
 
 	s.Equal("let myVar = anotherVar;return myVar;", program.String())
 }
+
+func (s *ASTTestSuite) TestInspect() {
+	program := &Program{
+		Statements: []Statement{
+			&ExpressionStatement{
+				Token: token.IDENT.Token("add"),
+				Expression: &CallExpression{
+					Token:    token.LPAREN.Token("("),
+					Function: &Identifier{Token: token.IDENT.Token("add"), Value: "add"},
+					Arguments: []Expression{
+						&IntegerLiteral{Token: token.INT.Token("1"), Value: 1},
+						&PrefixExpression{
+							Token:    token.MINUS.Token("-"),
+							Operator: "-",
+							Right:    &Identifier{Token: token.IDENT.Token("x"), Value: "x"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	visited := []string{}
+	Inspect(program, func(n Node) bool {
+		switch n := n.(type) {
+		case *Identifier:
+			visited = append(visited, n.Value)
+		case *PrefixExpression:
+			visited = append(visited, n.Operator)
+			return false
+		}
+
+		return true
+	})
+
+	s.Equal([]string{"add", "-"}, visited)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/marcel/monkey"
+	"github.com/marcel/monkey/mkc"
+	"github.com/marcel/monkey/optimizer"
+)
+
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey build [-o out.mkc] [-s] [-O] file")
+		flags.PrintDefaults()
+	}
+
+	output := flags.String("o", "", "write the compiled program to `file` (default: the source file with the extension .mkc)")
+	strip := flags.Bool("s", false, "omit debug information: line tables and function names")
+	optimize := flags.Bool("O", false, "optimize the compiled instructions")
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkc"
+	}
+
+	program, err := monkey.CompileFile(path)
+	if err != nil {
+		report(err)
+		return 1
+	}
+
+	if *optimize {
+		program = optimizer.Peephole(program, 0)
+	}
+
+	data, err := mkc.Marshal(program, !*strip)
+	if err == nil {
+		err = os.WriteFile(*output, data, 0o644)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -9,6 +9,17 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "build":
+			os.Exit(build(os.Args[2:]))
+		case "run":
+			os.Exit(run(os.Args[2:]))
+		case "vet":
+			os.Exit(vet(os.Args[2:]))
+		}
+	}
+
 	user, err := user.Current()
 
 	if err != nil {
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/marcel/monkey"
+)
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey run [-engine name] [-O] file.mk|file.mkc")
+		flags.PrintDefaults()
+	}
+
+	engineName := flags.String("engine", "", "execute with the `engine` tree-walking or vm (default: vm for .mkc files, tree-walking otherwise)")
+	optimize := flags.Bool("O", false, "optimize programs before they run")
+
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+
+	// Compiled programs only run on the VM.
+	engine := monkey.TreeWalking
+	if filepath.Ext(path) == ".mkc" {
+		engine = monkey.VM
+	}
+
+	if *engineName != "" {
+		var ok bool
+		if engine, ok = parseEngine(*engineName); !ok {
+			fmt.Fprintf(os.Stderr, "unknown engine %q\n", *engineName)
+			return 2
+		}
+	}
+
+	opts := []monkey.Option{monkey.WithEngine(engine)}
+	if *optimize {
+		opts = append(opts, monkey.WithOptimizations())
+	}
+
+	interp := monkey.NewInterpreter(opts...)
+
+	if _, err := interp.EvalFile(context.Background(), path); err != nil {
+		report(err)
+		return 1
+	}
+
+	return 0
+}
+
+func parseEngine(name string) (monkey.Engine, bool) {
+	for _, e := range []monkey.Engine{monkey.TreeWalking, monkey.VM} {
+		if e.String() == name {
+			return e, true
+		}
+	}
+
+	return 0, false
+}
+
+// report prints err, with the Monkey stack trace of a runtime error.
+func report(err error) {
+	var monkeyErr *monkey.Error
+	if errors.As(err, &monkeyErr) {
+		fmt.Fprintln(os.Stderr, monkeyErr.StackTrace())
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/marcel/monkey/analysis"
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/parser"
+)
+
+func vet(args []string) int {
+	flags := flag.NewFlagSet("vet", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: monkey vet [-check=false ...] file...")
+		flags.PrintDefaults()
+	}
+
+	enabled := map[*analysis.Analyzer]*bool{}
+	for _, a := range analysis.All {
+		enabled[a] = flags.Bool(a.Name, true, a.Doc)
+	}
+
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	analyzers := []*analysis.Analyzer{}
+	for _, a := range analysis.All {
+		if *enabled[a] {
+			analyzers = append(analyzers, a)
+		}
+	}
+
+	status := 0
+
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+			continue
+		}
+
+		p := parser.New(lexer.New(string(src)))
+		program := p.ParseProgram()
+
+		if errors := p.Errors(); len(errors) > 0 {
+			for _, msg := range errors {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, msg)
+			}
+			status = 2
+			continue
+		}
+
+		for _, d := range analysis.Run(program, analyzers...) {
+			fmt.Fprintf(os.Stderr, "%s:%s\n", path, d)
+			if status == 0 {
+				status = 1
+			}
+		}
+	}
+
+	return status
+}
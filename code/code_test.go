@@ -0,0 +1,169 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/marcel/monkey/token"
+)
+
+type CodeTestSuite struct {
+	suite.Suite
+}
+
+func TestCodeTestSuite(t *testing.T) {
+	suite.Run(t, new(CodeTestSuite))
+}
+
+func (s *CodeTestSuite) TestMake() {
+	expectations := []struct {
+		Op       Opcode
+		Operands []int
+		Expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{Opcode(255), []int{1}, []byte{}},
+	}
+
+	for _, e := range expectations {
+		s.Equal(e.Expected, Make(e.Op, e.Operands...), e.Op.String())
+	}
+}
+
+func (s *CodeTestSuite) TestInstructionsString() {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpClosure, 65535, 255),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65535
+0009 OpClosure 65535 255
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	s.Equal(expected, concatted.String())
+}
+
+func (s *CodeTestSuite) TestInstructionsStringErrors() {
+	ins := Instructions{255, byte(OpPop), byte(OpConstant), 1}
+
+	s.Equal("0000 ERROR: opcode 255 undefined\n0001 OpPop\n0002 ERROR: OpConstant truncated\n", ins.String())
+}
+
+func (s *CodeTestSuite) TestReadOperands() {
+	expectations := []struct {
+		Op        Opcode
+		Operands  []int
+		BytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpClosure, []int{65535, 255}, 3},
+	}
+
+	for _, e := range expectations {
+		instruction := Make(e.Op, e.Operands...)
+
+		def, err := Lookup(byte(e.Op))
+		s.NoError(err)
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		s.Equal(e.BytesRead, n)
+		s.Equal(e.Operands, operandsRead)
+	}
+}
+
+func (s *CodeTestSuite) TestDefinitions() {
+	s.Len(Opcodes(), len(definitions))
+
+	for _, op := range Opcodes() {
+		def, err := Lookup(byte(op))
+		s.NoError(err, op.String())
+		s.Equal(def.Name, op.String())
+	}
+
+	_, err := Lookup(255)
+	s.EqualError(err, "opcode 255 undefined")
+}
+
+func FuzzMakeReadOperands(f *testing.F) {
+	for _, op := range Opcodes() {
+		f.Add(byte(op), uint16(0), uint16(1))
+		f.Add(byte(op), uint16(65535), uint16(255))
+	}
+
+	f.Fuzz(func(t *testing.T, op byte, a, b uint16) {
+		def, err := Lookup(op)
+		if err != nil {
+			if len(Make(Opcode(op), int(a), int(b))) != 0 {
+				t.Fatalf("Make encoded undefined opcode %d", op)
+			}
+			return
+		}
+
+		operands := []int{int(a), int(b)}[:len(def.OperandWidths)]
+		for i, w := range def.OperandWidths {
+			operands[i] &= 1<<(8*w) - 1
+		}
+
+		instruction := Make(Opcode(op), operands...)
+		if Opcode(instruction[0]) != Opcode(op) {
+			t.Fatalf("opcode %d encoded as %d", op, instruction[0])
+		}
+
+		decoded, n := ReadOperands(def, instruction[1:])
+		if n != len(instruction)-1 {
+			t.Fatalf("%s: read %d bytes of %d", def.Name, n, len(instruction)-1)
+		}
+
+		for i := range operands {
+			if decoded[i] != operands[i] {
+				t.Fatalf("%s: operand %d encoded as %d, decoded as %d", def.Name, i, operands[i], decoded[i])
+			}
+		}
+	})
+}
+
+func (s *CodeTestSuite) TestLineTable() {
+	pos := func(line, column int) token.Position {
+		return token.Position{Line: line, Column: column}
+	}
+
+	var lines LineTable
+	lines = lines.Add(0, pos(1, 1))
+	lines = lines.Add(3, pos(1, 1))
+	lines = lines.Add(4, token.Position{})
+	lines = lines.Add(6, pos(2, 5))
+	lines = lines.Add(6, pos(2, 3))
+	lines = lines.Add(9, pos(1, 1))
+
+	s.Equal(LineTable{{0, pos(1, 1)}, {6, pos(2, 3)}, {9, pos(1, 1)}}, lines)
+
+	expectations := map[int]token.Position{
+		0:  pos(1, 1),
+		5:  pos(1, 1),
+		6:  pos(2, 3),
+		8:  pos(2, 3),
+		20: pos(1, 1),
+	}
+
+	for offset, expected := range expectations {
+		s.Equal(expected, lines.Lookup(offset), offset)
+	}
+
+	s.Equal(token.Position{}, LineTable{{2, pos(1, 1)}}.Lookup(1))
+}
@@ -0,0 +1,50 @@
+package code
+
+import (
+	"sort"
+
+	"github.com/marcel/monkey/token"
+)
+
+type (
+	// A LineTable maps instruction offsets to the source positions they
+	// were compiled from. Each Line covers the instructions from its Offset
+	// up to the Offset of the next one; lines are sorted by offset.
+	LineTable []Line
+
+	Line struct {
+		Offset int
+		Pos    token.Position
+	}
+)
+
+// Add records that the instructions from offset on were compiled from pos.
+// Offsets must be added in ascending order.
+func (t LineTable) Add(offset int, pos token.Position) LineTable {
+	if !pos.IsValid() {
+		return t
+	}
+
+	if n := len(t); n > 0 {
+		switch {
+		case t[n-1].Pos == pos:
+			return t
+		case t[n-1].Offset == offset:
+			t[n-1].Pos = pos
+			return t
+		}
+	}
+
+	return append(t, Line{Offset: offset, Pos: pos})
+}
+
+// Lookup returns the position of the instruction at offset, or the zero
+// position when the table does not cover it.
+func (t LineTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+
+	return t[i-1].Pos
+}
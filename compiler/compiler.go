@@ -0,0 +1,682 @@
+// Package compiler lowers ast.Programs to the bytecode the vm package runs.
+package compiler
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/token"
+)
+
+const (
+	maxConstants = math.MaxUint16 + 1
+	maxGlobals   = math.MaxUint16 + 1
+	maxLocals    = math.MaxUint8 + 1
+	maxFree      = math.MaxUint8 + 1
+	maxBuiltins  = math.MaxUint8 + 1
+	maxArguments = math.MaxUint8
+	maxElements  = math.MaxUint16
+	maxJump      = math.MaxUint16
+)
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+}
+
+type (
+	// A Compiler compiles programs one at a time. Compiling several
+	// programs with the same symbol table and constants, see NewWithState,
+	// lets later ones use the globals of earlier ones.
+	Compiler struct {
+		constants   []object.Object
+		symbolTable *SymbolTable
+		scopes      []CompilationScope
+		scopeIndex  int
+	}
+
+	// A CompilationScope holds the instructions of a function, or of the
+	// main program, as they are compiled. captured holds the names the
+	// functions defined in it refer to.
+	CompilationScope struct {
+		instructions        code.Instructions
+		lines               code.LineTable
+		lastInstruction     EmittedInstruction
+		previousInstruction EmittedInstruction
+		captured            map[string]bool
+	}
+
+	EmittedInstruction struct {
+		Opcode   code.Opcode
+		Position int
+	}
+
+	// Bytecode is a compiled program. The main program ends in a return
+	// like a function does. Globals and Builtins hold the names of the
+	// globals and builtins the instructions refer to by index.
+	Bytecode struct {
+		Instructions code.Instructions
+		Lines        code.LineTable
+		Constants    []object.Object
+		Globals      []string
+		Builtins     []string
+	}
+
+	// An Error is a program the compiler cannot lower to bytecode, such as
+	// a function with too many local bindings.
+	Error struct {
+		Pos     token.Position
+		Message string
+	}
+)
+
+// DefaultBuiltins returns the names of the builtins of
+// object.CoreBuiltins, in the order New defines them.
+func DefaultBuiltins() []string {
+	return object.CoreBuiltins(nil, nil, nil).Names()
+}
+
+// NewGlobalSymbolTable returns a global symbol table that defines builtins.
+func NewGlobalSymbolTable(builtins []string) *SymbolTable {
+	symbolTable := NewSymbolTable()
+	for _, name := range builtins {
+		symbolTable.DefineBuiltin(name)
+	}
+
+	return symbolTable
+}
+
+func New() *Compiler {
+	return NewWithState(NewGlobalSymbolTable(DefaultBuiltins()), []object.Object{})
+}
+
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	return &Compiler{
+		constants:   constants,
+		symbolTable: s,
+		scopes:      []CompilationScope{{}},
+	}
+}
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		c.scopes[c.scopeIndex].captured = capturedNames(node)
+		if err := c.compileStatements(node.Statements, false); err != nil {
+			return err
+		}
+		c.finishBody(node.Statements, node.Pos())
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(node.Pos(), code.OpPop)
+	case *ast.BlockStatement:
+		return c.compileStatements(node.Statements, false)
+	case *ast.LetStatement:
+		return c.compileLet(node)
+	case *ast.ReturnStatement:
+		if err := c.compileExpression(node.ReturnValue, c.scopeIndex > 0); err != nil {
+			return err
+		}
+		c.emit(node.Pos(), code.OpReturnValue)
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// The name may be defined later, before the code that refers
+			// to it runs; the VM reports it if it is not.
+			symbol = c.symbolTable.Global().Define(node.Value)
+		}
+		return c.loadSymbol(node.Pos(), symbol)
+	case *ast.IntegerLiteral:
+		return c.emitConstant(node.Pos(), &object.Integer{Value: node.Value})
+	case *ast.StringLiteral:
+		return c.emitConstant(node.Pos(), &object.String{Value: node.Value})
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(node.Pos(), code.OpTrue)
+		} else {
+			c.emit(node.Pos(), code.OpFalse)
+		}
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(node.Pos(), code.OpBang)
+		case "-":
+			c.emit(node.Pos(), code.OpMinus)
+		default:
+			return c.errorf(node.Pos(), "unknown operator %s", node.Operator)
+		}
+	case *ast.InfixExpression:
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return c.errorf(node.Token.Pos(), "unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(node.Token.Pos(), op)
+	case *ast.IfExpression:
+		return c.compileIf(node, false)
+	case *ast.ArrayLiteral:
+		if len(node.Elements) > maxElements {
+			return c.errorf(node.Pos(), "too many elements in array literal")
+		}
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(node.Pos(), code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		if 2*len(node.Pairs) > maxElements {
+			return c.errorf(node.Pos(), "too many pairs in hash literal")
+		}
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(node.Pos(), code.OpHash, 2*len(node.Pairs))
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(node.Token.Pos(), code.OpIndex)
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node)
+	case *ast.CallExpression:
+		return c.compileCall(node, false)
+	default:
+		return c.errorf(token.Position{}, "cannot compile %T", node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Constants:    c.constants,
+		Globals:      c.symbolTable.GlobalNames(),
+		Builtins:     c.symbolTable.BuiltinNames(),
+	}
+}
+
+// compileStatements compiles the statements of a body or block. The value
+// of the last one is in tail position when tail is set.
+func (c *Compiler) compileStatements(stmts []ast.Statement, tail bool) error {
+	for i, stmt := range stmts {
+		es, ok := stmt.(*ast.ExpressionStatement)
+		if !ok || !tail || i < len(stmts)-1 {
+			if err := c.Compile(stmt); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := c.compileExpression(es.Expression, true); err != nil {
+			return err
+		}
+		c.emit(es.Pos(), code.OpPop)
+	}
+
+	return nil
+}
+
+// compileExpression compiles exp, making the calls in tail position tail
+// calls when tail is set.
+func (c *Compiler) compileExpression(exp ast.Expression, tail bool) error {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		return c.compileCall(exp, tail)
+	case *ast.IfExpression:
+		return c.compileIf(exp, tail)
+	}
+
+	return c.Compile(exp)
+}
+
+// finishBody makes the value of the last statement of a function body, or
+// of the main program, its return value. Bodies that end in anything but
+// an expression return null.
+func (c *Compiler) finishBody(stmts []ast.Statement, pos token.Position) {
+	if len(stmts) > 0 {
+		if _, ok := stmts[len(stmts)-1].(*ast.ExpressionStatement); ok && c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+			return
+		}
+	}
+
+	if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(pos, code.OpReturn)
+	}
+}
+
+func (c *Compiler) compileLet(node *ast.LetStatement) error {
+	var symbol Symbol
+
+	// A function may refer to the name it is bound to. Any other value
+	// is computed before the name is bound, in case it refers to an outer
+	// binding of the same name.
+	_, isFunction := node.Value.(*ast.FunctionLiteral)
+	if isFunction {
+		symbol = c.symbolTable.Define(node.Name.Value)
+	}
+
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+
+	if !isFunction {
+		symbol = c.symbolTable.Define(node.Name.Value)
+	}
+
+	return c.storeSymbol(node.Name.Pos(), symbol)
+}
+
+func (c *Compiler) compileIf(node *ast.IfExpression, tail bool) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthyPos := c.emit(node.Pos(), code.OpJumpNotTruthy, 9999)
+
+	if err := c.compileBlock(node.Consequence, tail); err != nil {
+		return err
+	}
+
+	jumpPos := c.emit(node.Pos(), code.OpJump, 9999)
+
+	if err := c.changeOperand(node.Pos(), jumpNotTruthyPos, len(c.currentInstructions())); err != nil {
+		return err
+	}
+
+	if node.Alternative == nil {
+		c.emit(node.Pos(), code.OpNull)
+	} else if err := c.compileBlock(node.Alternative, tail); err != nil {
+		return err
+	}
+
+	return c.changeOperand(node.Pos(), jumpPos, len(c.currentInstructions()))
+}
+
+// compileBlock compiles a branch of an if expression in a scope of its own
+// and leaves its value on the stack: that of its last statement if it is an
+// expression, null otherwise.
+func (c *Compiler) compileBlock(block *ast.BlockStatement, tail bool) error {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	c.declare(block.Statements)
+
+	if err := c.compileStatements(block.Statements, tail); err != nil {
+		return err
+	}
+
+	if n := len(block.Statements); n > 0 {
+		switch block.Statements[n-1].(type) {
+		case *ast.ExpressionStatement:
+			c.removeLastPop()
+			return nil
+		case *ast.ReturnStatement:
+			return nil
+		}
+	}
+
+	c.emit(block.Pos(), code.OpNull)
+
+	return nil
+}
+
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	for _, p := range node.Parameters {
+		c.symbolTable.DefineParameter(p.Value)
+	}
+
+	captured := capturedNames(node.Body)
+	c.scopes[c.scopeIndex].captured = captured
+
+	for _, p := range node.Parameters {
+		if symbol, _ := c.symbolTable.Resolve(p.Value); !captured[p.Value] || symbol.Scope == CellScope {
+			continue
+		}
+
+		symbol := c.symbolTable.MakeCell(p.Value)
+		c.emit(p.Pos(), code.OpGetLocal, symbol.Index)
+		c.emit(p.Pos(), code.OpCell, symbol.Index)
+		c.emit(p.Pos(), code.OpSetCell, symbol.Index)
+	}
+
+	c.declare(node.Body.Statements)
+
+	if err := c.compileStatements(node.Body.Statements, true); err != nil {
+		c.leaveScope()
+		return err
+	}
+	c.finishBody(node.Body.Statements, node.Body.Pos())
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.NumDefinitions()
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+
+	switch {
+	case numLocals > maxLocals:
+		return c.errorf(node.Pos(), "too many local bindings in function")
+	case len(freeSymbols) > maxFree:
+		return c.errorf(node.Pos(), "too many free variables in function")
+	}
+
+	for _, s := range freeSymbols {
+		c.loadCell(node.Pos(), s)
+	}
+
+	fn := &object.CompiledFunction{
+		Name:          node.Name,
+		Source:        object.FunctionSource(node.Parameters, node.Body),
+		Instructions:  instructions,
+		Lines:         lines,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+	}
+
+	index, err := c.addConstant(node.Pos(), fn)
+	if err != nil {
+		return err
+	}
+
+	c.emit(node.Pos(), code.OpClosure, index, len(freeSymbols))
+
+	return nil
+}
+
+func (c *Compiler) compileCall(node *ast.CallExpression, tail bool) error {
+	if len(node.Arguments) > maxArguments {
+		return c.errorf(node.Pos(), "too many arguments in call")
+	}
+
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+
+	for _, a := range node.Arguments {
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+	}
+
+	op := code.OpCall
+	if tail {
+		op = code.OpTailCall
+	}
+	c.emit(node.Pos(), op, len(node.Arguments))
+
+	return nil
+}
+
+// declare reserves the slots of the names that the let statements of a body
+// or block bind and functions capture, before any of its code runs. Those
+// of locals are cells.
+func (c *Compiler) declare(stmts []ast.Statement) {
+	captured := c.scopes[c.scopeIndex].captured
+
+	for _, stmt := range stmts {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || !captured[let.Name.Value] {
+			continue
+		}
+
+		if symbol, ok := c.symbolTable.Declare(let.Name.Value); ok && symbol.Scope == CellScope {
+			c.emit(let.Pos(), code.OpCell, symbol.Index)
+		}
+	}
+}
+
+// capturedNames returns the names that the functions defined in node refer
+// to. Some may be bound by those functions themselves rather than captured,
+// but the locals of node they may refer to need to be held in cells.
+func capturedNames(node ast.Node) map[string]bool {
+	captured := map[string]bool{}
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FunctionLiteral)
+		if !ok {
+			return true
+		}
+
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Identifier); ok {
+				captured[ident.Value] = true
+			}
+			return true
+		})
+
+		return false
+	})
+
+	return captured
+}
+
+func (c *Compiler) loadSymbol(pos token.Position, s Symbol) error {
+	if s.Outer != nil {
+		return c.loadIfBound(pos, s)
+	}
+
+	switch s.Scope {
+	case GlobalScope:
+		if s.Index >= maxGlobals {
+			return c.errorf(pos, "too many global bindings")
+		}
+		c.emit(pos, code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(pos, code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(pos, code.OpGetBuiltin, s.Index)
+	case CellScope:
+		c.emit(pos, code.OpGetCell, s.Index)
+	case FreeScope:
+		c.emit(pos, code.OpGetFree, s.Index)
+	}
+
+	return nil
+}
+
+// loadIfBound loads s, a free variable or global that may not be bound yet,
+// or s.Outer while it is not.
+func (c *Compiler) loadIfBound(pos token.Position, s Symbol) error {
+	op := code.OpGetFreeIfBound
+	if s.Scope == GlobalScope {
+		if s.Index >= maxGlobals {
+			return c.errorf(pos, "too many global bindings")
+		}
+		op = code.OpGetGlobalIfBound
+	}
+
+	jumpPos := c.emit(pos, op, s.Index, 9999)
+
+	if err := c.loadSymbol(pos, *s.Outer); err != nil {
+		return err
+	}
+
+	return c.changeOperand(pos, jumpPos, len(c.currentInstructions()))
+}
+
+// loadCell loads the cell of s, a local or free variable that a closure
+// captures, rather than its value.
+func (c *Compiler) loadCell(pos token.Position, s Symbol) {
+	switch s.Scope {
+	case LocalScope, CellScope:
+		c.emit(pos, code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(pos, code.OpGetFreeCell, s.Index)
+	}
+}
+
+func (c *Compiler) storeSymbol(pos token.Position, s Symbol) error {
+	switch s.Scope {
+	case GlobalScope:
+		if s.Index >= maxGlobals {
+			return c.errorf(pos, "too many global bindings")
+		}
+		c.emit(pos, code.OpSetGlobal, s.Index)
+	case LocalScope:
+		if s.Index >= maxLocals {
+			return c.errorf(pos, "too many local bindings in function")
+		}
+		c.emit(pos, code.OpSetLocal, s.Index)
+	case CellScope:
+		if s.Index >= maxLocals {
+			return c.errorf(pos, "too many local bindings in function")
+		}
+		c.emit(pos, code.OpSetCell, s.Index)
+	}
+
+	return nil
+}
+
+func (c *Compiler) emitConstant(pos token.Position, obj object.Object) error {
+	index, err := c.addConstant(pos, obj)
+	if err != nil {
+		return err
+	}
+
+	c.emit(pos, code.OpConstant, index)
+
+	return nil
+}
+
+func (c *Compiler) addConstant(pos token.Position, obj object.Object) (int, error) {
+	if len(c.constants) >= maxConstants {
+		return 0, c.errorf(pos, "too many constants")
+	}
+
+	c.constants = append(c.constants, obj)
+
+	return len(c.constants) - 1, nil
+}
+
+// emit appends an instruction compiled from the source at pos and returns
+// its offset.
+func (c *Compiler) emit(pos token.Position, op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	offset := c.addInstruction(ins)
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.lines = scope.lines.Add(offset, pos)
+	scope.previousInstruction = scope.lastInstruction
+	scope.lastInstruction = EmittedInstruction{Opcode: op, Position: offset}
+
+	return offset
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	offset := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+
+	return offset
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	last := scope.lastInstruction
+
+	scope.instructions = scope.instructions[:last.Position]
+	scope.lastInstruction = scope.previousInstruction
+
+	for len(scope.lines) > 0 && scope.lines[len(scope.lines)-1].Offset >= last.Position {
+		scope.lines = scope.lines[:len(scope.lines)-1]
+	}
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	scope := &c.scopes[c.scopeIndex]
+	last := scope.lastInstruction.Position
+
+	c.replaceInstruction(last, code.Make(code.OpReturnValue))
+	scope.lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, ins []byte) {
+	copy(c.currentInstructions()[pos:], ins)
+}
+
+// changeOperand sets the last operand, the target, of the jump at opPos.
+func (c *Compiler) changeOperand(at token.Position, opPos int, operand int) error {
+	if operand > maxJump {
+		return c.errorf(at, "jump too far: program too large")
+	}
+
+	op := code.Opcode(c.currentInstructions()[opPos])
+	def, _ := code.Lookup(byte(op))
+	operands, _ := code.ReadOperands(def, c.currentInstructions()[opPos+1:])
+	operands[len(operands)-1] = operand
+
+	c.replaceInstruction(opPos, code.Make(op, operands...))
+
+	return nil
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() code.Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
+
+func (c *Compiler) errorf(pos token.Position, format string, a ...interface{}) *Error {
+	return &Error{Pos: pos, Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,626 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/parser"
+)
+
+type CompilerTestSuite struct {
+	suite.Suite
+}
+
+func TestCompilerTestSuite(t *testing.T) {
+	suite.Run(t, new(CompilerTestSuite))
+}
+
+type compilerTestCase struct {
+	Input                string
+	ExpectedConstants    []interface{}
+	ExpectedInstructions []code.Instructions
+}
+
+func (s *CompilerTestSuite) TestIntegerArithmetic() {
+	s.runCompilerTests([]compilerTestCase{
+		{
+			Input:             "1 + 2",
+			ExpectedConstants: []interface{}{1, 2},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input:             "1; 2",
+			ExpectedConstants: []interface{}{1, 2},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input:             "-1 * 2 / 3 - 4",
+			ExpectedConstants: []interface{}{1, 2, 3, 4},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpDiv),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpSub),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	})
+}
+
+func (s *CompilerTestSuite) TestBooleanExpressions() {
+	s.runCompilerTests([]compilerTestCase{
+		{
+			Input:             "1 < 2 == !false",
+			ExpectedConstants: []interface{}{1, 2},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpFalse),
+				code.Make(code.OpBang),
+				code.Make(code.OpEqual),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input:             "true != 1 > 2",
+			ExpectedConstants: []interface{}{1, 2},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterThan),
+				code.Make(code.OpNotEqual),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	})
+}
+
+func (s *CompilerTestSuite) TestConditionals() {
+	s.runCompilerTests([]compilerTestCase{
+		{
+			Input:             "if (true) { 10 }; 3333;",
+			ExpectedConstants: []interface{}{10, 3333},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 11),          // 0007
+				code.Make(code.OpNull),              // 0010
+				code.Make(code.OpPop),               // 0011
+				code.Make(code.OpConstant, 1),       // 0012
+				code.Make(code.OpReturnValue),       // 0015
+			},
+		},
+		{
+			Input:             "if (true) { 10 } else { 20 }",
+			ExpectedConstants: []interface{}{10, 20},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 13),          // 0007
+				code.Make(code.OpConstant, 1),       // 0010
+				code.Make(code.OpReturnValue),       // 0013
+			},
+		},
+		{
+			Input:             "if (true) { } else { let a = 1; }",
+			ExpectedConstants: []interface{}{1},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),             // 0000
+				code.Make(code.OpJumpNotTruthy, 8), // 0001
+				code.Make(code.OpNull),             // 0004
+				code.Make(code.OpJump, 15),         // 0005
+				code.Make(code.OpConstant, 0),      // 0008
+				code.Make(code.OpSetGlobal, 0),     // 0011
+				code.Make(code.OpNull),             // 0014
+				code.Make(code.OpReturnValue),      // 0015
+			},
+		},
+	})
+}
+
+func (s *CompilerTestSuite) TestGlobalLetStatements() {
+	s.runCompilerTests([]compilerTestCase{
+		{
+			Input:             "let one = 1; let two = one; two;",
+			ExpectedConstants: []interface{}{1},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input:             "let a = 1; let a = a + 1;",
+			ExpectedConstants: []interface{}{1, 1},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpReturn),
+			},
+		},
+		{
+			Input:             "let a = 1; if (true) { let a = 2; a }; a",
+			ExpectedConstants: []interface{}{1, 2},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),       // 0000
+				code.Make(code.OpSetGlobal, 0),      // 0003
+				code.Make(code.OpTrue),              // 0006
+				code.Make(code.OpJumpNotTruthy, 22), // 0007
+				code.Make(code.OpConstant, 1),       // 0010
+				code.Make(code.OpSetGlobal, 1),      // 0013
+				code.Make(code.OpGetGlobal, 1),      // 0016
+				code.Make(code.OpJump, 23),          // 0019
+				code.Make(code.OpNull),              // 0022
+				code.Make(code.OpPop),               // 0023
+				code.Make(code.OpGetGlobal, 0),      // 0024
+				code.Make(code.OpReturnValue),       // 0027
+			},
+		},
+		{
+			Input:             "x; let x = 1;",
+			ExpectedConstants: []interface{}{1},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpReturn),
+			},
+		},
+	})
+}
+
+func (s *CompilerTestSuite) TestStringsArraysAndHashes() {
+	s.runCompilerTests([]compilerTestCase{
+		{
+			Input:             `"mon" + "key"`,
+			ExpectedConstants: []interface{}{"mon", "key"},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input:             "[1, 2][0]",
+			ExpectedConstants: []interface{}{1, 2, 0},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpArray, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpIndex),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input:             `{}; {"a": 1 + 2}`,
+			ExpectedConstants: []interface{}{"a", 1, 2},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpHash, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpHash, 2),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	})
+}
+
+func (s *CompilerTestSuite) TestFunctions() {
+	s.runCompilerTests([]compilerTestCase{
+		{
+			Input: "fn() { return 5 + 10 }",
+			ExpectedConstants: []interface{}{
+				5,
+				10,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input: "fn() { 1; 2 }",
+			ExpectedConstants: []interface{}{
+				1,
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input: "fn() { }",
+			ExpectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpReturn),
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input: "let f = fn(a, b) { let c = a; c }; f(1, 2);",
+			ExpectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 2),
+					code.Make(code.OpGetLocal, 2),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				2,
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input: "fn() { len([]) }",
+			ExpectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 4),
+					code.Make(code.OpArray, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	})
+}
+
+func (s *CompilerTestSuite) TestTailCalls() {
+	s.runCompilerTests([]compilerTestCase{
+		{
+			Input: "fn(f) { f(); if (true) { return f() } else { f() } }",
+			ExpectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),       // 0000
+					code.Make(code.OpCall, 0),           // 0002
+					code.Make(code.OpPop),               // 0004
+					code.Make(code.OpTrue),              // 0005
+					code.Make(code.OpJumpNotTruthy, 17), // 0006
+					code.Make(code.OpGetLocal, 0),       // 0009
+					code.Make(code.OpTailCall, 0),       // 0011
+					code.Make(code.OpReturnValue),       // 0013
+					code.Make(code.OpJump, 21),          // 0014
+					code.Make(code.OpGetLocal, 0),       // 0017
+					code.Make(code.OpTailCall, 0),       // 0019
+					code.Make(code.OpReturnValue),       // 0021
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input: "fn(f) { f() + 1 }; f()",
+			ExpectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	})
+}
+
+func (s *CompilerTestSuite) TestClosures() {
+	s.runCompilerTests([]compilerTestCase{
+		{
+			Input: "fn(a) { fn(b) { a + b } }",
+			ExpectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCell, 0),
+					code.Make(code.OpSetCell, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input: "fn() { let g = fn() { h }; let h = 1; g() }",
+			ExpectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetFreeIfBound, 0, 7),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+				1,
+				[]code.Instructions{
+					code.Make(code.OpCell, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpSetCell, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			Input: "let countdown = fn(x) { countdown(x - 1) };",
+			ExpectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSub),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			ExpectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpReturn),
+			},
+		},
+	})
+}
+
+func (s *CompilerTestSuite) TestGlobalsAndBuiltins() {
+	bytecode := s.compile("let a = 1; puts(a, b); let b = 2;")
+
+	s.Equal([]string{"a", "b"}, bytecode.Globals)
+	s.Equal(DefaultBuiltins(), bytecode.Builtins)
+	s.Equal("puts", bytecode.Builtins[6])
+}
+
+func (s *CompilerTestSuite) TestLines() {
+	input := `let a = 1;
+let f = fn(x) {
+  x / a
+};
+f(2)`
+
+	bytecode := s.compile(input)
+
+	expectations := []struct {
+		Offset   int
+		Expected string
+	}{
+		{0, "1:9"},  // OpConstant 0
+		{3, "1:5"},  // OpSetGlobal 0
+		{6, "2:9"},  // OpClosure
+		{10, "2:5"}, // OpSetGlobal 1
+		{13, "5:1"}, // OpGetGlobal 1
+		{16, "5:3"}, // OpConstant 2
+		{19, "5:1"}, // OpCall 1
+		{21, "5:1"}, // OpReturnValue
+	}
+
+	s.Equal(code.Make(code.OpCall, 1), []byte(bytecode.Instructions[19:21]))
+	for _, e := range expectations {
+		s.Equal(e.Expected, bytecode.Lines.Lookup(e.Offset).String(), fmt.Sprintf("%04d", e.Offset))
+	}
+
+	fn := bytecode.Constants[1].(*object.CompiledFunction)
+	s.Equal(code.Make(code.OpDiv), []byte(fn.Instructions[5:6]))
+	s.Equal("3:5", fn.Lines.Lookup(5).String())
+	s.Equal("3:3", fn.Lines.Lookup(0).String())
+}
+
+func (s *CompilerTestSuite) TestErrors() {
+	var locals strings.Builder
+	for i := 0; i < maxLocals+1; i++ {
+		fmt.Fprintf(&locals, "\nlet %s = 0;", strings.Repeat("a", i+1))
+	}
+
+	args := strings.Repeat("0, ", maxArguments) + "0"
+
+	expectations := []struct {
+		Input    string
+		Expected string
+	}{
+		{"let f = fn() {" + locals.String() + "\n};", "258:5: too many local bindings in function"},
+		{"let f = fn() { fn() {" + locals.String() + "\n} };", "258:5: too many local bindings in function"},
+		{"len(" + args + ")", "1:1: too many arguments in call"},
+	}
+
+	for _, e := range expectations {
+		program := s.parse(e.Input)
+
+		c := New()
+		err := c.Compile(program)
+		s.Require().Error(err)
+		s.Equal(e.Expected, err.Error())
+		s.IsType(&Error{}, err)
+		s.True(err.(*Error).Pos.IsValid())
+
+		s.Zero(c.scopeIndex, "the scopes of the functions are left")
+		s.Len(c.scopes, 1)
+		s.Nil(c.symbolTable.Outer)
+	}
+
+	err := New().Compile(&ast.ExpressionStatement{})
+	s.EqualError(err, "cannot compile <nil>")
+}
+
+func (s *CompilerTestSuite) TestState() {
+	symbolTable := NewGlobalSymbolTable(DefaultBuiltins())
+	constants := []object.Object{}
+
+	first := NewWithState(symbolTable, constants)
+	s.Require().NoError(first.Compile(s.parse("let a = 1;")))
+
+	second := NewWithState(symbolTable, first.Bytecode().Constants)
+	s.Require().NoError(second.Compile(s.parse("a + 2")))
+
+	bytecode := second.Bytecode()
+	s.Len(bytecode.Constants, 2)
+	s.Equal(concat(
+		code.Make(code.OpGetGlobal, 0),
+		code.Make(code.OpConstant, 1),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	).String(), bytecode.Instructions.String())
+}
+
+func (s *CompilerTestSuite) TestLink() {
+	input := "let x = y; if (true) { let x = 1; puts(x) }; let f = fn() { len(x) + 2 };"
+
+	state := func() *Compiler {
+		symbolTable := NewGlobalSymbolTable([]string{"puts", "len"})
+		symbolTable.Define("y")
+
+		return NewWithState(symbolTable, []object.Object{&object.Integer{Value: 7}})
+	}
+
+	direct := state()
+	s.Require().NoError(direct.Compile(s.parse(input)))
+
+	linked, err := state().Link(s.compile(input))
+	s.Require().NoError(err)
+	s.Equal(direct.Bytecode(), linked, "linking a program compiles it with the state")
+	s.Equal([]string{"y", "x", "", "f"}, linked.Globals)
+
+	_, err = New().Link(&Bytecode{Instructions: code.Make(code.OpGetGlobal, 5)})
+	s.EqualError(err, "offset 0: global 5 undefined")
+
+	_, err = New().Link(&Bytecode{Constants: []object.Object{&object.CompiledFunction{Instructions: code.Instructions{255}}}})
+	s.EqualError(err, "function 0: offset 0: opcode 255 undefined")
+}
+
+func (s *CompilerTestSuite) runCompilerTests(tests []compilerTestCase) {
+	for _, tt := range tests {
+		bytecode := s.compile(tt.Input)
+
+		s.Equal(concat(tt.ExpectedInstructions...).String(), bytecode.Instructions.String(), tt.Input)
+		s.testConstants(tt.Input, tt.ExpectedConstants, bytecode.Constants)
+	}
+}
+
+func (s *CompilerTestSuite) testConstants(input string, expected []interface{}, actual []object.Object) {
+	s.Require().Len(actual, len(expected), input)
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			s.Equal(&object.Integer{Value: int64(constant)}, actual[i], input)
+		case string:
+			s.Equal(&object.String{Value: constant}, actual[i], input)
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			s.Require().True(ok, "%s: constant %d is %T", input, i, actual[i])
+			s.Equal(concat(constant...).String(), fn.Instructions.String(), input)
+		}
+	}
+}
+
+func (s *CompilerTestSuite) compile(input string) *Bytecode {
+	compiler := New()
+	s.Require().NoError(compiler.Compile(s.parse(input)), input)
+
+	return compiler.Bytecode()
+}
+
+func (s *CompilerTestSuite) parse(input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	s.Require().Empty(p.Errors(), input)
+
+	return program
+}
+
+func concat(instructions ...code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range instructions {
+		out = append(out, ins...)
+	}
+
+	return out
+}
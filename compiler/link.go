@@ -0,0 +1,153 @@
+package compiler
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/token"
+)
+
+// A linker renumbers the globals, builtins and constants a program refers
+// to.
+type linker struct {
+	global    *SymbolTable
+	globals   []int
+	builtins  []string
+	constants int
+}
+
+// Link adds program, compiled by another Compiler, to the state of c as if
+// c had compiled it, and returns the result. The globals of the top level
+// of program are matched with those of c by name and the unnamed ones of
+// its blocks get slots of their own. The instructions of program are
+// copied, not changed.
+func (c *Compiler) Link(program *Bytecode) (*Bytecode, error) {
+	global := c.symbolTable.Global()
+	l := &linker{global: global, builtins: program.Builtins, constants: len(c.constants)}
+
+	for _, name := range program.Globals {
+		table := global
+		if name == "" {
+			table = NewBlockSymbolTable(global)
+		}
+
+		symbol := table.Define(name)
+		if symbol.Index >= maxGlobals {
+			return nil, c.errorf(token.Position{}, "too many global bindings")
+		}
+		l.globals = append(l.globals, symbol.Index)
+	}
+
+	if len(c.constants)+len(program.Constants) > maxConstants {
+		return nil, c.errorf(token.Position{}, "too many constants")
+	}
+
+	constants := make([]object.Object, len(program.Constants))
+	for i, constant := range program.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			constants[i] = constant
+			continue
+		}
+
+		instructions, err := l.link(fn.Instructions)
+		if err != nil {
+			return nil, c.errorf(token.Position{}, "function %d: %s", i, err)
+		}
+
+		linked := *fn
+		linked.Instructions = instructions
+		constants[i] = &linked
+	}
+
+	instructions, err := l.link(program.Instructions)
+	if err != nil {
+		return nil, c.errorf(token.Position{}, "%s", err)
+	}
+
+	c.constants = append(c.constants, constants...)
+
+	return &Bytecode{
+		Instructions: instructions,
+		Lines:        program.Lines,
+		Constants:    c.constants,
+		Globals:      global.GlobalNames(),
+		Builtins:     global.BuiltinNames(),
+	}, nil
+}
+
+// link returns a copy of ins with its operands renumbered. Instructions
+// keep their width, so line tables and jumps stay valid.
+func (l *linker) link(ins code.Instructions) (code.Instructions, error) {
+	linked := slices.Clone(ins)
+
+	for i := 0; i < len(linked); {
+		def, err := code.Lookup(linked[i])
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", i, err)
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+
+		if i+1+width > len(linked) {
+			return nil, fmt.Errorf("offset %d: %s truncated", i, def.Name)
+		}
+
+		op := code.Opcode(linked[i])
+		operands, _ := code.ReadOperands(def, linked[i+1:])
+
+		switch op {
+		case code.OpGetGlobal, code.OpSetGlobal, code.OpGetGlobalIfBound:
+			operands[0], err = renumber(l.globals, operands[0], "global")
+		case code.OpGetBuiltin:
+			operands[0], err = l.builtin(operands[0])
+		case code.OpConstant, code.OpClosure:
+			operands[0] += l.constants
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("offset %d: %w", i, err)
+		}
+
+		copy(linked[i:], code.Make(op, operands...))
+		i += 1 + width
+	}
+
+	return linked, nil
+}
+
+// builtin returns the index in l.global of the builtin program refers to
+// by index, adding it to the builtins of l.global when it is missing. It is
+// not bound to its name there, which a global may be.
+func (l *linker) builtin(index int) (int, error) {
+	if index >= len(l.builtins) {
+		return 0, fmt.Errorf("builtin %d undefined", index)
+	}
+
+	name := l.builtins[index]
+
+	index = slices.Index(l.global.builtins, name)
+	if index < 0 {
+		index = len(l.global.builtins)
+		l.global.builtins = append(l.global.builtins, name)
+	}
+
+	if index >= maxBuiltins {
+		return 0, fmt.Errorf("too many builtins")
+	}
+
+	return index, nil
+}
+
+func renumber(indexes []int, index int, what string) (int, error) {
+	if index >= len(indexes) {
+		return 0, fmt.Errorf("%s %d undefined", what, index)
+	}
+
+	return indexes[index], nil
+}
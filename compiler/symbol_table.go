@@ -0,0 +1,291 @@
+package compiler
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	BuiltinScope SymbolScope = "BUILTIN"
+	FreeScope    SymbolScope = "FREE"
+	// CellScope is that of locals that closures capture, which are held
+	// in cells so that the closures see the values they are bound to
+	// later on. Free variables are always cells.
+	CellScope SymbolScope = "CELL"
+)
+
+type (
+	SymbolScope string
+
+	// A Symbol is the slot a name refers to. Outer is set when the slot
+	// may not be bound yet when the name is looked up, for names that a
+	// function refers to before the scope around it binds them; the name
+	// then refers to Outer until it is.
+	Symbol struct {
+		Name  string
+		Scope SymbolScope
+		Index int
+		Outer *Symbol
+	}
+
+	// A SymbolTable resolves the names of one scope. The table of a block
+	// shares the slots of the function, or the globals, it belongs to, but
+	// its names go out of scope at its end, like the environment the
+	// evaluator creates for the block.
+	SymbolTable struct {
+		Outer       *SymbolTable
+		FreeSymbols []Symbol
+
+		store map[string]Symbol
+		// pending holds the names Declare reserved slots for that are not
+		// bound yet.
+		pending map[string]Symbol
+		owner   *SymbolTable
+		// numDefinitions is the number of slots in use; maxDefinitions
+		// the number of slots the owner needs for all of its blocks.
+		numDefinitions int
+		maxDefinitions int
+
+		// Only the global table has names, the names of all globals and
+		// builtins by index.
+		globals  []string
+		builtins []string
+	}
+)
+
+func NewSymbolTable() *SymbolTable {
+	s := &SymbolTable{store: make(map[string]Symbol), pending: make(map[string]Symbol)}
+	s.owner = s
+
+	return s
+}
+
+// NewEnclosedSymbolTable creates the table of a function inside outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+
+	return s
+}
+
+// NewBlockSymbolTable creates the table of a block inside outer.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{
+		Outer:          outer,
+		store:          make(map[string]Symbol),
+		pending:        make(map[string]Symbol),
+		owner:          outer.owner,
+		numDefinitions: outer.numDefinitions,
+	}
+}
+
+func (s *SymbolTable) isBlock() bool {
+	return s.owner != s
+}
+
+func (s *SymbolTable) scope() SymbolScope {
+	if s.owner.Outer == nil {
+		return GlobalScope
+	}
+
+	return LocalScope
+}
+
+// Define binds name in s. Redefining a name of the same scope, or one
+// Declare reserved a slot for, keeps its slot, so that closures that refer
+// to it see the new value, as they do in the evaluator.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.pending[name]; ok {
+		delete(s.pending, name)
+		s.store[name] = symbol
+		return symbol
+	}
+
+	if symbol, ok := s.store[name]; ok && s.owns(symbol) {
+		return symbol
+	}
+
+	return s.define(name)
+}
+
+// Declare reserves a slot for name, which a let statement of s binds later
+// on: a cell for a local, which closures capture. Until Define binds it,
+// the code of s refers to the name as if s did not bind it, while the
+// functions defined in s, which may run after it is bound, refer to the
+// slot and look further out while it is not bound. It reports whether the
+// slot is new, rather than one s already binds or reserved.
+func (s *SymbolTable) Declare(name string) (Symbol, bool) {
+	if symbol, ok := s.pending[name]; ok {
+		return symbol, false
+	}
+
+	if symbol, ok := s.store[name]; ok && s.owns(symbol) {
+		return symbol, false
+	}
+
+	symbol := s.define(name)
+	delete(s.store, name)
+
+	if symbol.Scope == LocalScope {
+		symbol.Scope = CellScope
+	}
+	s.pending[name] = symbol
+
+	return symbol, true
+}
+
+// MakeCell holds the local name of s, a parameter, in a cell from now on.
+func (s *SymbolTable) MakeCell(name string) Symbol {
+	symbol := s.store[name]
+	symbol.Scope = CellScope
+	s.store[name] = symbol
+
+	return symbol
+}
+
+// owns reports whether symbol is a slot of s, rather than one of an
+// enclosing scope.
+func (s *SymbolTable) owns(symbol Symbol) bool {
+	switch symbol.Scope {
+	case FreeScope, BuiltinScope:
+		return false
+	}
+
+	return true
+}
+
+// DefineParameter binds name to the next slot even if s already binds it;
+// every parameter takes up a slot of its own.
+func (s *SymbolTable) DefineParameter(name string) Symbol {
+	return s.define(name)
+}
+
+// define binds name to a new slot. Blocks inside functions reuse the slots
+// of the blocks that went before them, but globals are looked up when a
+// function runs rather than captured when it is created, so those of
+// blocks at the top level never are.
+func (s *SymbolTable) define(name string) Symbol {
+	index := s.numDefinitions
+	if s.scope() == GlobalScope {
+		index = s.owner.maxDefinitions
+	}
+
+	symbol := Symbol{Name: name, Index: index, Scope: s.scope()}
+	s.store[name] = symbol
+	s.numDefinitions = index + 1
+
+	// Only the globals of the top level can be referred to by name, so
+	// those of blocks are left unnamed.
+	if symbol.Scope == GlobalScope {
+		if s.isBlock() {
+			name = ""
+		}
+		s.owner.globals = append(s.owner.globals, name)
+	}
+
+	if s.numDefinitions > s.owner.maxDefinitions {
+		s.owner.maxDefinitions = s.numDefinitions
+	}
+
+	return symbol
+}
+
+// DefineBuiltin binds name to the next builtin. Only the global table may
+// define builtins.
+func (s *SymbolTable) DefineBuiltin(name string) Symbol {
+	symbol := Symbol{Name: name, Index: len(s.builtins), Scope: BuiltinScope}
+	s.store[name] = symbol
+	s.builtins = append(s.builtins, name)
+
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol, outer *Symbol) Symbol {
+	original.Outer = nil
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Outer: outer}
+	s.store[original.Name] = symbol
+
+	return symbol
+}
+
+// NumDefinitions returns the number of slots s and all of its blocks need.
+func (s *SymbolTable) NumDefinitions() int {
+	return s.owner.maxDefinitions
+}
+
+// Global returns the global table s belongs to.
+func (s *SymbolTable) Global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+
+	return s
+}
+
+// GlobalNames returns the names of the globals s.Global defines, by index.
+// Globals of blocks have no name.
+func (s *SymbolTable) GlobalNames() []string {
+	return s.Global().globals
+}
+
+// BuiltinNames returns the names of the builtins s.Global defines, by
+// index.
+func (s *SymbolTable) BuiltinNames() []string {
+	return s.Global().builtins
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+// resolve looks name up from s, or, when nested is set, from a function
+// defined in s, which may refer to the names s has declared but not bound
+// yet.
+func (s *SymbolTable) resolve(name string, nested bool) (Symbol, bool) {
+	if symbol, ok := s.store[name]; ok {
+		return symbol, true
+	}
+
+	if symbol, ok := s.pending[name]; ok && nested {
+		outer, ok := s.resolveOuter(name, true)
+		if !ok {
+			// As for any name that is not bound, the VM reports it
+			// if it is not bound by the time it is looked up.
+			outer = s.Global().Define(name)
+		}
+		symbol.Outer = &outer
+
+		return symbol, true
+	}
+
+	return s.resolveOuter(name, nested)
+}
+
+// resolveOuter looks name up in the scopes around s.
+func (s *SymbolTable) resolveOuter(name string, nested bool) (Symbol, bool) {
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	symbol, ok := s.Outer.resolve(name, nested || !s.isBlock())
+	if !ok || s.isBlock() {
+		return symbol, ok
+	}
+
+	return s.capture(symbol), true
+}
+
+// capture returns the symbol by which the function of s refers to symbol,
+// of the scope around it.
+func (s *SymbolTable) capture(symbol Symbol) Symbol {
+	if symbol.Scope == GlobalScope || symbol.Scope == BuiltinScope {
+		return symbol
+	}
+
+	var outer *Symbol
+	if symbol.Outer != nil {
+		captured := s.capture(*symbol.Outer)
+		outer = &captured
+	}
+
+	return s.defineFree(symbol, outer)
+}
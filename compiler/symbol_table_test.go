@@ -0,0 +1,122 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SymbolTableTestSuite struct {
+	suite.Suite
+}
+
+func TestSymbolTableTestSuite(t *testing.T) {
+	suite.Run(t, new(SymbolTableTestSuite))
+}
+
+func (s *SymbolTableTestSuite) TestDefineResolve() {
+	global := NewSymbolTable()
+	s.Equal(Symbol{Name: "a", Scope: GlobalScope, Index: 0}, global.Define("a"))
+	s.Equal(Symbol{Name: "b", Scope: GlobalScope, Index: 1}, global.Define("b"))
+	s.Equal(Symbol{Name: "a", Scope: GlobalScope, Index: 0}, global.Define("a"), "redefinitions keep their slot")
+
+	local := NewEnclosedSymbolTable(global)
+	s.Equal(Symbol{Name: "c", Scope: LocalScope, Index: 0}, local.Define("c"))
+	s.Equal(Symbol{Name: "a", Scope: LocalScope, Index: 1}, local.Define("a"), "locals shadow globals")
+
+	nested := NewEnclosedSymbolTable(local)
+	s.Equal(Symbol{Name: "d", Scope: LocalScope, Index: 0}, nested.Define("d"))
+
+	expectations := []struct {
+		Name     string
+		Expected Symbol
+	}{
+		{"a", Symbol{Name: "a", Scope: FreeScope, Index: 0}},
+		{"b", Symbol{Name: "b", Scope: GlobalScope, Index: 1}},
+		{"c", Symbol{Name: "c", Scope: FreeScope, Index: 1}},
+		{"d", Symbol{Name: "d", Scope: LocalScope, Index: 0}},
+	}
+
+	for _, e := range expectations {
+		symbol, ok := nested.Resolve(e.Name)
+		s.True(ok, e.Name)
+		s.Equal(e.Expected, symbol, e.Name)
+	}
+
+	s.Equal([]Symbol{{Name: "a", Scope: LocalScope, Index: 1}, {Name: "c", Scope: LocalScope, Index: 0}}, nested.FreeSymbols)
+
+	_, ok := nested.Resolve("e")
+	s.False(ok)
+}
+
+func (s *SymbolTableTestSuite) TestParameters() {
+	local := NewEnclosedSymbolTable(NewSymbolTable())
+
+	local.DefineParameter("a")
+	s.Equal(Symbol{Name: "a", Scope: LocalScope, Index: 1}, local.DefineParameter("a"))
+	s.Equal(2, local.NumDefinitions())
+}
+
+func (s *SymbolTableTestSuite) TestBlocks() {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	block := NewBlockSymbolTable(global)
+	s.Equal(Symbol{Name: "a", Scope: GlobalScope, Index: 1}, block.Define("a"))
+	s.Equal(Symbol{Name: "b", Scope: GlobalScope, Index: 2}, global.Define("b"), "globals of blocks keep their slots")
+	s.Equal([]string{"a", "", "b"}, global.GlobalNames())
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("x")
+
+	first := NewBlockSymbolTable(local)
+	s.Equal(Symbol{Name: "y", Scope: LocalScope, Index: 1}, first.Define("y"))
+	s.Equal(Symbol{Name: "z", Scope: LocalScope, Index: 2}, NewBlockSymbolTable(first).Define("z"))
+
+	second := NewBlockSymbolTable(local)
+	s.Equal(Symbol{Name: "w", Scope: LocalScope, Index: 1}, second.Define("w"), "locals of blocks reuse slots")
+	s.Equal(3, local.NumDefinitions())
+
+	_, ok := local.Resolve("y")
+	s.False(ok)
+
+	inner := NewEnclosedSymbolTable(NewBlockSymbolTable(local))
+	symbol, ok := NewBlockSymbolTable(inner).Resolve("x")
+	s.True(ok)
+	s.Equal(Symbol{Name: "x", Scope: FreeScope, Index: 0}, symbol)
+	s.Equal([]Symbol{{Name: "x", Scope: LocalScope, Index: 0}}, inner.FreeSymbols)
+}
+
+func (s *SymbolTableTestSuite) TestDeclare() {
+	global := NewSymbolTable()
+	local := NewEnclosedSymbolTable(global)
+
+	symbol, ok := local.Declare("x")
+	s.True(ok)
+	s.Equal(Symbol{Name: "x", Scope: CellScope, Index: 0}, symbol)
+
+	_, ok = local.Resolve("x")
+	s.False(ok, "the code of the scope does not see names it has not bound yet")
+
+	nested, ok := NewEnclosedSymbolTable(local).Resolve("x")
+	s.True(ok)
+	s.Equal(Symbol{Name: "x", Scope: FreeScope, Index: 0, Outer: &Symbol{Name: "x", Scope: GlobalScope, Index: 0}}, nested)
+
+	s.Equal(Symbol{Name: "x", Scope: CellScope, Index: 0}, local.Define("x"))
+	s.Equal(1, local.NumDefinitions())
+
+	nested, _ = NewEnclosedSymbolTable(local).Resolve("x")
+	s.Equal(Symbol{Name: "x", Scope: FreeScope, Index: 0}, nested, "bound names are bound for good")
+}
+
+func (s *SymbolTableTestSuite) TestBuiltins() {
+	global := NewGlobalSymbolTable([]string{"len", "puts"})
+	local := NewEnclosedSymbolTable(global)
+
+	symbol, ok := local.Resolve("puts")
+	s.True(ok)
+	s.Equal(Symbol{Name: "puts", Scope: BuiltinScope, Index: 1}, symbol)
+
+	s.Equal(Symbol{Name: "len", Scope: GlobalScope, Index: 0}, global.Define("len"), "globals shadow builtins")
+	s.Equal([]string{"len", "puts"}, local.BuiltinNames())
+}
@@ -0,0 +1,249 @@
+// Package cst holds concrete syntax trees: trees of every token of a
+// source, with the whitespace before it, so that the text of a tree is the
+// source it was parsed from, byte for byte. The parser builds one
+// alongside the AST with parser.WithSyntaxTree.
+//
+// A tree is made of two layers. Green nodes are immutable and know their
+// text and width but not where they are, so that they can be shared by
+// trees and a rewritten tree reuses every node that did not change. A Node
+// wraps a green node with its parent and offset in the source, and is
+// created as the tree is navigated.
+package cst
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcel/monkey/token"
+)
+
+const (
+	// Token is the kind of the leaves of a tree.
+	Token Kind = iota
+
+	Program
+	LetStatement
+	ReturnStatement
+	ExpressionStatement
+	BlockStatement
+	Identifier
+	IntegerLiteral
+	StringLiteral
+	Boolean
+	PrefixExpression
+	InfixExpression
+	GroupedExpression
+	IfExpression
+	FunctionLiteral
+	CallExpression
+	ArrayLiteral
+	HashLiteral
+	IndexExpression
+
+	numKinds
+)
+
+var kindNames = [numKinds]string{
+	Token:               "Token",
+	Program:             "Program",
+	LetStatement:        "LetStatement",
+	ReturnStatement:     "ReturnStatement",
+	ExpressionStatement: "ExpressionStatement",
+	BlockStatement:      "BlockStatement",
+	Identifier:          "Identifier",
+	IntegerLiteral:      "IntegerLiteral",
+	StringLiteral:       "StringLiteral",
+	Boolean:             "Boolean",
+	PrefixExpression:    "PrefixExpression",
+	InfixExpression:     "InfixExpression",
+	GroupedExpression:   "GroupedExpression",
+	IfExpression:        "IfExpression",
+	FunctionLiteral:     "FunctionLiteral",
+	CallExpression:      "CallExpression",
+	ArrayLiteral:        "ArrayLiteral",
+	HashLiteral:         "HashLiteral",
+	IndexExpression:     "IndexExpression",
+}
+
+type (
+	// A Kind tells nodes apart. The kinds of inner nodes are named after
+	// the AST nodes they stand for, GroupedExpression standing for the
+	// parentheses the AST leaves out.
+	Kind uint8
+
+	// A Green node is a token, with the whitespace before it, or a node
+	// of a kind and the nodes it is made of. It must not be modified.
+	Green struct {
+		kind     Kind
+		typ      token.Type
+		trivia   string
+		text     string
+		children []*Green
+		width    int
+	}
+
+	// A Builder builds a green tree from the tokens of a source, in
+	// order. Nodes are started before their first token or, for nodes
+	// whose first tokens are found to belong to them only later, at a
+	// checkpoint taken before them.
+	Builder struct {
+		frames []frame
+	}
+
+	frame struct {
+		kind     Kind
+		children []*Green
+	}
+)
+
+func (k Kind) String() string {
+	if k < numKinds {
+		return kindNames[k]
+	}
+
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// NewToken returns a token of type t, written as text after the
+// whitespace trivia.
+func NewToken(t token.Type, trivia, text string) *Green {
+	return &Green{kind: Token, typ: t, trivia: trivia, text: text, width: len(trivia) + len(text)}
+}
+
+// NewNode returns a node of kind made of children.
+func NewNode(kind Kind, children ...*Green) *Green {
+	g := &Green{kind: kind, children: children}
+	for _, child := range children {
+		g.width += child.width
+	}
+
+	return g
+}
+
+func (g *Green) Kind() Kind {
+	return g.kind
+}
+
+// Type returns the type of a token, and ILLEGAL for other nodes.
+func (g *Green) Type() token.Type {
+	return g.typ
+}
+
+// Trivia returns the whitespace before a token, or before the first token
+// of a node.
+func (g *Green) Trivia() string {
+	for g.kind != Token {
+		if len(g.children) == 0 {
+			return ""
+		}
+		g = g.children[0]
+	}
+
+	return g.trivia
+}
+
+// Children returns the nodes g is made of, which must not be modified.
+func (g *Green) Children() []*Green {
+	return g.children
+}
+
+// Width returns the length of the text of g.
+func (g *Green) Width() int {
+	return g.width
+}
+
+// Text returns the source g was built from.
+func (g *Green) Text() string {
+	var out strings.Builder
+	out.Grow(g.width)
+	g.write(&out)
+
+	return out.String()
+}
+
+func (g *Green) write(out *strings.Builder) {
+	if g.kind == Token {
+		out.WriteString(g.trivia)
+		out.WriteString(g.text)
+		return
+	}
+
+	for _, child := range g.children {
+		child.write(out)
+	}
+}
+
+// with returns a copy of g with its ith child replaced.
+func (g *Green) with(i int, child *Green) *Green {
+	children := make([]*Green, len(g.children))
+	copy(children, g.children)
+	children[i] = child
+
+	return NewNode(g.kind, children...)
+}
+
+// Token adds a token to the node being built.
+func (b *Builder) Token(t token.Type, trivia, text string) {
+	b.add(NewToken(t, trivia, text))
+}
+
+// StartNode starts a node of kind, which the tokens and nodes that follow
+// belong to until it is finished.
+func (b *Builder) StartNode(kind Kind) {
+	b.frames = append(b.frames, frame{kind: kind})
+}
+
+// Checkpoint returns a checkpoint before what follows, to start a node at
+// later.
+func (b *Builder) Checkpoint() int {
+	if len(b.frames) == 0 {
+		return 0
+	}
+
+	return len(b.frames[len(b.frames)-1].children)
+}
+
+// StartNodeAt starts a node of kind at checkpoint, which the tokens and
+// nodes added since belong to as well. The node being built must be the
+// one checkpoint was taken in.
+func (b *Builder) StartNodeAt(checkpoint int, kind Kind) {
+	top := &b.frames[len(b.frames)-1]
+	children := append([]*Green(nil), top.children[checkpoint:]...)
+	top.children = top.children[:checkpoint]
+
+	b.frames = append(b.frames, frame{kind: kind, children: children})
+}
+
+// FinishNode finishes the node started last.
+func (b *Builder) FinishNode() {
+	top := b.frames[len(b.frames)-1]
+	b.frames = b.frames[:len(b.frames)-1]
+
+	b.add(NewNode(top.kind, top.children...))
+}
+
+// Finish finishes the nodes that are not finished yet and returns the
+// outermost, a Program if no node was started.
+func (b *Builder) Finish() *Green {
+	for len(b.frames) > 1 {
+		b.FinishNode()
+	}
+
+	if len(b.frames) == 0 {
+		return NewNode(Program)
+	}
+
+	root := b.frames[0]
+	b.frames = nil
+
+	return NewNode(root.kind, root.children...)
+}
+
+func (b *Builder) add(g *Green) {
+	if len(b.frames) == 0 {
+		b.StartNode(Program)
+	}
+
+	top := &b.frames[len(b.frames)-1]
+	top.children = append(top.children, g)
+}
@@ -0,0 +1,114 @@
+package cst
+
+import (
+	"testing"
+
+	"github.com/marcel/monkey/token"
+	"github.com/stretchr/testify/suite"
+)
+
+type CSTTestSuite struct {
+	suite.Suite
+}
+
+func TestCSTTestSuite(t *testing.T) {
+	suite.Run(t, new(CSTTestSuite))
+}
+
+// build returns the tree of "a + (b)", built as the parser builds it.
+func build() *Green {
+	b := &Builder{}
+	b.StartNode(Program)
+
+	mark := b.Checkpoint()
+	b.Token(token.IDENT, "", "a")
+	b.StartNodeAt(mark, Identifier)
+	b.FinishNode()
+
+	b.Token(token.PLUS, " ", "+")
+	b.StartNodeAt(mark, InfixExpression)
+	b.StartNode(GroupedExpression)
+	b.Token(token.LPAREN, " ", "(")
+	b.StartNode(Identifier)
+	b.Token(token.IDENT, "", "b")
+	b.FinishNode()
+	b.Token(token.RPAREN, "", ")")
+
+	return b.Finish()
+}
+
+func (s *CSTTestSuite) TestBuilder() {
+	g := build()
+
+	s.Equal(Program, g.Kind())
+	s.Equal("a + (b)", g.Text())
+	s.Equal(7, g.Width())
+	s.Require().Len(g.Children(), 1)
+
+	infix := g.Children()[0]
+	s.Equal(InfixExpression, infix.Kind())
+	s.Equal([]Kind{Identifier, Token, GroupedExpression}, kinds(infix.Children()))
+	s.Equal(token.PLUS, infix.Children()[1].Type())
+	s.Equal(" ", infix.Children()[2].Trivia())
+	s.Equal("", infix.Trivia())
+
+	empty := &Builder{}
+	s.Equal(Program, empty.Finish().Kind())
+}
+
+func (s *CSTTestSuite) TestKindString() {
+	for k := range numKinds {
+		s.NotEmpty(k.String())
+	}
+
+	s.Equal("IfExpression", IfExpression.String())
+	s.Equal("Kind(99)", Kind(99).String())
+}
+
+func (s *CSTTestSuite) TestNode() {
+	root := NewRoot(build())
+
+	var texts []string
+	var offsets []int
+	for tok := range root.Tokens() {
+		texts = append(texts, tok.Text())
+		offsets = append(offsets, tok.Offset())
+	}
+	s.Equal([]string{"a", " +", " (", "b", ")"}, texts)
+	s.Equal([]int{0, 1, 3, 5, 6}, offsets)
+
+	b := root.TokenAt(5)
+	s.Equal("b", b.Text())
+	s.Equal(Identifier, b.Parent().Kind())
+	s.Equal(GroupedExpression, b.Parent().Parent().Kind())
+	s.Same(root, b.Root())
+	s.Equal(6, b.End())
+
+	s.Nil(root.TokenAt(1), "trivia")
+	s.Nil(root.TokenAt(7))
+}
+
+func (s *CSTTestSuite) TestReplace() {
+	root := NewRoot(build())
+	a := root.TokenAt(0).Parent()
+
+	replaced := a.Replace(NewNode(Identifier, NewToken(token.IDENT, "", "total")))
+	s.Equal("total", replaced.Text())
+	s.Equal("total + (b)", replaced.Root().Text())
+	s.Equal("a + (b)", root.Text())
+
+	b := replaced.Root().TokenAt(9)
+	s.Equal("b", b.Text())
+	s.Same(root.TokenAt(5).Green(), b.Green())
+
+	s.Equal("x", root.Replace(NewToken(token.IDENT, "", "x")).Text())
+}
+
+func kinds(children []*Green) []Kind {
+	k := make([]Kind, len(children))
+	for i, child := range children {
+		k[i] = child.Kind()
+	}
+
+	return k
+}
@@ -0,0 +1,137 @@
+package cst
+
+import (
+	"iter"
+
+	"github.com/marcel/monkey/token"
+)
+
+// A Node is a green node where it is in a tree: under its parent, at an
+// offset in the text of the tree.
+type Node struct {
+	green  *Green
+	parent *Node
+	index  int
+	offset int
+}
+
+// NewRoot returns the root of the tree of g.
+func NewRoot(g *Green) *Node {
+	return &Node{green: g}
+}
+
+func (n *Node) Green() *Green {
+	return n.green
+}
+
+func (n *Node) Kind() Kind {
+	return n.green.kind
+}
+
+// Type returns the type of a token, and ILLEGAL for other nodes.
+func (n *Node) Type() token.Type {
+	return n.green.typ
+}
+
+// Parent returns the node n is a child of, or nil for the root.
+func (n *Node) Parent() *Node {
+	return n.parent
+}
+
+// Root returns the root of the tree of n.
+func (n *Node) Root() *Node {
+	for n.parent != nil {
+		n = n.parent
+	}
+
+	return n
+}
+
+// Offset returns where n starts in the text of its tree, trivia included.
+func (n *Node) Offset() int {
+	return n.offset
+}
+
+// End returns where n ends in the text of its tree.
+func (n *Node) End() int {
+	return n.offset + n.green.width
+}
+
+// Text returns the text of n, trivia included.
+func (n *Node) Text() string {
+	return n.green.Text()
+}
+
+// Children returns the nodes n is made of.
+func (n *Node) Children() []*Node {
+	children := make([]*Node, len(n.green.children))
+
+	offset := n.offset
+	for i, g := range n.green.children {
+		children[i] = &Node{green: g, parent: n, index: i, offset: offset}
+		offset += g.width
+	}
+
+	return children
+}
+
+// Tokens returns the tokens of n in order.
+func (n *Node) Tokens() iter.Seq[*Node] {
+	return func(yield func(*Node) bool) {
+		n.tokens(yield)
+	}
+}
+
+func (n *Node) tokens(yield func(*Node) bool) bool {
+	if n.green.kind == Token {
+		return yield(n)
+	}
+
+	for _, child := range n.Children() {
+		if !child.tokens(yield) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// TokenAt returns the token whose text, trivia excluded, holds the byte at
+// offset, or nil if there is none. A token of the AST is found at its
+// Position.Offset, and the node of an AST node is mostly the parent of its
+// token: the statement of an ExpressionStatement is the parent of the node
+// of its expression.
+func (n *Node) TokenAt(offset int) *Node {
+	for n.green.kind != Token {
+		var next *Node
+		for _, child := range n.Children() {
+			if offset < child.End() {
+				next = child
+				break
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+
+	if offset < n.offset+len(n.green.trivia) {
+		return nil
+	}
+
+	return n
+}
+
+// Replace returns the node of g in a new tree, in which g takes the place
+// of n and all else is as in the tree of n. The tree of n is not changed.
+func (n *Node) Replace(g *Green) *Node {
+	if n.parent == nil {
+		return NewRoot(g)
+	}
+
+	parent := n.parent.Replace(n.parent.green.with(n.index, g))
+
+	return &Node{green: g, parent: parent, index: n.index, offset: n.offset}
+}
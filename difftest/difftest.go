@@ -0,0 +1,126 @@
+// Package difftest checks that the tree-walking evaluator and the VM agree.
+// It runs programs with both engines and compares what they produce:
+// values, printed output and the kinds of the errors they fail with.
+// Programs come from a corpus or from a Generator, and a program on which
+// the engines disagree is reduced with Minimize before it is reported.
+//
+// Positions and messages of errors are not compared.
+package difftest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/evaluator"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/vm"
+)
+
+type (
+	// An Outcome is what running a program produced. Value is the result,
+	// Output what the program wrote with puts and eputs, and Error the kind
+	// of runtime error it failed with, if any. Panic is set when the engine
+	// panicked, and Skipped when the VM could not compile the program.
+	Outcome struct {
+		Value   string
+		Output  string
+		Error   string
+		Panic   string
+		Skipped string
+	}
+
+	// A Divergence is a program on which the engines disagree.
+	Divergence struct {
+		Program   *ast.Program
+		Evaluator Outcome
+		VM        Outcome
+	}
+)
+
+// Evaluate runs program with the tree-walking evaluator.
+func Evaluate(program *ast.Program) (outcome Outcome) {
+	var out bytes.Buffer
+	defer recoverPanic(&outcome)
+
+	e := evaluator.New()
+	e.Builtins = object.CoreBuiltins(&out, &out, e.Alloc)
+
+	return newOutcome(e.Eval(program, e.Globals), &out)
+}
+
+// Execute compiles program and runs it on the VM.
+func Execute(program *ast.Program) (outcome Outcome) {
+	var out bytes.Buffer
+	defer recoverPanic(&outcome)
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return Outcome{Skipped: err.Error()}
+	}
+
+	machine := vm.New(c.Bytecode())
+	machine.Builtins = object.CoreBuiltins(&out, &out, machine.Alloc)
+
+	return newOutcome(machine.Run(), &out)
+}
+
+// Compare runs program with both engines and returns how they disagree,
+// or nil when they agree or the VM cannot compile the program.
+func Compare(program *ast.Program) *Divergence {
+	evaluated, executed := Evaluate(program), Execute(program)
+	if evaluated == executed || executed.Skipped != "" {
+		return nil
+	}
+
+	return &Divergence{Program: program, Evaluator: evaluated, VM: executed}
+}
+
+// Diverges reports whether the engines disagree on program.
+func Diverges(program *ast.Program) bool {
+	return Compare(program) != nil
+}
+
+func newOutcome(result object.Object, out *bytes.Buffer) Outcome {
+	outcome := Outcome{Output: out.String()}
+
+	switch result := result.(type) {
+	case nil:
+		outcome.Value = object.NULL.Inspect()
+	case *object.Error:
+		outcome.Error = result.Kind.String()
+	default:
+		outcome.Value = result.Inspect()
+	}
+
+	return outcome
+}
+
+func recoverPanic(outcome *Outcome) {
+	if r := recover(); r != nil {
+		*outcome = Outcome{Panic: fmt.Sprint(r)}
+	}
+}
+
+func (o Outcome) String() string {
+	switch {
+	case o.Panic != "":
+		return "panic: " + o.Panic
+	case o.Skipped != "":
+		return "skipped: " + o.Skipped
+	case o.Error != "":
+		return fmt.Sprintf("%s, output %q", o.Error, o.Output)
+	}
+
+	return fmt.Sprintf("%s, output %q", o.Value, o.Output)
+}
+
+func (d *Divergence) Error() string {
+	return fmt.Sprintf(
+		"engines disagree on:\n%s\nevaluator: %s\nvm:        %s",
+		Format(d.Program),
+		d.Evaluator,
+		d.VM,
+	)
+}
@@ -0,0 +1,245 @@
+package difftest
+
+import (
+	"testing"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/parser"
+	"github.com/stretchr/testify/suite"
+)
+
+type DiffTestSuite struct {
+	suite.Suite
+}
+
+func TestDiffTestSuite(t *testing.T) {
+	suite.Run(t, new(DiffTestSuite))
+}
+
+func (s *DiffTestSuite) TestCorpus() {
+	corpus := []string{
+		`let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(15)`,
+		`let adder = fn(a) { fn(b) { a + b } }; let addTwo = adder(2); addTwo(3)`,
+		`let map = fn(arr, f) { if (len(arr) == 0) { [] } else { push(map(rest(arr), f), f(first(arr))) } }; map([1, 2, 3], fn(x) { x * x })`,
+		`let h = {"one": 1, "two": 2, true: 3, 4: 4}; [h["one"], h["two"], h[true], h[4], h["five"]]`,
+		`let s = "mon" + "key"; puts(s, len(s), str(len(s))); type(s)`,
+		`eputs([1, [2, 3]], {"a": [1]}); puts(int("42") + 1)`,
+		`let x = if (false) { 1 }; puts(x); x`,
+		`let f = fn() { if (true) { if (true) { return 1; } 2 } 3 }; f()`,
+		`[1, 2, 3][3]`,
+		`[1, 2, 3][-1]`,
+		`9223372036854775807 + 1`,
+		`!0 == !1`,
+		`if (1) { "truthy" } else { "falsy" }`,
+		`if ([]) { "truthy" } else { "falsy" }`,
+		`puts("before"); 1 / 0; puts("after")`,
+		`"a" - "b"`,
+		`-true`,
+		`{[1]: 2}`,
+		`{[1]: 1 / 0}`,
+		`{[1]: puts("x")}`,
+		`{[1]: 1, 2: puts("x"), 3: 1 / 0}`,
+		`{"a": puts("x"), fn(x) { x }: puts("y")}`,
+		`{"a": 1}[fn(x) { x }]`,
+		`5()`,
+		`fn(x) { x }(1, 2)`,
+		`len(1)`,
+		`first("a")`,
+		`int("one")`,
+		`let f = fn(x) { x }; f`,
+		`puts(fn(x) { x })`,
+		`str(fn(x) { x })`,
+		`str([1, fn(x) { x }])`,
+		`let f = fn(a, b) { let c = a * b; if (c > 1) { c } else { -c } }; puts(f, {"f": f}); f`,
+		`let f = fn() { let g = fn() { h() }; let h = fn() { 1 }; g() }; f()`,
+		`let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()`,
+		`let x = "outer"; let f = fn() { let g = fn() { x }; let a = g(); let x = "inner"; [a, g()] }; f()`,
+		`let f = fn() { let g = fn() { h }; let a = g(); let h = 1; a }; f()`,
+		`let f = fn(x) { let g = fn() { x }; let x = x + 1; g() }; f(1)`,
+		`let f = fn() { if (true) { let g = fn() { y }; let y = 3; g() } }; f()`,
+		`if (true) { let g = fn() { h() }; let h = fn() { 2 }; g() }`,
+		`let f = fn() { f }; let g = f; let f = 1; g()`,
+		`let len = fn(x) { 0 }; len("abc")`,
+		`len`,
+		`return 5; 10`,
+		``,
+	}
+
+	for _, input := range corpus {
+		program := parse(s, input)
+
+		evaluated := Evaluate(program)
+		s.Empty(evaluated.Panic, input)
+
+		executed := Execute(program)
+		s.Empty(executed.Skipped, input)
+
+		s.Nil(Compare(program), input)
+	}
+}
+
+func (s *DiffTestSuite) TestFunctionValues() {
+	program := parse(s, `let f = fn(x, y) { x + y }; puts(f); [f]`)
+
+	evaluated, executed := Evaluate(program), Execute(program)
+	s.Equal("fn(x, y) {\n(x + y)\n}\n", evaluated.Output)
+	s.Equal("[fn(x, y) {\n(x + y)\n}]", evaluated.Value)
+	s.Equal(evaluated, executed)
+}
+
+func (s *DiffTestSuite) TestGenerated() {
+	n := uint64(2000)
+	if testing.Short() {
+		n = 200
+	}
+
+	for seed := range n {
+		program := NewGenerator(seed).Program()
+
+		if d := Compare(program); d != nil {
+			Minimize(program, Diverges)
+			s.Failf("engines disagree", "seed %d: %v", seed, Compare(program))
+		}
+	}
+}
+
+func (s *DiffTestSuite) TestGeneratorFormat() {
+	for seed := range uint64(200) {
+		program := NewGenerator(seed).Program()
+		source := Format(program)
+
+		s.Equal(source, Format(parse(s, source)), "seed %d", seed)
+	}
+}
+
+func (s *DiffTestSuite) TestGeneratorVaries() {
+	outcomes := map[string]bool{}
+	for seed := range uint64(200) {
+		outcome := Evaluate(NewGenerator(seed).Program())
+		s.Empty(outcome.Panic)
+
+		outcomes[outcome.Error] = true
+	}
+
+	s.True(outcomes[""], "no program succeeds")
+	s.Greater(len(outcomes), 2, "programs fail with too few kinds of errors")
+}
+
+func (s *DiffTestSuite) TestGeneratorCovers() {
+	var printsFunction, unusableKey, usedBeforeLet, reboundAfterCapture bool
+
+	for seed := range uint64(500) {
+		ast.Inspect(NewGenerator(seed).Program(), func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.CallExpression:
+				if name, ok := node.Function.(*ast.Identifier); ok && (name.Value == "puts" || name.Value == "str") {
+					for _, arg := range node.Arguments {
+						_, ok := arg.(*ast.FunctionLiteral)
+						printsFunction = printsFunction || ok
+					}
+				}
+			case *ast.HashLiteral:
+				for _, pair := range node.Pairs {
+					switch pair.Key.(type) {
+					case *ast.ArrayLiteral, *ast.HashLiteral, *ast.FunctionLiteral:
+						unusableKey = true
+					}
+				}
+			case *ast.FunctionLiteral:
+				before, after := capturedLocals(node)
+				usedBeforeLet = usedBeforeLet || before
+				reboundAfterCapture = reboundAfterCapture || after
+			}
+			return true
+		})
+	}
+
+	s.True(printsFunction, "no function is printed")
+	s.True(unusableKey, "no hash literal has an unusable key")
+	s.True(usedBeforeLet, "no nested function uses a local before its let")
+	s.True(reboundAfterCapture, "no local is rebound after a closure captures it")
+}
+
+// capturedLocals reports whether the body of fn binds a local after a
+// function it defines refers to it, and whether that local was bound
+// before, so that the let rebinds it.
+func capturedLocals(fn *ast.FunctionLiteral) (usedBeforeLet, reboundAfterCapture bool) {
+	bound := map[string]bool{}
+	for _, param := range fn.Parameters {
+		bound[param.Value] = true
+	}
+
+	captured := map[string]bool{}
+	for _, stmt := range fn.Body.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok {
+			continue
+		}
+
+		if wasBound, ok := captured[let.Name.Value]; ok {
+			usedBeforeLet = usedBeforeLet || !wasBound
+			reboundAfterCapture = reboundAfterCapture || wasBound
+		}
+
+		if nested, ok := let.Value.(*ast.FunctionLiteral); ok {
+			ast.Inspect(nested.Body, func(node ast.Node) bool {
+				if ident, ok := node.(*ast.Identifier); ok {
+					if _, ok := captured[ident.Value]; !ok {
+						captured[ident.Value] = bound[ident.Value]
+					}
+				}
+				return true
+			})
+		}
+
+		bound[let.Name.Value] = true
+	}
+
+	return usedBeforeLet, reboundAfterCapture
+}
+
+func (s *DiffTestSuite) TestFormat() {
+	expectations := []struct {
+		Input    string
+		Expected string
+	}{
+		{`let x = "a" + "b";`, `let x = ("a" + "b");`},
+		{"-1 * 2", "((-1) * 2);"},
+		{"f(1)[2](3)", "f(1)[2](3);"},
+		{"fn(x) { x }(1)", "(fn(x) { x; })(1);"},
+		{"if (a) { b } else { c; d }", "if (a) { b; } else { c; d; };"},
+		{"if (a) {}", "if (a) { };"},
+		{`{"a": [1, 2]}["a"][0]`, `{"a": [1, 2]}["a"][0];`},
+		{"return -x; 1", "return (-x);\n1;"},
+	}
+
+	for _, e := range expectations {
+		s.Equal(e.Expected, Format(parse(s, e.Input)), e.Input)
+	}
+}
+
+func (s *DiffTestSuite) TestMinimize() {
+	program := parse(s, `
+		let a = 1;
+		let b = [1, 2, 3];
+		puts(a + b[1]);
+		if (a < 2) { puts("x"); len(b) / (a - 1) } else { 5 }
+	`)
+
+	// A program fails while it divides something by 0.
+	fails := func(p *ast.Program) bool {
+		return Evaluate(p).Error == "division by zero"
+	}
+
+	s.Require().True(fails(program))
+	s.Equal("(0 / 0);", Format(Minimize(program, fails)))
+}
+
+func parse(s *DiffTestSuite, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	s.Require().Empty(p.Errors(), input)
+
+	return program
+}
@@ -0,0 +1,102 @@
+package difftest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/marcel/monkey/ast"
+)
+
+// Format returns Monkey source for node that parses back to the same
+// tree. Unlike the String methods of the ast package it quotes strings,
+// keeps the braces of blocks and parenthesizes every operator, so that
+// reported programs can be run as they are.
+func Format(node ast.Node) string {
+	switch node := node.(type) {
+	case *ast.Program:
+		return statements(node.Statements, "\n")
+	case *ast.BlockStatement:
+		if len(node.Statements) == 0 {
+			return "{ }"
+		}
+		return "{ " + statements(node.Statements, " ") + " }"
+	case *ast.LetStatement:
+		return fmt.Sprintf("let %s = %s;", node.Name.Value, Format(node.Value))
+	case *ast.ReturnStatement:
+		return fmt.Sprintf("return %s;", Format(node.ReturnValue))
+	case *ast.ExpressionStatement:
+		return Format(node.Expression) + ";"
+	case *ast.Identifier:
+		return node.Value
+	case *ast.IntegerLiteral:
+		if node.Value < 0 {
+			return fmt.Sprintf("(-%d)", -uint64(node.Value))
+		}
+		return strconv.FormatInt(node.Value, 10)
+	case *ast.StringLiteral:
+		return `"` + node.Value + `"`
+	case *ast.Boolean:
+		return strconv.FormatBool(node.Value)
+	case *ast.PrefixExpression:
+		return fmt.Sprintf("(%s%s)", node.Operator, Format(node.Right))
+	case *ast.InfixExpression:
+		return fmt.Sprintf("(%s %s %s)", Format(node.Left), node.Operator, Format(node.Right))
+	case *ast.IfExpression:
+		s := fmt.Sprintf("if (%s) %s", Format(node.Condition), Format(node.Consequence))
+		if node.Alternative != nil {
+			s += " else " + Format(node.Alternative)
+		}
+		return s
+	case *ast.FunctionLiteral:
+		params := make([]string, len(node.Parameters))
+		for i, p := range node.Parameters {
+			params[i] = p.Value
+		}
+		return fmt.Sprintf("fn(%s) %s", strings.Join(params, ", "), Format(node.Body))
+	case *ast.CallExpression:
+		return fmt.Sprintf("%s(%s)", callee(node.Function), expressions(node.Arguments))
+	case *ast.ArrayLiteral:
+		return "[" + expressions(node.Elements) + "]"
+	case *ast.HashLiteral:
+		pairs := make([]string, len(node.Pairs))
+		for i, p := range node.Pairs {
+			pairs[i] = Format(p.Key) + ": " + Format(p.Value)
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+	case *ast.IndexExpression:
+		return fmt.Sprintf("%s[%s]", callee(node.Left), Format(node.Index))
+	}
+
+	return fmt.Sprintf("<%T>", node)
+}
+
+// callee formats the left-hand side of a call or index expression, which
+// needs parentheses unless it binds tighter than them.
+func callee(node ast.Expression) string {
+	switch node.(type) {
+	case *ast.Identifier, *ast.CallExpression, *ast.IndexExpression,
+		*ast.ArrayLiteral, *ast.HashLiteral, *ast.StringLiteral:
+		return Format(node)
+	}
+
+	return "(" + Format(node) + ")"
+}
+
+func statements(stmts []ast.Statement, sep string) string {
+	s := make([]string, len(stmts))
+	for i, stmt := range stmts {
+		s[i] = Format(stmt)
+	}
+
+	return strings.Join(s, sep)
+}
+
+func expressions(exps []ast.Expression) string {
+	s := make([]string, len(exps))
+	for i, e := range exps {
+		s[i] = Format(e)
+	}
+
+	return strings.Join(s, ", ")
+}
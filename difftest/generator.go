@@ -0,0 +1,433 @@
+package difftest
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/token"
+)
+
+const (
+	intType valueType = iota
+	boolType
+	stringType
+	arrayType
+	hashType
+	functionType
+
+	numTypes
+)
+
+// printable are the types of the values programs print.
+var printable = []valueType{intType, boolType, stringType, arrayType, hashType, functionType}
+
+var hashKeys = []string{"a", "b", "c"}
+
+type (
+	// valueType is the type the generator means an expression to have.
+	// Arrays hold integers, hashes map strings to integers and functions
+	// take and return integers.
+	valueType int
+
+	// A binding is a name a scope binds. A later one is bound by a let
+	// statement that comes after the functions that refer to it, so only
+	// functions see it until then.
+	binding struct {
+		name  string
+		t     valueType
+		arity int
+		later bool
+	}
+
+	// A generatorScope holds the names bound by a program, a function or
+	// a block. Names other than those of functions may be bound again to
+	// other values of the same type, after closures have captured them.
+	generatorScope struct {
+		bindings []*binding
+		outer    *generatorScope
+		function bool
+	}
+
+	// A Generator produces random well-formed programs from the grammar of
+	// Monkey. Expressions are mostly well-typed, so that programs run for a
+	// while before they fail, if they fail at all; ErrorRate is the share of
+	// those that are not. Functions are never recursive, so every program
+	// terminates.
+	Generator struct {
+		MaxDepth      int
+		MaxStatements int
+		ErrorRate     float64
+
+		r     *rand.Rand
+		scope *generatorScope
+		names int
+	}
+)
+
+func NewGenerator(seed uint64) *Generator {
+	return &Generator{
+		MaxDepth:      4,
+		MaxStatements: 6,
+		ErrorRate:     0.02,
+		r:             rand.New(rand.NewPCG(seed, seed>>32|1)),
+	}
+}
+
+// Program returns a new random program.
+func (g *Generator) Program() *ast.Program {
+	g.scope = &generatorScope{}
+	g.names = 0
+
+	return &ast.Program{Statements: g.statements(intType, 0)}
+}
+
+// statements returns the statements of a program, function or block whose
+// value has type t, at the given depth of nesting.
+func (g *Generator) statements(t valueType, depth int) []ast.Statement {
+	var stmts []ast.Statement
+
+	for n := g.r.IntN(g.MaxStatements) / (depth + 1); n > 0; n-- {
+		stmts = append(stmts, g.statement(depth)...)
+	}
+
+	return append(stmts, &ast.ExpressionStatement{Expression: g.expression(t, depth)})
+}
+
+// statement returns a statement, or a few that belong together.
+func (g *Generator) statement(depth int) []ast.Statement {
+	switch n := g.r.IntN(20); {
+	case n < 9:
+		t := valueType(g.r.IntN(int(numTypes)))
+		if t == functionType {
+			return g.letFunction(depth)
+		}
+
+		value := g.expression(t, depth)
+
+		return []ast.Statement{&ast.LetStatement{Name: identifier(g.bind(t, 0)), Value: value}}
+	case n < 14:
+		return []ast.Statement{&ast.ExpressionStatement{Expression: g.call("puts", g.expression(g.printable(), depth+1))}}
+	case n < 19:
+		return []ast.Statement{&ast.ExpressionStatement{Expression: g.expression(g.printable(), depth)}}
+	}
+
+	return []ast.Statement{&ast.ReturnStatement{ReturnValue: g.expression(intType, depth)}}
+}
+
+// letFunction binds a function to a new name. Some functions are followed
+// by a let statement that binds a name they may refer to, either for the
+// first time or again, which they see when they are called later on.
+func (g *Generator) letFunction(depth int) []ast.Statement {
+	var next *binding
+	switch rebound := g.rebindable(); {
+	case len(rebound) > 0 && g.r.IntN(3) == 0:
+		next = rebound[g.r.IntN(len(rebound))]
+	case g.r.IntN(3) == 0:
+		next = g.scope.add(binding{name: g.name(), t: valueType(g.r.IntN(int(functionType))), later: true})
+	}
+
+	fn := g.function(depth)
+	fn.Name = g.bind(functionType, len(fn.Parameters))
+	stmts := []ast.Statement{&ast.LetStatement{Name: identifier(fn.Name), Value: fn}}
+
+	if next != nil {
+		value := g.expression(next.t, depth)
+		next.later = false
+		stmts = append(stmts, &ast.LetStatement{Name: identifier(next.name), Value: value})
+	}
+
+	return stmts
+}
+
+// expression returns an expression of type t at the given depth of
+// nesting.
+func (g *Generator) expression(t valueType, depth int) ast.Expression {
+	if g.r.Float64() < g.ErrorRate {
+		return g.mistake(depth)
+	}
+
+	if depth >= g.MaxDepth || g.r.IntN(4) == 0 {
+		return g.leaf(t)
+	}
+
+	depth++
+
+	switch t {
+	case intType:
+		switch g.r.IntN(10) {
+		case 0, 1:
+			return infix(g.expression(intType, depth), pick(g.r, "+", "-", "*", "/"), g.expression(intType, depth))
+		case 2:
+			return &ast.PrefixExpression{Operator: "-", Right: g.expression(intType, depth)}
+		case 3:
+			return g.call("len", g.expression(pick(g.r, stringType, arrayType), depth))
+		case 4:
+			return &ast.IndexExpression{Left: g.expression(arrayType, depth), Index: g.expression(intType, depth)}
+		case 5:
+			return &ast.IndexExpression{Left: g.expression(hashType, depth), Index: g.key()}
+		case 6:
+			return g.call(pick(g.r, "first", "last"), g.expression(arrayType, depth))
+		case 7:
+			return g.call("int", g.call("str", g.expression(intType, depth)))
+		case 8:
+			return g.apply(depth)
+		}
+	case boolType:
+		switch g.r.IntN(5) {
+		case 0, 1:
+			return infix(g.expression(intType, depth), pick(g.r, "<", ">", "==", "!="), g.expression(intType, depth))
+		case 2:
+			return &ast.PrefixExpression{Operator: "!", Right: g.expression(g.printable(), depth)}
+		case 3:
+			return infix(g.expression(stringType, depth), pick(g.r, "==", "!="), g.expression(stringType, depth))
+		}
+	case stringType:
+		switch g.r.IntN(5) {
+		case 0, 1:
+			return infix(g.expression(stringType, depth), "+", g.expression(stringType, depth))
+		case 2:
+			return g.call("str", g.expression(g.printable(), depth))
+		case 3:
+			return g.call("type", g.expression(valueType(g.r.IntN(int(numTypes))), depth))
+		}
+	case arrayType:
+		switch g.r.IntN(4) {
+		case 0:
+			return g.call("push", g.expression(arrayType, depth), g.expression(intType, depth))
+		case 1:
+			return g.call("rest", g.expression(arrayType, depth))
+		case 2:
+			return g.array(depth)
+		}
+	case hashType:
+		if g.r.IntN(2) == 0 {
+			return g.hash(depth)
+		}
+	case functionType:
+		return g.function(depth)
+	}
+
+	return g.ifExpression(t, depth)
+}
+
+// leaf returns a literal or a name bound to a value of type t.
+func (g *Generator) leaf(t valueType) ast.Expression {
+	if names := g.visible(t); len(names) > 0 && g.r.IntN(2) == 0 {
+		return identifier(names[g.r.IntN(len(names))].name)
+	}
+
+	switch t {
+	case intType:
+		values := []int64{0, 1, 2, 3, 7, 10, 100, math.MaxInt64}
+		return &ast.IntegerLiteral{Value: values[g.r.IntN(len(values))]}
+	case boolType:
+		return &ast.Boolean{Value: g.r.IntN(2) == 0}
+	case stringType:
+		return &ast.StringLiteral{Value: pick(g.r, "", "a", "monkey", "b")}
+	case arrayType:
+		return g.array(g.MaxDepth)
+	case hashType:
+		return g.hash(g.MaxDepth)
+	}
+
+	return g.function(g.MaxDepth)
+}
+
+// mistake returns an expression that fails at runtime, or may.
+func (g *Generator) mistake(depth int) ast.Expression {
+	switch g.r.IntN(6) {
+	case 0:
+		return infix(g.expression(intType, depth+1), "/", &ast.IntegerLiteral{Value: 0})
+	case 1:
+		return infix(g.expression(stringType, depth+1), pick(g.r, "-", "<", "+"), g.expression(intType, depth+1))
+	case 2:
+		return &ast.CallExpression{Function: g.expression(intType, depth+1)}
+	case 3:
+		return identifier("undefined")
+	case 4:
+		return g.unusableKey(depth + 1)
+	}
+
+	return g.call(pick(g.r, "len", "first", "rest", "int"), g.expression(g.printable(), depth+1), g.leaf(intType))
+}
+
+// unusableKey returns a hash literal with a key that cannot be hashed,
+// paired with a value that fails or prints, and maybe more pairs around
+// it, so that the order the engines evaluate and check pairs in shows.
+func (g *Generator) unusableKey(depth int) ast.Expression {
+	hash := g.hash(depth).(*ast.HashLiteral)
+
+	value := g.call("puts", g.expression(g.printable(), depth))
+	if g.r.IntN(2) == 0 {
+		value = infix(g.expression(intType, depth), "/", &ast.IntegerLiteral{Value: 0})
+	}
+
+	pair := ast.HashPair{Key: g.expression(pick(g.r, arrayType, hashType, functionType), depth), Value: value}
+	hash.Pairs = slices.Insert(hash.Pairs, g.r.IntN(len(hash.Pairs)+1), pair)
+
+	return hash
+}
+
+func (g *Generator) ifExpression(t valueType, depth int) ast.Expression {
+	exp := &ast.IfExpression{
+		Condition:   g.expression(g.printable(), depth),
+		Consequence: g.block(t, depth),
+	}
+
+	if g.r.IntN(4) != 0 {
+		exp.Alternative = g.block(t, depth)
+	}
+
+	return exp
+}
+
+func (g *Generator) block(t valueType, depth int) *ast.BlockStatement {
+	g.enter(false)
+	defer g.leave()
+
+	return &ast.BlockStatement{Statements: g.statements(t, depth)}
+}
+
+func (g *Generator) function(depth int) *ast.FunctionLiteral {
+	g.enter(true)
+	defer g.leave()
+
+	fn := &ast.FunctionLiteral{}
+	for n := g.r.IntN(3); n > 0; n-- {
+		fn.Parameters = append(fn.Parameters, identifier(g.bind(intType, 0)))
+	}
+
+	fn.Body = &ast.BlockStatement{Statements: g.statements(intType, depth)}
+
+	return fn
+}
+
+// apply calls a function with integer arguments.
+func (g *Generator) apply(depth int) ast.Expression {
+	var fn ast.Expression
+	n := g.r.IntN(3)
+
+	if names := g.visible(functionType); len(names) > 0 && g.r.IntN(3) != 0 {
+		b := names[g.r.IntN(len(names))]
+		fn, n = identifier(b.name), b.arity
+	} else {
+		literal := g.function(depth)
+		fn, n = literal, len(literal.Parameters)
+	}
+
+	call := &ast.CallExpression{Function: fn}
+	for ; n > 0; n-- {
+		call.Arguments = append(call.Arguments, g.expression(intType, depth))
+	}
+
+	return call
+}
+
+func (g *Generator) array(depth int) ast.Expression {
+	array := &ast.ArrayLiteral{}
+	for n := g.r.IntN(4); n > 0; n-- {
+		array.Elements = append(array.Elements, g.expression(intType, depth))
+	}
+
+	return array
+}
+
+func (g *Generator) hash(depth int) ast.Expression {
+	hash := &ast.HashLiteral{}
+	for n := g.r.IntN(3); n > 0; n-- {
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: g.key(), Value: g.expression(intType, depth)})
+	}
+
+	return hash
+}
+
+func (g *Generator) key() ast.Expression {
+	return &ast.StringLiteral{Value: hashKeys[g.r.IntN(len(hashKeys))]}
+}
+
+func (g *Generator) call(name string, args ...ast.Expression) ast.Expression {
+	return &ast.CallExpression{Function: identifier(name), Arguments: args}
+}
+
+func (g *Generator) printable() valueType {
+	return printable[g.r.IntN(len(printable))]
+}
+
+func (g *Generator) enter(function bool) {
+	g.scope = &generatorScope{outer: g.scope, function: function}
+}
+
+func (g *Generator) leave() {
+	g.scope = g.scope.outer
+}
+
+// bind binds a new name to a value of type t in the current scope.
+func (g *Generator) bind(t valueType, arity int) string {
+	return g.scope.add(binding{name: g.name(), t: t, arity: arity}).name
+}
+
+// name returns a name no binding has yet.
+func (g *Generator) name() string {
+	name := "v"
+	for n := g.names; ; n = n/26 - 1 {
+		name += string(rune('a' + n%26))
+		if n < 26 {
+			break
+		}
+	}
+	g.names++
+
+	return name
+}
+
+func (s *generatorScope) add(b binding) *binding {
+	s.bindings = append(s.bindings, &b)
+
+	return &b
+}
+
+// visible returns the bindings of type t in scope. Later bindings are
+// only visible from the functions of their scope.
+func (g *Generator) visible(t valueType) []binding {
+	var bindings []binding
+
+	nested := false
+	for s := g.scope; s != nil; s = s.outer {
+		for _, b := range s.bindings {
+			if b.t == t && (nested || !b.later) {
+				bindings = append(bindings, *b)
+			}
+		}
+		nested = nested || s.function
+	}
+
+	return bindings
+}
+
+// rebindable returns the bindings other than functions that the current
+// scope itself has bound, which a let statement may bind again.
+func (g *Generator) rebindable() []*binding {
+	var bindings []*binding
+
+	for _, b := range g.scope.bindings {
+		if b.t != functionType && !b.later {
+			bindings = append(bindings, b)
+		}
+	}
+
+	return bindings
+}
+
+func pick[T any](r *rand.Rand, choices ...T) T {
+	return choices[r.IntN(len(choices))]
+}
+
+func identifier(name string) *ast.Identifier {
+	return &ast.Identifier{Token: token.IDENT.Token(name), Value: name}
+}
+
+func infix(left ast.Expression, operator string, right ast.Expression) ast.Expression {
+	return &ast.InfixExpression{Left: left, Operator: operator, Right: right}
+}
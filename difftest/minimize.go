@@ -0,0 +1,145 @@
+package difftest
+
+import (
+	"slices"
+
+	"github.com/marcel/monkey/ast"
+)
+
+type (
+	// An edit makes a program smaller and returns a function undoing it.
+	edit func() (undo func())
+
+	// A reducer collects the edits that can be made to a program, larger
+	// ones first.
+	reducer struct {
+		edits []edit
+	}
+)
+
+// Minimize reduces program in place, for as long as fails holds for the
+// result, and returns it. It removes statements and replaces expressions
+// with 0 or with one of their operands, one edit at a time, until no edit
+// leaves a program fails holds for.
+func Minimize(program *ast.Program, fails func(*ast.Program) bool) *ast.Program {
+	for reduced := true; reduced; {
+		reduced = false
+
+		r := &reducer{}
+		r.statements(&program.Statements)
+
+		for _, edit := range r.edits {
+			undo := edit()
+			if fails(program) {
+				reduced = true
+				break
+			}
+			undo()
+		}
+	}
+
+	return program
+}
+
+func (r *reducer) statements(stmts *[]ast.Statement) {
+	for i := range *stmts {
+		r.edits = append(r.edits, func() func() {
+			old := *stmts
+			*stmts = slices.Delete(slices.Clone(old), i, i+1)
+
+			return func() { *stmts = old }
+		})
+	}
+
+	for _, stmt := range *stmts {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			r.expression(&stmt.Value)
+		case *ast.ReturnStatement:
+			r.expression(&stmt.ReturnValue)
+		case *ast.ExpressionStatement:
+			r.expression(&stmt.Expression)
+		}
+	}
+}
+
+func (r *reducer) expression(exp *ast.Expression) {
+	var (
+		operands []*ast.Expression
+		values   []*ast.Expression
+		blocks   []*ast.BlockStatement
+	)
+
+	switch e := (*exp).(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		if lit, ok := e.(*ast.IntegerLiteral); !ok || lit.Value != 0 {
+			r.replace(exp, func() ast.Expression { return &ast.IntegerLiteral{Value: 0} })
+		}
+		return
+	case *ast.PrefixExpression:
+		operands = []*ast.Expression{&e.Right}
+	case *ast.InfixExpression:
+		operands = []*ast.Expression{&e.Left, &e.Right}
+	case *ast.IfExpression:
+		operands = []*ast.Expression{&e.Condition}
+		blocks = []*ast.BlockStatement{e.Consequence, e.Alternative}
+
+		// An if expression can also be replaced with the values of its
+		// blocks.
+		for _, block := range blocks {
+			if block == nil || len(block.Statements) == 0 {
+				continue
+			}
+			if stmt, ok := block.Statements[len(block.Statements)-1].(*ast.ExpressionStatement); ok {
+				values = append(values, &stmt.Expression)
+			}
+		}
+	case *ast.FunctionLiteral:
+		blocks = []*ast.BlockStatement{e.Body}
+	case *ast.CallExpression:
+		operands = append([]*ast.Expression{&e.Function}, pointers(e.Arguments)...)
+	case *ast.ArrayLiteral:
+		operands = pointers(e.Elements)
+	case *ast.HashLiteral:
+		for i := range e.Pairs {
+			operands = append(operands, &e.Pairs[i].Key, &e.Pairs[i].Value)
+		}
+	case *ast.IndexExpression:
+		operands = []*ast.Expression{&e.Left, &e.Index}
+	}
+
+	r.replace(exp, func() ast.Expression { return &ast.IntegerLiteral{Value: 0} })
+	for _, operand := range append(operands, values...) {
+		r.replace(exp, func() ast.Expression { return *operand })
+	}
+
+	for _, operand := range operands {
+		r.expression(operand)
+	}
+
+	for _, block := range blocks {
+		if block != nil {
+			r.statements(&block.Statements)
+		}
+	}
+}
+
+// replace adds the edit replacing the expression at exp with the one with
+// returns.
+func (r *reducer) replace(exp *ast.Expression, with func() ast.Expression) {
+	r.edits = append(r.edits, func() func() {
+		old := *exp
+		*exp = with()
+
+		return func() { *exp = old }
+	})
+}
+
+func pointers(exps []ast.Expression) []*ast.Expression {
+	p := make([]*ast.Expression, len(exps))
+	for i := range exps {
+		p[i] = &exps[i]
+	}
+
+	return p
+}
@@ -0,0 +1,151 @@
+package monkey
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/parser"
+	"github.com/marcel/monkey/token"
+)
+
+const (
+	LexError ErrorKind = iota
+	ParseError
+	RuntimeError
+	CompileError
+	LoadError
+)
+
+type (
+	ErrorKind int
+
+	// An Error is returned for any failure of a Monkey program. Syntax
+	// errors describe the first problem found and list all of them in
+	// Errors; runtime errors carry the Monkey stack trace and wrap the
+	// underlying *object.Error. Compile errors only occur with the VM
+	// engine, for programs too large for the bytecode. Load errors are
+	// for files that cannot be read, or hold compiled programs that
+	// cannot be run, and wrap the cause.
+	Error struct {
+		Kind    ErrorKind
+		File    string
+		Pos     token.Position
+		Message string
+		Stack   []object.Frame
+		Errors  []*parser.Error
+		Err     error
+	}
+)
+
+var errorKindNames = map[ErrorKind]string{
+	LexError:     "lex error",
+	ParseError:   "parse error",
+	RuntimeError: "runtime error",
+	CompileError: "compile error",
+	LoadError:    "load error",
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := errorKindNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(":")
+	}
+	if e.Pos.IsValid() {
+		b.WriteString(e.Pos.String())
+		b.WriteString(":")
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+
+	b.WriteString(e.Message)
+
+	if n := len(e.Errors); n > 1 {
+		fmt.Fprintf(&b, " (and %d more errors)", n-1)
+	}
+
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// StackTrace returns the message followed by one line per Monkey frame.
+func (e *Error) StackTrace() string {
+	if err, ok := e.Err.(*object.Error); ok {
+		return e.Error() + strings.TrimPrefix(err.StackTrace(), err.Error())
+	}
+
+	return e.Error()
+}
+
+func syntaxError(file string, errors []*parser.Error) *Error {
+	first := errors[0]
+
+	kind := ParseError
+	if first.Lexical {
+		kind = LexError
+	}
+
+	return &Error{
+		Kind:    kind,
+		File:    file,
+		Pos:     first.Pos,
+		Message: first.Message,
+		Errors:  errors,
+		Err:     first,
+	}
+}
+
+func runtimeError(file string, err *object.Error) *Error {
+	return &Error{
+		Kind:    RuntimeError,
+		File:    file,
+		Pos:     err.Pos,
+		Message: err.Message,
+		Stack:   err.Stack,
+		Err:     err,
+	}
+}
+
+func compileError(file string, err *compiler.Error) *Error {
+	return &Error{
+		Kind:    CompileError,
+		File:    file,
+		Pos:     err.Pos,
+		Message: err.Message,
+		Err:     err,
+	}
+}
+
+func loadError(file string, err error) *Error {
+	message := err.Error()
+
+	// The file is reported already.
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && pathErr.Path == file {
+		message = pathErr.Op + ": " + pathErr.Err.Error()
+	}
+
+	return &Error{
+		Kind:    LoadError,
+		File:    file,
+		Message: message,
+		Err:     err,
+	}
+}
@@ -0,0 +1,726 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/gas"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/token"
+)
+
+const (
+	mainFrameName      = "<main>"
+	anonymousFrameName = "<anonymous>"
+
+	// DefaultMaxDepth is the call depth New limits evaluators to, well
+	// below the depth at which the Go stack would overflow.
+	DefaultMaxDepth = 10000
+
+	// contextCheckInterval is the number of steps between two checks of
+	// whether the context of an evaluation is done.
+	contextCheckInterval = 1024
+)
+
+var (
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+)
+
+type (
+	// An Evaluator walks the AST and keeps track of the calls in progress,
+	// so that runtime errors carry a Monkey stack trace. Globals is where
+	// Call and BindFunc look functions up; evaluate programs in it to make
+	// their let bindings available to the host. An Evaluator must not be
+	// used by more than one goroutine at a time.
+	//
+	// MaxSteps limits the number of nodes a single evaluation, or call from
+	// the host, may evaluate and MaxDepth the depth of nested function
+	// calls; zero means no limit. Alloc accounts for the objects and
+	// environments programs allocate. Gas, when set, meters the cost of
+	// each evaluation or call from the host, starting from zero every time.
+	Evaluator struct {
+		Builtins *object.Builtins
+		Globals  *object.Environment
+		Alloc    *object.Allocator
+		Gas      *gas.Meter
+		MaxSteps int64
+		MaxDepth int
+
+		frames  []frame
+		ctx     context.Context
+		steps   int64
+		running bool
+	}
+
+	frame struct {
+		name   string
+		call   token.Position
+		elided int
+	}
+)
+
+func New() *Evaluator {
+	alloc := object.NewAllocator(0)
+
+	return &Evaluator{
+		Builtins: object.CoreBuiltins(os.Stdout, os.Stderr, alloc),
+		Globals:  object.NewEnvironment(),
+		Alloc:    alloc,
+		MaxDepth: DefaultMaxDepth,
+	}
+}
+
+func Eval(node ast.Node, env *object.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+func (e *Evaluator) Eval(node ast.Node, env *object.Environment) object.Object {
+	return e.EvalContext(context.Background(), node, env)
+}
+
+// EvalContext evaluates node like Eval and ends the evaluation with a
+// Timeout error once ctx is done.
+func (e *Evaluator) EvalContext(ctx context.Context, node ast.Node, env *object.Environment) object.Object {
+	defer e.begin(ctx)()
+
+	return e.eval(node, env)
+}
+
+// begin starts an evaluation requested by the host and returns the function
+// that ends it. Evaluations a builtin starts while another one is running
+// count toward the running one.
+func (e *Evaluator) begin(ctx context.Context) func() {
+	if e.running {
+		return func() {}
+	}
+
+	e.running, e.ctx, e.steps = true, ctx, 0
+	e.Gas.Reset()
+	e.Alloc.Begin()
+
+	return func() { e.running, e.ctx = false, nil }
+}
+
+func (e *Evaluator) eval(node ast.Node, env *object.Environment) object.Object {
+	if err := e.step(node); err != nil {
+		return err
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		return e.evalProgram(node, env)
+	case *ast.ExpressionStatement:
+		return e.eval(node.Expression, env)
+	case *ast.BlockStatement:
+		return e.evalBlockStatement(node, env)
+	case *ast.LetStatement:
+		val := e.eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		env.Set(node.Name.Value, val)
+	case *ast.ReturnStatement:
+		val := e.eval(node.ReturnValue, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+	case *ast.IntegerLiteral:
+		return e.alloc(node.Pos(), &object.Integer{Value: node.Value})
+	case *ast.Boolean:
+		return nativeBoolToBooleanObject(node.Value)
+	case *ast.StringLiteral:
+		return e.alloc(node.Pos(), &object.String{Value: node.Value})
+	case *ast.ArrayLiteral:
+		elements := e.evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
+			return elements[0]
+		}
+		if err := e.charge(node.Pos(), gas.Element, len(elements)); err != nil {
+			return err
+		}
+		return e.alloc(node.Pos(), &object.Array{Elements: elements})
+	case *ast.HashLiteral:
+		return e.evalHashLiteral(node, env)
+	case *ast.Identifier:
+		return e.evalIdentifier(node, env)
+	case *ast.PrefixExpression:
+		right := e.eval(node.Right, env)
+		if isAbrupt(right) {
+			return right
+		}
+		if err := e.charge(node.Pos(), gas.Prefix, 1); err != nil {
+			return err
+		}
+		return e.evalPrefixExpression(node, right)
+	case *ast.InfixExpression:
+		left := e.eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		right := e.eval(node.Right, env)
+		if isAbrupt(right) {
+			return right
+		}
+		if err := e.charge(node.Token.Pos(), gas.Infix, 1); err != nil {
+			return err
+		}
+		return e.evalInfixExpression(node, left, right)
+	case *ast.IfExpression:
+		return e.evalIfExpression(node, env)
+	case *ast.FunctionLiteral:
+		return e.alloc(node.Pos(), &object.Function{
+			Name:       node.Name,
+			Parameters: node.Parameters,
+			Body:       node.Body,
+			Env:        env,
+		})
+	case *ast.CallExpression:
+		function, args, err := e.evalCall(node, env)
+		if err != nil {
+			return err
+		}
+		return e.applyFunction(node.Pos(), function, args)
+	case *ast.IndexExpression:
+		left := e.eval(node.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := e.eval(node.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		if err := e.charge(node.Token.Pos(), gas.Index, 1); err != nil {
+			return err
+		}
+		return e.evalIndexExpression(node, left, index)
+	}
+
+	return nil
+}
+
+func (e *Evaluator) evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	var result object.Object
+
+	for _, stmt := range program.Statements {
+		result = e.eval(stmt, env)
+
+		switch result := result.(type) {
+		case *object.ReturnValue:
+			return result.Value
+		case *object.Error:
+			return result
+		}
+	}
+
+	return result
+}
+
+// evalBlockStatement evaluates block in env itself; callers that need the
+// block to have its own scope enclose env first.
+func (e *Evaluator) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+	var result object.Object
+
+	for _, stmt := range block.Statements {
+		result = e.eval(stmt, env)
+
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+func (e *Evaluator) evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
+	if val, ok := env.Get(node.Value); ok {
+		return val
+	}
+
+	if builtin, ok := e.Builtins.Lookup(node.Value); ok {
+		return builtin
+	}
+
+	return e.newError(node.Pos(), object.UnknownIdentifier, "identifier not found: %s", node.Value)
+}
+
+func (e *Evaluator) evalPrefixExpression(node *ast.PrefixExpression, right object.Object) object.Object {
+	switch node.Operator {
+	case "!":
+		return nativeBoolToBooleanObject(!isTruthy(right))
+	case "-":
+		if right.Type() == object.INTEGER_OBJ {
+			return e.alloc(node.Pos(), &object.Integer{Value: -right.(*object.Integer).Value})
+		}
+	}
+
+	return e.newError(
+		node.Pos(),
+		object.UnknownOperator,
+		"unknown operator: %s%s",
+		node.Operator,
+		right.Type(),
+	)
+}
+
+func (e *Evaluator) evalInfixExpression(node *ast.InfixExpression, left, right object.Object) object.Object {
+	operator := node.Operator
+
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return e.evalIntegerInfixExpression(node, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return e.evalStringInfixExpression(node, left, right)
+	case operator == "==":
+		return nativeBoolToBooleanObject(left == right)
+	case operator == "!=":
+		return nativeBoolToBooleanObject(left != right)
+	case left.Type() != right.Type():
+		return e.newError(
+			node.Token.Pos(),
+			object.TypeMismatch,
+			"type mismatch: %s %s %s",
+			left.Type(),
+			operator,
+			right.Type(),
+		)
+	}
+
+	return e.newError(
+		node.Token.Pos(),
+		object.UnknownOperator,
+		"unknown operator: %s %s %s",
+		left.Type(),
+		operator,
+		right.Type(),
+	)
+}
+
+func (e *Evaluator) evalIntegerInfixExpression(node *ast.InfixExpression, left, right object.Object) object.Object {
+	leftVal := left.(*object.Integer).Value
+	rightVal := right.(*object.Integer).Value
+
+	switch node.Operator {
+	case "+":
+		return e.alloc(node.Token.Pos(), &object.Integer{Value: leftVal + rightVal})
+	case "-":
+		return e.alloc(node.Token.Pos(), &object.Integer{Value: leftVal - rightVal})
+	case "*":
+		return e.alloc(node.Token.Pos(), &object.Integer{Value: leftVal * rightVal})
+	case "/":
+		if rightVal == 0 {
+			return e.newError(node.Token.Pos(), object.DivisionByZero, "division by zero")
+		}
+		return e.alloc(node.Token.Pos(), &object.Integer{Value: leftVal / rightVal})
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	}
+
+	return e.newError(
+		node.Token.Pos(),
+		object.UnknownOperator,
+		"unknown operator: %s %s %s",
+		left.Type(),
+		node.Operator,
+		right.Type(),
+	)
+}
+
+func (e *Evaluator) evalStringInfixExpression(node *ast.InfixExpression, left, right object.Object) object.Object {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch node.Operator {
+	case "+":
+		if err := e.charge(node.Token.Pos(), gas.Byte, len(leftVal)+len(rightVal)); err != nil {
+			return err
+		}
+		return e.alloc(node.Token.Pos(), &object.String{Value: leftVal + rightVal})
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	}
+
+	return e.newError(
+		node.Token.Pos(),
+		object.UnknownOperator,
+		"unknown operator: %s %s %s",
+		left.Type(),
+		node.Operator,
+		right.Type(),
+	)
+}
+
+func (e *Evaluator) evalIndexExpression(node *ast.IndexExpression, left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+
+		if i < 0 || i >= int64(len(elements)) {
+			return NULL
+		}
+
+		return elements[i]
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return e.newError(node.Token.Pos(), object.TypeMismatch, "unusable as hash key: %s", index.Type())
+		}
+
+		if value, ok := left.(*object.Hash).Get(key); ok {
+			return value
+		}
+
+		return NULL
+	}
+
+	return e.newError(
+		node.Token.Pos(),
+		object.TypeMismatch,
+		"index operator not supported: %s[%s]",
+		left.Type(),
+		index.Type(),
+	)
+}
+
+// evalHashLiteral evaluates the keys and values of all pairs in order
+// before it checks that the keys can be hashed, as the VM does.
+func (e *Evaluator) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	elements := make([]object.Object, 0, 2*len(node.Pairs))
+
+	for _, pair := range node.Pairs {
+		key := e.eval(pair.Key, env)
+		if isAbrupt(key) {
+			return key
+		}
+
+		value := e.eval(pair.Value, env)
+		if isAbrupt(value) {
+			return value
+		}
+
+		elements = append(elements, key, value)
+	}
+
+	hash := object.NewHash(len(node.Pairs))
+
+	for i := 0; i < len(elements); i += 2 {
+		key, ok := elements[i].(object.Hashable)
+		if !ok {
+			return e.newError(node.Pos(), object.TypeMismatch, "unusable as hash key: %s", elements[i].Type())
+		}
+
+		hash.Set(key, elements[i+1])
+	}
+
+	if err := e.charge(node.Pos(), gas.Element, len(node.Pairs)); err != nil {
+		return err
+	}
+
+	return e.alloc(node.Pos(), hash)
+}
+
+func (e *Evaluator) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	block, err := e.evalCondition(ie, env)
+	if err != nil {
+		return err
+	}
+
+	if block == nil {
+		return NULL
+	}
+
+	scope, err := e.enclose(block, env)
+	if err != nil {
+		return err
+	}
+
+	return nullIfNil(e.evalBlockStatement(block, scope))
+}
+
+// evalCondition returns the block of ie its condition selects, nil when
+// there is none.
+func (e *Evaluator) evalCondition(ie *ast.IfExpression, env *object.Environment) (*ast.BlockStatement, object.Object) {
+	condition := e.eval(ie.Condition, env)
+	if isAbrupt(condition) {
+		return nil, condition
+	}
+
+	if isTruthy(condition) {
+		return ie.Consequence, nil
+	}
+
+	return ie.Alternative, nil
+}
+
+// enclose creates the scope of block inside env.
+func (e *Evaluator) enclose(block *ast.BlockStatement, env *object.Environment) (*object.Environment, object.Object) {
+	if err := e.Alloc.Environment(0); err != nil {
+		return nil, e.newError(block.Pos(), err.Kind, "%s", err.Message)
+	}
+
+	return object.NewEnclosedEnvironment(env), nil
+}
+
+func (e *Evaluator) evalCall(node *ast.CallExpression, env *object.Environment) (object.Object, []object.Object, object.Object) {
+	function := e.eval(node.Function, env)
+	if isAbrupt(function) {
+		return nil, nil, function
+	}
+
+	args := e.evalExpressions(node.Arguments, env)
+	if len(args) == 1 && isAbrupt(args[0]) {
+		return nil, nil, args[0]
+	}
+
+	return function, args, nil
+}
+
+func (e *Evaluator) evalExpressions(exps []ast.Expression, env *object.Environment) []object.Object {
+	result := []object.Object{}
+
+	for _, exp := range exps {
+		evaluated := e.eval(exp, env)
+		if isAbrupt(evaluated) {
+			return []object.Object{evaluated}
+		}
+
+		result = append(result, evaluated)
+	}
+
+	return result
+}
+
+// applyFunction calls fn from pos. The calls fn makes in tail position are
+// handed back to it as a *tailCall and made here, in place of the call that
+// made them, so that tail recursion runs in constant stack space. Their
+// frames replace that of fn in stack traces.
+func (e *Evaluator) applyFunction(pos token.Position, fn object.Object, args []object.Object) object.Object {
+	at := pos
+
+	for elided := 0; ; elided++ {
+		result := e.applyOnce(pos, at, fn, args, elided)
+
+		tc, ok := result.(*tailCall)
+		if !ok {
+			return result
+		}
+
+		fn, args, at = tc.fn, tc.args, tc.pos
+	}
+}
+
+// applyOnce calls fn at the position at, with a frame called from pos that
+// takes the place of elided calls in tail position.
+func (e *Evaluator) applyOnce(pos, at token.Position, fn object.Object, args []object.Object, elided int) object.Object {
+	if builtin, ok := fn.(*object.Builtin); ok {
+		if err := e.charge(at, gas.Builtin, 1); err != nil {
+			return err
+		}
+		if builtin.Elements != nil {
+			if err := e.charge(at, gas.Element, builtin.Elements(args...)); err != nil {
+				return err
+			}
+		}
+		return e.applyBuiltin(at, builtin, args)
+	}
+
+	function, ok := fn.(*object.Function)
+	if !ok {
+		return e.newError(at, object.NotAFunction, "not a function: %s", fn.Type())
+	}
+
+	if err := e.charge(at, gas.Call, 1); err != nil {
+		return err
+	}
+
+	if len(args) != len(function.Parameters) {
+		return e.newError(
+			at,
+			object.ArityMismatch,
+			"wrong number of arguments: want=%d, got=%d",
+			len(function.Parameters),
+			len(args),
+		)
+	}
+
+	if e.MaxDepth > 0 && len(e.frames) >= e.MaxDepth {
+		return e.newError(at, object.StackOverflow, "stack overflow: maximum call depth of %d exceeded", e.MaxDepth)
+	}
+
+	name := function.Name
+	if name == "" {
+		name = anonymousFrameName
+	}
+
+	if err := e.Alloc.Environment(len(args)); err != nil {
+		return e.newError(at, err.Kind, "%s", err.Message)
+	}
+
+	e.frames = append(e.frames, frame{name: name, call: pos, elided: elided})
+	defer func() { e.frames = e.frames[:len(e.frames)-1] }()
+
+	env := extendFunctionEnv(function, args)
+	evaluated := e.evalBody(function.Body, env, true)
+
+	return unwrapReturnValue(evaluated)
+}
+
+func (e *Evaluator) step(node ast.Node) *object.Error {
+	e.steps++
+
+	if e.MaxSteps > 0 && e.steps > e.MaxSteps {
+		return e.newError(node.Pos(), object.StepLimit, "step limit of %d exceeded", e.MaxSteps)
+	}
+
+	if e.ctx != nil && (e.steps == 1 || e.steps%contextCheckInterval == 0) {
+		if err := e.ctx.Err(); err != nil {
+			timeout := e.newError(node.Pos(), object.Timeout, "execution stopped: %s", err)
+			timeout.Err = err
+			return timeout
+		}
+	}
+
+	return nil
+}
+
+// charge charges the gas for n operations of kind op at pos.
+func (e *Evaluator) charge(pos token.Position, op gas.Op, n int) *object.Error {
+	if e.Gas.Charge(op, n) {
+		return nil
+	}
+
+	return e.newError(pos, object.OutOfGas, "out of gas: limit of %d exceeded", e.Gas.Limit)
+}
+
+// alloc accounts for obj, which has just been allocated at pos.
+func (e *Evaluator) alloc(pos token.Position, obj object.Object) object.Object {
+	if err := e.Alloc.Alloc(object.SizeOf(obj)); err != nil {
+		return e.newError(pos, err.Kind, "%s", err.Message)
+	}
+
+	return obj
+}
+
+// applyBuiltin calls builtin and gives any error it returns the position of
+// the call, since builtins have no position of their own.
+func (e *Evaluator) applyBuiltin(pos token.Position, builtin *object.Builtin, args []object.Object) object.Object {
+	result := builtin.Fn(args...)
+
+	if err, ok := result.(*object.Error); ok && err.Stack == nil {
+		located := e.newError(pos, err.Kind, "%s", err.Message)
+		err.Pos, err.Stack = located.Pos, located.Stack
+	}
+
+	if result == nil {
+		return NULL
+	}
+
+	return result
+}
+
+// extendFunctionEnv creates the scope of a single call: it encloses the
+// environment the function was defined in, which is what makes closures
+// work, and binds the parameters in it.
+func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+	env := object.NewEnclosedEnvironment(fn.Env)
+
+	for i, param := range fn.Parameters {
+		env.Set(param.Value, args[i])
+	}
+
+	return env
+}
+
+// newError creates an error at pos along with a stack trace of the calls
+// currently in progress. Calls made by the host rather than by the program
+// have no position and end the trace.
+func (e *Evaluator) newError(pos token.Position, kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+	at := pos
+	stack := make([]object.Frame, 0, len(e.frames)+1)
+
+	for i := len(e.frames) - 1; i >= 0; i-- {
+		stack = append(stack, object.Frame{Name: e.frames[i].name, Pos: pos, Elided: e.frames[i].elided})
+		pos = e.frames[i].call
+	}
+
+	if pos.IsValid() {
+		stack = append(stack, object.Frame{Name: mainFrameName, Pos: pos})
+	}
+
+	return &object.Error{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, a...),
+		Pos:     at,
+		Stack:   stack,
+	}
+}
+
+func unwrapReturnValue(obj object.Object) object.Object {
+	if rv, ok := obj.(*object.ReturnValue); ok {
+		return rv.Value
+	}
+
+	if obj == nil {
+		return NULL
+	}
+
+	return obj
+}
+
+func nullIfNil(obj object.Object) object.Object {
+	if obj == nil {
+		return NULL
+	}
+
+	return obj
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj {
+	case NULL, FALSE:
+		return false
+	}
+
+	return true
+}
+
+func isError(obj object.Object) bool {
+	return obj != nil && obj.Type() == object.ERROR_OBJ
+}
+
+// isAbrupt reports whether obj ends the evaluation of the statement it
+// occurs in: an error, or the value of a return statement nested in an
+// expression, as in let x = if (c) { return 1 }.
+func isAbrupt(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+
+	rt := obj.Type()
+
+	return rt == object.ERROR_OBJ || rt == object.RETURN_VALUE_OBJ
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return TRUE
+	}
+
+	return FALSE
+}
@@ -0,0 +1,766 @@
+package evaluator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/gas"
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/parser"
+	"github.com/marcel/monkey/token"
+	"github.com/stretchr/testify/suite"
+)
+
+type EvaluatorTestSuite struct {
+	suite.Suite
+}
+
+func TestEvaluatorTestSuite(t *testing.T) {
+	suite.Run(t, new(EvaluatorTestSuite))
+}
+
+func (s *EvaluatorTestSuite) TestEvalIntegerExpression() {
+	expectations := []struct {
+		Input    string
+		Expected int64
+	}{
+		{"5", 5},
+		{"-10", -10},
+		{"5 + 5 + 5 + 5 - 10", 10},
+		{"2 * 2 * 2 * 2 * 2", 32},
+		{"-50 + 100 + -50", 0},
+		{"50 / 2 * 2 + 10", 60},
+		{"3 * (3 * 3) + 10", 37},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"9223372036854775807 + 1", -9223372036854775808},
+	}
+
+	for _, e := range expectations {
+		s.testIntegerObject(s.eval(e.Input), e.Expected)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestEvalBooleanExpression() {
+	expectations := []struct {
+		Input    string
+		Expected bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"true == true", true},
+		{"true != false", true},
+		{"(1 < 2) == true", true},
+		{"1 == true", false},
+		{"!5", false},
+		{"!!true", true},
+	}
+
+	for _, e := range expectations {
+		s.testBooleanObject(s.eval(e.Input), e.Expected)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestIfElseExpressions() {
+	expectations := []struct {
+		Input    string
+		Expected interface{}
+	}{
+		{"if (true) { 10 }", 10},
+		{"if (false) { 10 }", nil},
+		{"if (1) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (true) { }", nil},
+	}
+
+	for _, e := range expectations {
+		evaluated := s.eval(e.Input)
+
+		if expected, ok := e.Expected.(int); ok {
+			s.testIntegerObject(evaluated, int64(expected))
+		} else {
+			s.Equal(NULL, evaluated)
+		}
+	}
+}
+
+func (s *EvaluatorTestSuite) TestReturnStatements() {
+	expectations := []struct {
+		Input    string
+		Expected int64
+	}{
+		{"return 10; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{"if (10 > 1) { if (10 > 1) { return 10; } return 1; }", 10},
+		{"let x = if (true) { return 10 }; 9;", 10},
+		{"1 + if (true) { return 10 } else { 2 }", 10},
+		{"let f = fn() { let x = if (true) { return 10 }; 9 }; f()", 10},
+		{"let f = fn() { return if (true) { return 10 } }; f()", 10},
+		{"let f = fn(x) { x }; f(if (true) { return 10 })", 10},
+	}
+
+	for _, e := range expectations {
+		s.testIntegerObject(s.eval(e.Input), e.Expected)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestErrorHandling() {
+	expectations := []struct {
+		Input   string
+		Kind    object.ErrorKind
+		Message string
+	}{
+		{"5 + true;", object.TypeMismatch, "type mismatch: INTEGER + BOOLEAN"},
+		{"5 + true; 5;", object.TypeMismatch, "type mismatch: INTEGER + BOOLEAN"},
+		{"-true", object.UnknownOperator, "unknown operator: -BOOLEAN"},
+		{"true + false;", object.UnknownOperator, "unknown operator: BOOLEAN + BOOLEAN"},
+		{"if (10 > 1) { return true + false; }", object.UnknownOperator, "unknown operator: BOOLEAN + BOOLEAN"},
+		{"foobar", object.UnknownIdentifier, "identifier not found: foobar"},
+		{"10 / (5 - 5)", object.DivisionByZero, "division by zero"},
+		{"let x = 5; x()", object.NotAFunction, "not a function: INTEGER"},
+		{"fn(a, b) { a }(1)", object.ArityMismatch, "wrong number of arguments: want=2, got=1"},
+	}
+
+	for _, e := range expectations {
+		err, ok := s.eval(e.Input).(*object.Error)
+		s.True(ok, e.Input)
+		s.Equal(e.Kind, err.Kind)
+		s.Equal(e.Message, err.Message)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestHashLiteralEvaluationOrder() {
+	// Keys are checked once every pair is evaluated, as on the VM.
+	expectations := []struct {
+		Input    string
+		Kind     object.ErrorKind
+		Expected string
+	}{
+		{"{[1]: 1 / 0}", object.DivisionByZero, "1:9: division by zero"},
+		{"{[1]: 1, 2: 1 / 0}", object.DivisionByZero, "1:15: division by zero"},
+		{"{[1]: 1}", object.TypeMismatch, "1:1: unusable as hash key: ARRAY"},
+	}
+
+	for _, e := range expectations {
+		err, ok := s.eval(e.Input).(*object.Error)
+		s.Require().True(ok, e.Input)
+		s.Equal(e.Kind, err.Kind, e.Input)
+		s.Equal(e.Expected, err.Error(), e.Input)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestErrorStackTrace() {
+	input := `let c = fn(x) { x + true };
+let b = fn(x) { c(x) };
+let a = fn(x) { fn() { b(x) }() };
+1; a(1)`
+
+	err, ok := s.eval(input).(*object.Error)
+	s.True(ok)
+
+	// The calls of the anonymous function, b and c are in tail position,
+	// so c takes the place of a.
+	s.Equal(object.TypeMismatch, err.Kind)
+	s.Equal("1:19: type mismatch: INTEGER + BOOLEAN", err.Error())
+	s.Equal([]object.Frame{
+		{Name: "c", Pos: token.Position{Offset: 18, Line: 1, Column: 19}, Elided: 3},
+		{Name: "<main>", Pos: token.Position{Offset: 90, Line: 4, Column: 4}},
+	}, err.Stack)
+
+	s.Equal(`1:19: type mismatch: INTEGER + BOOLEAN
+	at c (1:19)
+	... 3 tail calls elided
+	at <main> (4:4)`, err.StackTrace())
+
+	err, ok = s.eval(strings.NewReplacer("c(x)", "c(x) + 0", "b(x)", "b(x) + 0", "}() }", "}() + 0 }").Replace(input)).(*object.Error)
+	s.True(ok)
+	s.Equal(`1:19: type mismatch: INTEGER + BOOLEAN
+	at c (1:19)
+	at b (2:17)
+	at <anonymous> (3:24)
+	at a (3:17)
+	at <main> (4:4)`, err.StackTrace())
+
+	var target *object.Error
+	s.True(errors.As(fmt.Errorf("running script: %w", err), &target))
+	s.Equal(object.TypeMismatch, target.Kind)
+}
+
+func (s *EvaluatorTestSuite) TestFunctionApplication() {
+	expectations := []struct {
+		Input    string
+		Expected int64
+	}{
+		{"let identity = fn(x) { x; }; identity(5);", 5},
+		{"let identity = fn(x) { return x; }; identity(5);", 5},
+		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
+		{"fn(x) { x; }(5)", 5},
+		{"let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; fact(5)", 120},
+	}
+
+	for _, e := range expectations {
+		s.testIntegerObject(s.eval(e.Input), e.Expected)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestClosures() {
+	input := `
+		let newAdder = fn(x) {
+			fn(y) { x + y };
+		};
+
+		let addTwo = newAdder(2);
+		addTwo(2);
+	`
+
+	s.testIntegerObject(s.eval(input), 4)
+}
+
+func (s *EvaluatorTestSuite) TestScopes() {
+	expectations := []struct {
+		Input    string
+		Expected interface{}
+	}{
+		{"let x = 1; if (true) { let x = 2; }; x", 1},
+		{"let x = 1; if (true) { let y = x + 1; y }", 2},
+		{"if (true) { let y = 1; }; y", "identifier not found: y"},
+		{"let x = 1; let f = fn() { let x = 2; x }; f() + x", 3},
+		{"let f = fn(x) { let x = x * 2; x }; f(2)", 4},
+		{"let f = fn() { g() }; let g = fn() { 1 }; f()", 1},
+	}
+
+	for _, e := range expectations {
+		evaluated := s.eval(e.Input)
+
+		switch expected := e.Expected.(type) {
+		case int:
+			s.testIntegerObject(evaluated, int64(expected))
+		case string:
+			err, ok := evaluated.(*object.Error)
+			s.True(ok, e.Input)
+			s.Equal(expected, err.Message)
+		}
+	}
+}
+
+func (s *EvaluatorTestSuite) TestStrings() {
+	expectations := []struct {
+		Input    string
+		Expected interface{}
+	}{
+		{`"Hello World!"`, "Hello World!"},
+		{`"Hello" + " " + "World!"`, "Hello World!"},
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" - "b"`, "unknown operator: STRING - STRING"},
+	}
+
+	for _, e := range expectations {
+		s.testObject(s.eval(e.Input), e.Expected, e.Input)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestArrays() {
+	expectations := []struct {
+		Input    string
+		Expected interface{}
+	}{
+		{"[1, 2 * 2, 3 + 3]", "[1, 4, 6]"},
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1 + 1]", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", nil},
+		{"[1, 2, 3][true]", "index operator not supported: ARRAY[BOOLEAN]"},
+		{"1[0]", "index operator not supported: INTEGER[INTEGER]"},
+	}
+
+	for _, e := range expectations {
+		evaluated := s.eval(e.Input)
+
+		if expected, ok := e.Expected.(string); ok && expected[0] == '[' {
+			s.Equal(expected, evaluated.Inspect())
+			continue
+		}
+
+		s.testObject(evaluated, e.Expected, e.Input)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestHashes() {
+	input := `let two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6,
+		"one": 7
+	}`
+
+	hash, ok := s.eval(input).(*object.Hash)
+	s.True(ok)
+	s.Equal(`{one: 7, two: 2, three: 3, 4: 4, true: 5, false: 6}`, hash.Inspect())
+
+	expectations := []struct {
+		Input    string
+		Expected interface{}
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{"name": "Monkey"}[fn(x) { x }];`, "unusable as hash key: FUNCTION"},
+		{`{fn(x) { x }: "Monkey"}`, "unusable as hash key: FUNCTION"},
+	}
+
+	for _, e := range expectations {
+		s.testObject(s.eval(e.Input), e.Expected, e.Input)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestBuiltinFunctions() {
+	expectations := []struct {
+		Input    string
+		Expected interface{}
+	}{
+		{`len("")`, 0},
+		{`len("four")`, 4},
+		{`len([1, 2, 3])`, 3},
+		{`len({"a": 1})`, 1},
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments to `len`: want=1, got=2"},
+		{`first([1, 2, 3])`, 1},
+		{`first([])`, nil},
+		{`first(1)`, "argument to `first` not supported, got INTEGER"},
+		{`last([1, 2, 3])`, 3},
+		{`last([])`, nil},
+		{`rest([1, 2, 3])[0]`, 2},
+		{`len(rest([1]))`, 0},
+		{`rest([])`, nil},
+		{`let a = [1]; let b = push(a, 2); len(a) + len(b)`, 3},
+		{`push(1, 1)`, "argument to `push` not supported, got INTEGER"},
+		{`type(1)`, "INTEGER"},
+		{`type(len)`, "BUILTIN"},
+		{`str(12) + str(true)`, "12true"},
+		{`str("a")`, "a"},
+		{`int("42") + int(true)`, 43},
+		{`int("4x2")`, `argument to ` + "`int`" + ` is not a valid integer: "4x2"`},
+		{`int([])`, "argument to `int` not supported, got ARRAY"},
+		{`let len = fn(x) { 42 }; len("a")`, 42},
+	}
+
+	for _, e := range expectations {
+		s.testObject(s.eval(e.Input), e.Expected, e.Input)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestBuiltinErrorPosition() {
+	err, ok := s.eval("let f = fn(x) {\n  len(x)\n};\nf(1)").(*object.Error)
+	s.True(ok)
+
+	s.Equal(object.TypeMismatch, err.Kind)
+	s.Equal("2:3: argument to `len` not supported, got INTEGER", err.Error())
+	s.Len(err.Stack, 2)
+	s.Equal("f", err.Stack[0].Name)
+	s.Equal("<main>", err.Stack[1].Name)
+}
+
+func (s *EvaluatorTestSuite) TestBuiltinRegistry() {
+	var out, errOut bytes.Buffer
+
+	e := New()
+	e.Builtins = object.CoreBuiltins(&out, &errOut, e.Alloc)
+	e.Builtins.Register("double", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	e.Builtins.Remove("len")
+
+	s.testIntegerObject(e.Eval(s.parse("double(21)"), object.NewEnvironment()), 42)
+	s.testObject(e.Eval(s.parse(`len("a")`), object.NewEnvironment()), "identifier not found: len", "len")
+
+	s.Equal(NULL, e.Eval(s.parse(`puts("hello", 1)`), object.NewEnvironment()))
+	s.Equal("hello\n1\n", out.String())
+
+	s.Equal(NULL, e.Eval(s.parse(`eputs("oops")`), object.NewEnvironment()))
+	s.Equal("oops\n", errOut.String())
+
+	s.testObject(s.eval(`double(1)`), "identifier not found: double", "double")
+}
+
+func (s *EvaluatorTestSuite) TestBind() {
+	type point struct {
+		X, Y int64
+	}
+
+	e := New()
+	s.NoError(e.Bind("sum", func(xs ...int64) int64 {
+		var total int64
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	}))
+	s.NoError(e.Bind("origin", func() point { return point{} }))
+	s.NoError(e.Bind("move", func(p point, dx int64) point { return point{X: p.X + dx, Y: p.Y} }))
+
+	env := object.NewEnvironment()
+
+	s.testIntegerObject(e.Eval(s.parse("sum(1, 2, 3)"), env), 6)
+	s.testIntegerObject(e.Eval(s.parse(`move(origin(), 5)["X"]`), env), 5)
+
+	err, ok := e.Eval(s.parse("let f = fn() { sum(1, true) };\nf()"), env).(*object.Error)
+	s.True(ok)
+	s.Equal("1:16: argument 2 to `sum`: cannot convert BOOLEAN to int64", err.Error())
+	s.Equal("f", err.Stack[0].Name)
+
+	s.Error(e.Bind("bad", "not a function"))
+}
+
+func (s *EvaluatorTestSuite) TestCall() {
+	type order struct {
+		Total int64 `monkey:"total"`
+		Items []string
+	}
+
+	e := New()
+	e.Eval(s.parse(`
+		let score = fn(order) { order["total"] * len(order["Items"]) };
+		let tags = fn(n) { if (n > 1) { ["bulk", n] } else { ["single"] } };
+		let broken = fn(x) { x + true };
+		let answer = 42;
+	`), e.Globals)
+
+	result, err := e.Call("score", order{Total: 10, Items: []string{"a", "b"}})
+	s.NoError(err)
+	s.Equal(int64(20), result)
+
+	result, err = e.Call("tags", 2)
+	s.NoError(err)
+	s.Equal([]interface{}{"bulk", int64(2)}, result)
+
+	result, err = e.Call("len", "four")
+	s.NoError(err)
+	s.Equal(int64(4), result)
+
+	_, err = e.Call("broken", 1)
+	var monkeyErr *object.Error
+	s.True(errors.As(err, &monkeyErr))
+	s.Equal(object.TypeMismatch, monkeyErr.Kind)
+	s.Equal([]object.Frame{{Name: "broken", Pos: monkeyErr.Pos}}, monkeyErr.Stack)
+
+	_, err = e.Call("answer")
+	s.EqualError(err, "not a function: INTEGER")
+
+	_, err = e.Call("missing")
+	s.EqualError(err, "identifier not found: missing")
+
+	_, err = e.Call("score")
+	s.EqualError(err, "wrong number of arguments: want=1, got=0")
+
+	_, err = e.Call("score", 1.5)
+	s.EqualError(err, "argument 1 to score: cannot convert Go value of type float64")
+}
+
+func (s *EvaluatorTestSuite) TestBindFunc() {
+	e := New()
+	e.Eval(s.parse(`
+		let pred = fn(n) { n > 10 };
+		let divide = fn(a, b) { a / b };
+		let sum = fn(a, b, c) { a + b + c };
+		let name = fn() { "monkey" };
+	`), e.Globals)
+
+	var pred func(int64) bool
+	s.NoError(e.BindFunc("pred", &pred))
+	s.True(pred(11))
+	s.False(pred(10))
+
+	var divide func(int, int) (int, error)
+	s.NoError(e.BindFunc("divide", &divide))
+
+	quotient, err := divide(7, 2)
+	s.NoError(err)
+	s.Equal(3, quotient)
+
+	_, err = divide(1, 0)
+	var monkeyErr *object.Error
+	s.True(errors.As(err, &monkeyErr))
+	s.Equal(object.DivisionByZero, monkeyErr.Kind)
+
+	var sum func(...int64) int64
+	s.NoError(e.BindFunc("sum", &sum))
+	s.Equal(int64(6), sum(1, 2, 3))
+	s.Panics(func() { sum(1, 2) })
+
+	var name func() (int64, error)
+	s.NoError(e.BindFunc("name", &name))
+	_, err = name()
+	s.EqualError(err, "result of name: cannot convert STRING to int64")
+
+	var wrongArity func(int64) int64
+	s.EqualError(
+		e.BindFunc("divide", &wrongArity),
+		"cannot bind divide: function takes 2 arguments, func(int64) int64 takes 1",
+	)
+	s.EqualError(e.BindFunc("pred", pred), "cannot bind pred: func(int64) bool is not a pointer to a function")
+	s.EqualError(e.BindFunc("missing", &pred), "identifier not found: missing")
+}
+
+func (s *EvaluatorTestSuite) TestPersistentGlobals() {
+	env := object.NewEnvironment()
+
+	s.Nil(Eval(s.parse("let counter = fn(x) { x + base }; let base = 10;"), env))
+	s.testIntegerObject(Eval(s.parse("counter(1)"), env), 11)
+
+	snapshot := env.Snapshot()
+	Eval(s.parse("let base = 20;"), env)
+	s.testIntegerObject(Eval(s.parse("counter(1)"), env), 21)
+
+	env.Restore(snapshot)
+	s.testIntegerObject(Eval(s.parse("counter(1)"), env), 11)
+}
+
+func (s *EvaluatorTestSuite) parse(input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	s.Empty(p.Errors())
+
+	return program
+}
+
+func (s *EvaluatorTestSuite) eval(input string) object.Object {
+	return Eval(s.parse(input), object.NewEnvironment())
+}
+
+func (s *EvaluatorTestSuite) testObject(obj object.Object, expected interface{}, input string) {
+	switch expected := expected.(type) {
+	case nil:
+		s.Equal(NULL, obj, input)
+	case int:
+		s.testIntegerObject(obj, int64(expected))
+	case bool:
+		s.testBooleanObject(obj, expected)
+	case string:
+		switch obj := obj.(type) {
+		case *object.String:
+			s.Equal(expected, obj.Value, input)
+		case *object.Error:
+			s.Equal(expected, obj.Message, input)
+		default:
+			s.Failf("unexpected object", "%s: got=%T (%+v)", input, obj, obj)
+		}
+	}
+}
+
+func (s *EvaluatorTestSuite) testIntegerObject(obj object.Object, expected int64) {
+	result, ok := obj.(*object.Integer)
+	s.True(ok, "object is not Integer. got=%T (%+v)", obj, obj)
+
+	if ok {
+		s.Equal(expected, result.Value)
+	}
+}
+
+func (s *EvaluatorTestSuite) testBooleanObject(obj object.Object, expected bool) {
+	result, ok := obj.(*object.Boolean)
+	s.True(ok, "object is not Boolean. got=%T (%+v)", obj, obj)
+
+	if ok {
+		s.Equal(expected, result.Value)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestStackOverflow() {
+	e := New()
+	e.MaxDepth = 100
+
+	err, ok := e.Eval(s.parse("let f = fn(n) { 1 + f(n + 1) };\nf(0)"), e.Globals).(*object.Error)
+	s.True(ok)
+	s.Equal(object.StackOverflow, err.Kind)
+	s.True(errors.Is(err, object.ErrStackOverflow))
+	s.Equal("1:21: stack overflow: maximum call depth of 100 exceeded", err.Error())
+	s.Len(err.Stack, 101)
+
+	trace := strings.Split(err.StackTrace(), "\n")
+	s.Len(trace, 22)
+	s.Equal("\t... 81 more frames", trace[11])
+	s.Equal("\tat <main> (2:1)", trace[21])
+
+	s.testIntegerObject(e.Eval(s.parse("let g = fn(n) { if (n > 0) { g(n - 1) } else { n } }; g(99)"), e.Globals), 0)
+
+	// Calls in tail position take the place of their caller, so unbounded
+	// tail recursion runs until the step limit stops it instead.
+	e.MaxSteps = 10000
+	err, ok = e.Eval(s.parse("let f = fn(n) { f(n + 1) };\nf(0)"), e.Globals).(*object.Error)
+	s.True(ok)
+	s.Equal(object.StepLimit, err.Kind)
+	s.Equal([]object.Frame{
+		{Name: "f", Pos: token.Position{Offset: 18, Line: 1, Column: 19}, Elided: 1665},
+		{Name: "<main>", Pos: token.Position{Offset: 28, Line: 2, Column: 1}},
+	}, err.Stack)
+}
+
+func (s *EvaluatorTestSuite) TestStepLimit() {
+	e := New()
+	e.MaxSteps = 50
+
+	err, ok := e.Eval(s.parse("let f = fn(n) { if (n > 0) { f(n - 1) } }; f(10)"), e.Globals).(*object.Error)
+	s.True(ok)
+	s.Equal(object.StepLimit, err.Kind)
+	s.True(errors.Is(err, object.ErrStepLimit))
+	s.False(errors.Is(err, object.ErrTimeout))
+
+	s.Equal(NULL, e.Eval(s.parse("f(3)"), e.Globals), "steps are counted per evaluation")
+
+	_, callErr := e.Call("f", 10)
+	s.True(errors.Is(callErr, object.ErrStepLimit))
+}
+
+func (s *EvaluatorTestSuite) TestContextCancellation() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	e := New()
+	s.NoError(e.Bind("sleep", func() { time.Sleep(time.Millisecond) }))
+
+	err, ok := e.EvalContext(ctx, s.parse("let loop = fn(n) { sleep(); loop(n + 1) }; loop(0)"), e.Globals).(*object.Error)
+	s.True(ok)
+	s.Equal(object.Timeout, err.Kind)
+	s.True(errors.Is(err, object.ErrTimeout))
+	s.True(errors.Is(err, context.DeadlineExceeded))
+	s.Equal("execution stopped: context deadline exceeded", err.Message)
+}
+
+func (s *EvaluatorTestSuite) TestMemoryQuota() {
+	e := New()
+	e.Alloc.Quota = 10000
+
+	program := s.parse("let grow = fn(arr, n) { if (n > 0) { grow(push(arr, n), n - 1) } else { arr } };\ngrow([], 1000)")
+	err, ok := e.Eval(program, e.Globals).(*object.Error)
+	s.True(ok)
+	s.Equal(object.MemoryLimit, err.Kind)
+	s.True(errors.Is(err, object.ErrMemoryLimit))
+	s.Equal("memory quota of 10000 bytes exceeded", err.Message)
+	s.Equal("grow", err.Stack[0].Name)
+
+	e = New()
+	e.Alloc.Quota = 1000
+	err, ok = e.Eval(s.parse(`let s = "abcdefghij"; s + s + s + s + s + s + s + s + s + s + s + s + s + s + s + s`), e.Globals).(*object.Error)
+	s.True(ok)
+	s.Equal(object.MemoryLimit, err.Kind)
+}
+
+func (s *EvaluatorTestSuite) TestAllocationCounters() {
+	e := New()
+	s.NoError(e.Bind("pair", func() []int64 { return []int64{1, 2} }))
+
+	e.Eval(s.parse("1 + 2"), e.Globals)
+	s.Equal(object.AllocStats{Objects: 3, Bytes: 72}, e.Alloc.Stats())
+
+	e.Alloc.Reset()
+	e.Eval(s.parse("pair()"), e.Globals)
+	s.Equal(object.AllocStats{Objects: 1, Bytes: 120}, e.Alloc.Stats())
+}
+
+func (s *EvaluatorTestSuite) TestGas() {
+	expectations := []struct {
+		Input    string
+		Expected uint64
+	}{
+		{"1", 0},
+		{"1 + 2 * 3", 2},
+		{"-1", 1},
+		{"[1, 2, 3][0]", 5},
+		{`{"a": 1, "b": 2}["a"]`, 4},
+		{"let f = fn(x) { x + 1 }; f(1) + f(2)", 23},
+		{"len([1, 2])", 12},
+		{"if (1 < 2) { 3 } else { 4 }", 1},
+		{"push([1, 2], 3)", 15},
+		{"rest([1, 2, 3])", 15},
+		{"rest([])", 10},
+		{`"ab" + "c"`, 4},
+	}
+
+	for _, e := range expectations {
+		ev := New()
+		ev.Gas = gas.NewMeter(gas.Table{Infix: 1, Prefix: 1, Call: 10, Builtin: 10, Index: 2, Element: 1, Byte: 1}, 0)
+
+		result := ev.Eval(s.parse(e.Input), ev.Globals)
+		s.False(isError(result), e.Input)
+		s.Equal(e.Expected, ev.Gas.Used(), e.Input)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestOutOfGas() {
+	e := New()
+	e.Gas = gas.NewMeter(gas.DefaultTable, 100)
+
+	err, ok := e.Eval(s.parse("let f = fn(n) { if (n > 0) { f(n - 1) } };\nf(20)"), e.Globals).(*object.Error)
+	s.True(ok)
+	s.Equal(object.OutOfGas, err.Kind)
+	s.True(errors.Is(err, object.ErrOutOfGas))
+	s.Equal("out of gas: limit of 100 exceeded", err.Message)
+	s.Equal(uint64(0), e.Gas.Remaining())
+
+	s.Equal(NULL, e.Eval(s.parse("f(2)"), e.Globals), "gas is metered per evaluation")
+	s.Equal(uint64(100-35), e.Gas.Remaining())
+}
+
+func (s *EvaluatorTestSuite) TestTailCalls() {
+	e := New()
+	e.MaxDepth = 10
+
+	expectations := []struct {
+		Input    string
+		Expected int64
+	}{
+		{"let loop = fn(n) { if (n == 0) { return 0 }; loop(n - 1) }; loop(1000000)", 0},
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0)", 5000050000},
+		{`
+let even = fn(n) { if (n == 0) { return true }; return odd(n - 1) };
+let odd = fn(n) { if (n == 0) { return false }; return even(n - 1) };
+if (even(100001)) { 1 } else { 2 }`, 2},
+		{"let f = fn(n) { if (n > 0) { let m = n - 1; return f(m); }; 7 }; f(100000)", 7},
+	}
+
+	for _, expected := range expectations {
+		s.testIntegerObject(e.Eval(s.parse(expected.Input), e.Globals), expected.Expected)
+	}
+}
+
+func (s *EvaluatorTestSuite) TestTailCallStackDepth() {
+	e := New()
+	s.NoError(e.Bind("depth", func() int64 {
+		return int64(runtime.Callers(0, make([]uintptr, 4096)))
+	}))
+
+	e.Eval(s.parse("let f = fn(n) { if (n == 0) { depth() } else { f(n - 1) } };"), e.Globals)
+
+	shallow := e.Eval(s.parse("f(10)"), e.Globals).(*object.Integer).Value
+	deep := e.Eval(s.parse("f(5000)"), e.Globals).(*object.Integer).Value
+	s.Equal(shallow, deep)
+
+	e.Eval(s.parse("let g = fn(n) { if (n == 0) { depth() } else { 0 + g(n - 1) } };"), e.Globals)
+	s.Greater(e.Eval(s.parse("g(10)"), e.Globals).(*object.Integer).Value, shallow, "calls outside tail position nest")
+}
+
+func (s *EvaluatorTestSuite) TestTailCallStackTrace() {
+	err, ok := s.eval("let b = fn(x) { x + true };\nlet a = fn(x) { b(x) };\n1 + a(1)").(*object.Error)
+	s.True(ok)
+	s.Equal("1:19: type mismatch: INTEGER + BOOLEAN\n\tat b (1:19)\n\t... 1 tail call elided\n\tat <main> (3:5)", err.StackTrace())
+}
@@ -0,0 +1,88 @@
+package evaluator
+
+import (
+	"context"
+
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/token"
+)
+
+// Bind exposes the Go function fn to Monkey code as the builtin name, see
+// object.NewGoBuiltin for how values are converted. The objects its results
+// are converted to count as allocations of e.Alloc.
+func (e *Evaluator) Bind(name string, fn interface{}) error {
+	builtin, err := object.NewGoBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+
+	call := builtin.Fn
+	builtin.Fn = func(args ...object.Object) object.Object {
+		result := call(args...)
+		if isError(result) {
+			return result
+		}
+
+		if err := e.Alloc.Alloc(object.DeepSizeOf(result)); err != nil {
+			return err
+		}
+
+		return result
+	}
+
+	e.Builtins.Add(builtin)
+
+	return nil
+}
+
+// Call calls the function bound to name in e.Globals with args converted by
+// object.ToObject. The result is converted to int64, bool, string,
+// []interface{}, map[string]interface{} or nil; a Monkey runtime error is
+// returned as an *object.Error.
+func (e *Evaluator) Call(name string, args ...interface{}) (interface{}, error) {
+	return e.Caller().Call(name, args...)
+}
+
+// CallContext calls the function bound to name like Call and ends the call
+// with a Timeout error once ctx is done.
+func (e *Evaluator) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	return e.Caller().CallContext(ctx, name, args...)
+}
+
+// BindFunc sets the function variable fnPtr points to, such as a
+// *func(int64) bool, to a Go function that calls the Monkey function bound
+// to name in e.Globals, see object.Caller.
+func (e *Evaluator) BindFunc(name string, fnPtr interface{}) error {
+	return e.Caller().BindFunc(name, fnPtr)
+}
+
+// Caller returns the object.Caller that calls the functions of e on behalf
+// of the host.
+func (e *Evaluator) Caller() object.Caller {
+	return object.Caller{Lookup: e.lookupFunction, Apply: e.call}
+}
+
+// call applies fn on behalf of the host, which is not a Monkey frame. A
+// call made while another one is running, by a builtin, runs under the
+// context of that one.
+func (e *Evaluator) call(ctx context.Context, fn object.Object, args []object.Object) object.Object {
+	defer e.begin(ctx)()
+
+	return e.applyFunction(token.Position{}, fn, args)
+}
+
+func (e *Evaluator) lookupFunction(name string) (object.Object, error) {
+	fn, ok := e.Globals.Get(name)
+	if !ok {
+		if fn, ok = e.Builtins.Lookup(name); !ok {
+			return nil, object.NewError(object.UnknownIdentifier, "identifier not found: %s", name)
+		}
+	}
+
+	switch fn.(type) {
+	case *object.Function, *object.Builtin:
+		return fn, nil
+	}
+
+	return nil, object.NewError(object.NotAFunction, "not a function: %s", fn.Type())
+}
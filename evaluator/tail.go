@@ -0,0 +1,100 @@
+package evaluator
+
+import (
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/token"
+)
+
+// A tailCall is a call in tail position that has not been made yet. It only
+// ever travels from the body of a function back to applyFunction.
+type tailCall struct {
+	pos  token.Position
+	fn   object.Object
+	args []object.Object
+}
+
+func (*tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (*tailCall) Inspect() string         { return "tail call" }
+
+// evalBody evaluates the statements of a function body, or of a block
+// inside it that is evaluated as a statement. The operand of a return
+// statement is always in tail position; the last expression of the block
+// is when tail is set.
+func (e *Evaluator) evalBody(block *ast.BlockStatement, env *object.Environment, tail bool) object.Object {
+	var result object.Object
+
+	for i, stmt := range block.Statements {
+		last := tail && i == len(block.Statements)-1
+
+		switch stmt := stmt.(type) {
+		case *ast.ReturnStatement:
+			if err := e.step(stmt); err != nil {
+				return err
+			}
+			result = e.evalTail(stmt.ReturnValue, env, true)
+			if !isAbrupt(result) {
+				result = &object.ReturnValue{Value: result}
+			}
+		case *ast.ExpressionStatement:
+			if err := e.step(stmt); err != nil {
+				return err
+			}
+			result = e.evalTail(stmt.Expression, env, last)
+		default:
+			result = e.eval(stmt, env)
+		}
+
+		if result != nil {
+			rt := result.Type()
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+// evalTail evaluates exp, deferring it to the caller as a *tailCall when it
+// is a call of a Monkey function and tail is set. Builtins are called right
+// away so that their errors are reported in the frame that called them.
+// The branches of an if expression are bodies in their own right: returns
+// in them are tail calls either way.
+func (e *Evaluator) evalTail(exp ast.Expression, env *object.Environment, tail bool) object.Object {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		if !tail || len(e.frames) == 0 {
+			break
+		}
+		if err := e.step(exp); err != nil {
+			return err
+		}
+		function, args, err := e.evalCall(exp, env)
+		if err != nil {
+			return err
+		}
+		if _, ok := function.(*object.Builtin); ok {
+			return e.applyFunction(exp.Pos(), function, args)
+		}
+		return &tailCall{pos: exp.Pos(), fn: function, args: args}
+	case *ast.IfExpression:
+		if err := e.step(exp); err != nil {
+			return err
+		}
+		block, err := e.evalCondition(exp, env)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return NULL
+		}
+		scope, err := e.enclose(block, env)
+		if err != nil {
+			return err
+		}
+		return nullIfNil(e.evalBody(block, scope, tail))
+	}
+
+	return e.eval(exp, env)
+}
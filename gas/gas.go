@@ -0,0 +1,136 @@
+// Package gas meters the cost of running Monkey programs. Costs are charged
+// per operation of the language rather than per node or instruction, so a
+// program costs the same whichever engine runs it.
+package gas
+
+import (
+	"math"
+	"math/bits"
+)
+
+// The kinds of operations that cost gas.
+const (
+	Infix Op = iota
+	Prefix
+	Call
+	Builtin
+	Index
+	Element
+	Byte
+)
+
+type (
+	Op int
+
+	// A Table holds the cost of each kind of operation.
+	Table struct {
+		// Infix is charged for every infix operator applied, Prefix for
+		// every prefix operator.
+		Infix  uint64
+		Prefix uint64
+		// Call is charged for every call of a Monkey function, Builtin for
+		// every call of a builtin.
+		Call    uint64
+		Builtin uint64
+		// Index is charged for every index expression.
+		Index uint64
+		// Element is charged per element of every array literal, per pair
+		// of every hash literal and per element a builtin such as push
+		// copies.
+		Element uint64
+		// Byte is charged per byte of every string built by +.
+		Byte uint64
+	}
+
+	// A Meter charges gas against a limit; a Limit of zero means no limit.
+	// A nil *Meter charges nothing.
+	Meter struct {
+		Table Table
+		Limit uint64
+		used  uint64
+	}
+)
+
+var DefaultTable = Table{
+	Infix:   1,
+	Prefix:  1,
+	Call:    10,
+	Builtin: 10,
+	Index:   2,
+	Element: 1,
+	Byte:    1,
+}
+
+func NewMeter(table Table, limit uint64) *Meter {
+	return &Meter{Table: table, Limit: limit}
+}
+
+// Cost returns the cost of a single operation of kind op.
+func (t *Table) Cost(op Op) uint64 {
+	switch op {
+	case Infix:
+		return t.Infix
+	case Prefix:
+		return t.Prefix
+	case Call:
+		return t.Call
+	case Builtin:
+		return t.Builtin
+	case Index:
+		return t.Index
+	case Element:
+		return t.Element
+	case Byte:
+		return t.Byte
+	}
+
+	return 0
+}
+
+// Charge charges for n operations of kind op and reports whether that was
+// within the limit. A charge that is not uses up all remaining gas.
+func (m *Meter) Charge(op Op, n int) bool {
+	if m == nil {
+		return true
+	}
+
+	cost := m.Table.Cost(op)
+
+	if m.Limit > 0 && cost > 0 && uint64(n) > (m.Limit-m.used)/cost {
+		m.used = m.Limit
+		return false
+	}
+
+	hi, total := bits.Mul64(cost, uint64(n))
+	if used, carry := bits.Add64(m.used, total, 0); hi == 0 && carry == 0 {
+		m.used = used
+	} else {
+		m.used = math.MaxUint64
+	}
+
+	return true
+}
+
+func (m *Meter) Used() uint64 {
+	if m == nil {
+		return 0
+	}
+
+	return m.used
+}
+
+// Remaining returns the gas left before the limit is reached, zero when
+// there is no limit.
+func (m *Meter) Remaining() uint64 {
+	if m == nil || m.Limit == 0 {
+		return 0
+	}
+
+	return m.Limit - m.used
+}
+
+func (m *Meter) Reset() {
+	if m != nil {
+		m.used = 0
+	}
+}
@@ -0,0 +1,61 @@
+package gas
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type GasTestSuite struct {
+	suite.Suite
+}
+
+func TestGasTestSuite(t *testing.T) {
+	suite.Run(t, new(GasTestSuite))
+}
+
+func (s *GasTestSuite) TestCharge() {
+	m := NewMeter(Table{Infix: 2, Element: 3}, 20)
+
+	s.True(m.Charge(Infix, 1))
+	s.True(m.Charge(Element, 4))
+	s.True(m.Charge(Call, 100), "operations without a cost are free")
+	s.Equal(uint64(14), m.Used())
+	s.Equal(uint64(6), m.Remaining())
+
+	s.True(m.Charge(Infix, 3))
+	s.Equal(uint64(0), m.Remaining())
+	s.False(m.Charge(Infix, 1))
+	s.Equal(uint64(20), m.Used())
+
+	m.Reset()
+	s.Equal(uint64(20), m.Remaining())
+}
+
+func (s *GasTestSuite) TestExhausted() {
+	m := NewMeter(DefaultTable, 15)
+
+	s.False(m.Charge(Call, 2))
+	s.Equal(uint64(15), m.Used())
+	s.Equal(uint64(0), m.Remaining())
+
+	m = NewMeter(Table{Element: 1 << 32}, 1000)
+	s.False(m.Charge(Element, 1<<32), "the cost overflows")
+	s.Equal(uint64(1000), m.Used())
+}
+
+func (s *GasTestSuite) TestUnlimited() {
+	m := NewMeter(DefaultTable, 0)
+
+	s.True(m.Charge(Builtin, 1000))
+	s.Equal(uint64(10000), m.Used())
+	s.Equal(uint64(0), m.Remaining())
+
+	s.True(m.Charge(Builtin, math.MaxInt))
+	s.Equal(uint64(math.MaxUint64), m.Used(), "the gas used saturates")
+
+	var nilMeter *Meter
+	s.True(nilMeter.Charge(Call, 1))
+	s.Equal(uint64(0), nilMeter.Used())
+}
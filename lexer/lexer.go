@@ -1,71 +1,187 @@
 package lexer
 
 import (
+	"io"
+	"iter"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/marcel/monkey/token"
 )
 
+// readSize is the least number of bytes a Lexer reading from an
+// io.Reader reads at once.
+const readSize = 4096
+
+// A Lexer splits its input into tokens. Positions in input are relative
+// to offset, the number of bytes of the input dropped before it, and
+// start is where the token being read starts. trivia and text are the
+// source of the last token read.
 type Lexer struct {
 	input        string
+	offset       int
+	start        int
+	trivia       string
+	text         string
 	position     int
 	readPosition int
 	ch           byte
+	line         int
+	column       int
+	names        map[string]string
+	reader       io.Reader
+	err          error
 }
 
 func New(input string) *Lexer {
-	lex := &Lexer{input: input}
+	lex := &Lexer{input: input, line: 1, names: map[string]string{}}
+	lex.readChar()
+
+	return lex
+}
+
+// NewReader returns a Lexer reading its input from r as it needs it.
+// Only the token being read is held in memory, beyond the tokens returned.
+// The input ends where r returns an error; Err reports it unless it is
+// io.EOF.
+func NewReader(r io.Reader) *Lexer {
+	lex := &Lexer{line: 1, names: map[string]string{}, reader: r}
 	lex.readChar()
 
 	return lex
 }
 
 func (l *Lexer) NextToken() token.Token {
+	l.start = l.position
 	l.skipWhitespace()
+	l.trivia = l.input[l.start:l.position]
 
-	t, ok := token.SingleByteLiteralToType[l.ch]
-	if ok {
-		defer l.readChar()
-		return t.Token(string(l.ch))
-	}
+	position := token.Position{Offset: l.offset + l.position, Line: l.line, Column: l.column}
+	l.start = l.position
+	tok := l.nextToken()
+	tok.Position = position
+	l.text = l.input[l.start:l.position]
 
-	switch l.ch {
-	case '=':
-		defer l.readChar()
-		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			return token.EQ.Token(string(ch) + string(l.ch))
-		}
-		return token.ASSIGN.Token(string(l.ch))
-	case '!':
-		defer l.readChar()
-		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			return token.NOT_EQ.Token(string(ch) + string(l.ch))
+	return tok
+}
+
+// Source returns the source of the last token NextToken returned, as it is
+// in the input: the whitespace before it and the token itself.
+func (l *Lexer) Source() (trivia, text string) {
+	return l.trivia, l.text
+}
+
+// Tokens returns the tokens up to the end of the input, not including
+// EOF.
+func (l *Lexer) Tokens() iter.Seq[token.Token] {
+	return func(yield func(token.Token) bool) {
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			if !yield(tok) {
+				return
+			}
 		}
-		return token.BANG.Token(string(l.ch))
+	}
+}
+
+// Err returns the error reading the input failed with, if any.
+func (l *Lexer) Err() error {
+	return l.err
+}
+
+func (l *Lexer) nextToken() token.Token {
+	// A NUL byte only ends the input at its end.
+	switch t := token.LookupByte(l.ch); {
+	case t == token.EOF && l.position >= len(l.input):
+		return t.Token("\x00")
+	case t != token.ILLEGAL && t != token.EOF:
+		l.readChar()
+		return t.Token(l.input[l.start:l.position])
 	}
 
 	switch {
+	case l.ch == '=':
+		return l.readOperator(token.ASSIGN, token.EQ)
+	case l.ch == '!':
+		return l.readOperator(token.BANG, token.NOT_EQ)
+	case l.ch == '"':
+		return l.readString()
 	case isLetter(l.ch):
 		literal := l.readIdentifier()
-		return token.LookupIdent(literal).Token(literal)
+		if t := token.LookupIdent(literal); t != token.IDENT {
+			return t.Token(literal)
+		}
+		return token.IDENT.Token(l.intern(literal))
 	case isDigit(l.ch):
 		return token.INT.Token(l.readNumber())
 	}
 
-	defer l.readChar()
-	return token.ILLEGAL.Token(string(l.ch))
+	return l.readIllegal()
 }
 
-func (l *Lexer) readWhile(predicate func(byte) bool) string {
-	position := l.position
+// readOperator reads an operator of type t, or of type withEquals when it
+// is followed by '='.
+func (l *Lexer) readOperator(t, withEquals token.Type) token.Token {
+	l.readChar()
+	if l.ch == '=' {
+		l.readChar()
+		t = withEquals
+	}
+
+	return t.Token(l.input[l.start:l.position])
+}
+
+// intern returns the copy of the identifier name the lexer returned
+// before, if any, so that a name is held in memory once however often it
+// occurs.
+func (l *Lexer) intern(name string) string {
+	if interned, ok := l.names[name]; ok {
+		return interned
+	}
+
+	name = strings.Clone(name)
+	l.names[name] = name
+
+	return name
+}
 
+// readIllegal reads a character that starts no token, which may take up
+// more than one byte.
+func (l *Lexer) readIllegal() token.Token {
+	l.fill(l.position + utf8.UTFMax)
+
+	_, size := utf8.DecodeRuneInString(l.input[l.position:])
+	for range max(size, 1) {
+		l.readChar()
+	}
+
+	return token.ILLEGAL.Token(l.input[l.start:l.position])
+}
+
+// readWhile reads the token as long as predicate holds for its characters.
+func (l *Lexer) readWhile(predicate func(byte) bool) string {
 	for predicate(l.ch) {
 		l.readChar()
 	}
 
-	return l.input[position:l.position]
+	return l.input[l.start:l.position]
+}
+
+// readString reads a double-quoted string literal. A string that is still
+// open at the end of the input is returned as an ILLEGAL token.
+func (l *Lexer) readString() token.Token {
+	for {
+		l.readChar()
+
+		if l.ch == '"' {
+			literal := l.input[l.start+1 : l.position]
+			l.readChar()
+			return token.STRING.Token(literal)
+		}
+
+		if l.ch == 0 && l.position >= len(l.input) {
+			return token.ILLEGAL.Token(l.input[l.start:])
+		}
+	}
 }
 
 func (l *Lexer) readNumber() string {
@@ -83,6 +199,8 @@ func (l *Lexer) skipWhitespace() {
 }
 
 func (l *Lexer) peekChar() byte {
+	l.fill(l.readPosition + 1)
+
 	if l.readPosition >= len(l.input) {
 		return 0
 	}
@@ -91,6 +209,17 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) readChar() {
+	l.fill(l.readPosition + 1)
+
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
+
+	if l.readPosition <= len(l.input) {
+		l.column++
+	}
+
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -101,6 +230,41 @@ func (l *Lexer) readChar() {
 	l.readPosition++
 }
 
+// fill reads from the reader, if any, until the input holds n bytes or
+// the reader is done. The input before the token being read is dropped
+// first.
+func (l *Lexer) fill(n int) {
+	if l.reader == nil || len(l.input) >= n {
+		return
+	}
+
+	kept := l.input[l.start:]
+	n -= l.start
+	l.offset += l.start
+	l.position -= l.start
+	l.readPosition -= l.start
+	l.start = 0
+
+	// Reading at least as much as is kept makes a long token take time
+	// linear in its length.
+	buf := make([]byte, len(kept), len(kept)+max(readSize, len(kept), n-len(kept)))
+	copy(buf, kept)
+
+	for l.reader != nil && len(buf) < n {
+		read, err := l.reader.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+read]
+
+		if err != nil {
+			if err != io.EOF {
+				l.err = err
+			}
+			l.reader = nil
+		}
+	}
+
+	l.input = string(buf)
+}
+
 func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
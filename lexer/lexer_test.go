@@ -1,7 +1,14 @@
 package lexer
 
 import (
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unsafe"
 
 	t "github.com/marcel/monkey/token"
 	"github.com/stretchr/testify/suite"
@@ -39,6 +46,11 @@ func (s *LexerTestSuite) TestNextToken() {
 		10 == 10;
 
 		10 != 9;
+
+		"foobar"
+		"foo bar"
+		[1, 2];
+		{"foo": "bar"}
 	`
 
 	expectations := []struct {
@@ -75,6 +87,14 @@ func (s *LexerTestSuite) TestNextToken() {
 		{t.INT, "10"}, {t.EQ, "=="}, {t.INT, "10"}, {t.SEMICOLON, ";"},
 		// 10 != 9;
 		{t.INT, "10"}, {t.NOT_EQ, "!="}, {t.INT, "9"}, {t.SEMICOLON, ";"},
+		// "foobar"
+		{t.STRING, "foobar"},
+		// "foo bar"
+		{t.STRING, "foo bar"},
+		// [1, 2];
+		{t.LBRACKET, "["}, {t.INT, "1"}, {t.COMMA, ","}, {t.INT, "2"}, {t.RBRACKET, "]"}, {t.SEMICOLON, ";"},
+		// {"foo": "bar"}
+		{t.LBRACE, "{"}, {t.STRING, "foo"}, {t.COLON, ":"}, {t.STRING, "bar"}, {t.RBRACE, "}"},
 		// EOF
 		{t.EOF, "\x00"},
 	}
@@ -88,3 +108,211 @@ func (s *LexerTestSuite) TestNextToken() {
 		s.Equal(e.Literal, tok.Literal)
 	}
 }
+
+func (s *LexerTestSuite) TestNextTokenPosition() {
+	input := "let x = 5;\n  x == 10;\n"
+
+	expectations := []struct {
+		Type   t.Type
+		Line   int
+		Column int
+	}{
+		{t.LET, 1, 1}, {t.IDENT, 1, 5}, {t.ASSIGN, 1, 7}, {t.INT, 1, 9}, {t.SEMICOLON, 1, 10},
+		{t.IDENT, 2, 3}, {t.EQ, 2, 5}, {t.INT, 2, 8}, {t.SEMICOLON, 2, 10},
+		{t.EOF, 3, 1},
+	}
+
+	l := New(input)
+
+	for _, e := range expectations {
+		tok := l.NextToken()
+
+		s.Equal(e.Type, tok.Type)
+		s.Equal(e.Line, tok.Position.Line)
+		s.Equal(e.Column, tok.Position.Column)
+	}
+}
+
+func (s *LexerTestSuite) TestUnterminatedString() {
+	l := New(`"foo`)
+
+	tok := l.NextToken()
+	s.Equal(t.ILLEGAL, tok.Type)
+	s.Equal(`"foo`, tok.Literal)
+
+	s.Equal(t.EOF, l.NextToken().Type)
+}
+
+func (s *LexerTestSuite) TestNulByte() {
+	l := New("a\x00b")
+
+	s.Equal(t.IDENT, l.NextToken().Type)
+
+	tok := l.NextToken()
+	s.Equal(t.ILLEGAL, tok.Type)
+	s.Equal("\x00", tok.Literal)
+
+	s.Equal(t.IDENT, l.NextToken().Type)
+	s.Equal(t.EOF, l.NextToken().Type)
+}
+
+func (s *LexerTestSuite) TestIllegalCharacters() {
+	l := New("@é\xff")
+
+	for _, literal := range []string{"@", "é", "\xff"} {
+		tok := l.NextToken()
+		s.Equal(t.ILLEGAL, tok.Type)
+		s.Equal(literal, tok.Literal)
+	}
+
+	s.Equal(t.EOF, l.NextToken().Type)
+}
+
+func (s *LexerTestSuite) TestInternedIdentifiers() {
+	l := New("total + total")
+
+	first := l.NextToken()
+	l.NextToken()
+	second := l.NextToken()
+
+	s.Equal("total", first.Literal)
+	s.Same(unsafe.StringData(first.Literal), unsafe.StringData(second.Literal))
+}
+
+func (s *LexerTestSuite) TestReader() {
+	long := strings.Repeat("abc", readSize)
+
+	for _, input := range []string{
+		"",
+		"let five = 5;\nlet add = fn(x, y) {\n  x + y;\n};\n",
+		`"foo" "unterminated`,
+		"a\x00b @é\xff == !=",
+		long + " " + `"` + long + `" ` + long,
+	} {
+		want := slices.Collect(New(input).Tokens())
+
+		for _, r := range []io.Reader{
+			strings.NewReader(input),
+			iotest.OneByteReader(strings.NewReader(input)),
+			iotest.HalfReader(strings.NewReader(input)),
+			iotest.DataErrReader(strings.NewReader(input)),
+		} {
+			l := NewReader(r)
+			s.Equal(want, slices.Collect(l.Tokens()))
+			s.Equal(t.EOF, l.NextToken().Type)
+			s.NoError(l.Err())
+		}
+	}
+}
+
+func (s *LexerTestSuite) TestReaderError() {
+	err := errors.New("broken")
+	l := NewReader(io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(err)))
+
+	s.Equal([]t.Type{t.LET, t.IDENT}, types(l.Tokens()))
+	s.ErrorIs(l.Err(), err)
+}
+
+func (s *LexerTestSuite) TestTokens() {
+	l := New("let x = 5;")
+
+	for tok := range l.Tokens() {
+		s.Equal(t.LET, tok.Type)
+		break
+	}
+
+	s.Equal([]t.Type{t.IDENT, t.ASSIGN, t.INT, t.SEMICOLON}, types(l.Tokens()))
+	s.Empty(types(l.Tokens()))
+}
+
+func types(tokens iter.Seq[t.Token]) []t.Type {
+	var types []t.Type
+	for tok := range tokens {
+		types = append(types, tok.Type)
+	}
+
+	return types
+}
+
+func FuzzNextToken(f *testing.F) {
+	for _, seed := range []string{
+		"let five = 5;\nlet add = fn(x, y) { x + y; };",
+		"!-/*5; 5 < 10 > 5; 10 == 10; 10 != 9;",
+		`"foo" "foo bar" "unterminated`,
+		`{"foo": "bar"}[0]`,
+		"if (a) {\r\n\treturn true;\n} else { return false }",
+		"@#$ \x00 \xff\xfe é",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(tt *testing.T, input string) {
+		l := New(input)
+		previous := -1
+
+		// Every token but EOF starts after the one before it, so there are
+		// at most as many as there are bytes.
+		for range len(input) + 1 {
+			tok := l.NextToken()
+			offset := tok.Position.Offset
+
+			if offset <= previous || offset > len(input) {
+				tt.Fatalf("token %q at offset %d after offset %d", tok.Literal, offset, previous)
+			}
+			previous = offset
+
+			line := 1 + strings.Count(input[:offset], "\n")
+			column := offset - strings.LastIndexByte(input[:offset], '\n')
+			if tok.Position.Line != line || tok.Position.Column != column {
+				tt.Fatalf("token %q at %s, want %d:%d", tok.Literal, tok.Position, line, column)
+			}
+
+			if tok.Type == t.EOF {
+				if offset != len(input) {
+					tt.Fatalf("EOF at offset %d of %d", offset, len(input))
+				}
+				return
+			}
+
+			source := input[offset:]
+			if tok.Type == t.STRING {
+				source = source[1:]
+			}
+			if !strings.HasPrefix(source, tok.Literal) {
+				tt.Fatalf("token %q at offset %d is not in the input", tok.Literal, offset)
+			}
+		}
+
+		tt.Fatal("no EOF")
+	})
+}
+
+func FuzzNewReader(f *testing.F) {
+	f.Add("let five = 5;\n\"foo\" @é\xff", uint8(1))
+
+	f.Fuzz(func(tt *testing.T, input string, size uint8) {
+		want := slices.Collect(New(input).Tokens())
+		got := slices.Collect(NewReader(&chunkReader{input: input, size: int(size)}).Tokens())
+
+		if !slices.Equal(want, got) {
+			tt.Fatalf("read %v in chunks of %d, want %v", got, size, want)
+		}
+	})
+}
+
+// A chunkReader reads input at most size bytes at a time.
+type chunkReader struct {
+	input string
+	size  int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.input == "" {
+		return 0, io.EOF
+	}
+
+	n := copy(p[:min(len(p), max(r.size, 1))], r.input)
+	r.input = r.input[n:]
+
+	return n, nil
+}
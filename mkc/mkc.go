@@ -0,0 +1,353 @@
+// Package mkc reads and writes compiled Monkey programs, the .mkc files
+// monkey build produces.
+//
+// A file starts with a header: the magic bytes "\x7fMKC", the format
+// version and the version of the instruction set, see code.Version, both
+// big-endian uint16, and a flags byte. The main program, the constants pool
+// and the names of the globals and builtins follow, with integers as
+// varints and byte strings prefixed with their length. Debug information,
+// the line tables and names of functions, is only present when the debug
+// flag is set. The file ends in the big-endian CRC-32 (IEEE) of everything
+// before it.
+package mkc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/token"
+)
+
+// FormatVersion is the version of the container format Marshal writes and
+// Unmarshal reads.
+const FormatVersion = 2
+
+const (
+	headerSize   = 9
+	checksumSize = 4
+
+	flagDebug = 1 << 0
+)
+
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagFunction
+)
+
+var magic = []byte("\x7fMKC")
+
+var (
+	// ErrFormat is returned for data that is not a compiled program.
+	ErrFormat = errors.New("not a compiled Monkey program")
+
+	// ErrCorrupt is returned, wrapped, for a compiled program that fails
+	// its checksum or cannot be decoded.
+	ErrCorrupt = errors.New("corrupt compiled program")
+)
+
+// A VersionError is returned for a program compiled by a version of monkey
+// that this one cannot run.
+type VersionError struct {
+	Format  int
+	Opcodes int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf(
+		"incompatible compiled program: built with format version %d and instruction set %d, "+
+			"but this monkey reads format version %d and instruction set %d; rebuild it with monkey build",
+		e.Format,
+		e.Opcodes,
+		FormatVersion,
+		code.Version,
+	)
+}
+
+// IsCompiled reports whether data starts like a compiled program.
+func IsCompiled(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+// Marshal encodes program. Debug information is included when debug is
+// set; without it runtime errors have no positions and functions no names.
+func Marshal(program *compiler.Bytecode, debug bool) ([]byte, error) {
+	e := &encoder{debug: debug}
+
+	e.buf = append(e.buf, magic...)
+	e.buf = binary.BigEndian.AppendUint16(e.buf, FormatVersion)
+	e.buf = binary.BigEndian.AppendUint16(e.buf, code.Version)
+
+	var flags byte
+	if debug {
+		flags |= flagDebug
+	}
+	e.buf = append(e.buf, flags)
+
+	e.bytes(program.Instructions)
+	e.lines(program.Lines)
+
+	e.uvarint(len(program.Constants))
+	for i, constant := range program.Constants {
+		if err := e.constant(constant); err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+
+	e.strings(program.Globals)
+	e.strings(program.Builtins)
+
+	return binary.BigEndian.AppendUint32(e.buf, crc32.ChecksumIEEE(e.buf)), nil
+}
+
+// Unmarshal decodes a program encoded by Marshal. It returns ErrFormat for
+// data that is not a compiled program, a *VersionError for one compiled by
+// an incompatible version and an error wrapping ErrCorrupt for one that is
+// damaged.
+func Unmarshal(data []byte) (*compiler.Bytecode, error) {
+	if !IsCompiled(data) {
+		return nil, ErrFormat
+	}
+
+	if len(data) < headerSize+checksumSize {
+		return nil, fmt.Errorf("%w: truncated", ErrCorrupt)
+	}
+
+	format := binary.BigEndian.Uint16(data[4:])
+	opcodes := binary.BigEndian.Uint16(data[6:])
+	if format != FormatVersion || opcodes != code.Version {
+		return nil, &VersionError{Format: int(format), Opcodes: int(opcodes)}
+	}
+
+	body := data[:len(data)-checksumSize]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[len(body):]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	flags := data[8]
+	if flags&^flagDebug != 0 {
+		return nil, fmt.Errorf("%w: unknown flags %#x", ErrCorrupt, flags)
+	}
+
+	d := &decoder{data: body[headerSize:], debug: flags&flagDebug != 0}
+	program := &compiler.Bytecode{}
+
+	program.Instructions = d.bytes()
+	program.Lines = d.lines()
+
+	n := d.count()
+	for i := 0; i < n && d.err == nil; i++ {
+		program.Constants = append(program.Constants, d.constant())
+	}
+
+	program.Globals = d.strings()
+	program.Builtins = d.strings()
+
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("%d bytes of trailing data", len(d.data))
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return program, nil
+}
+
+type encoder struct {
+	buf   []byte
+	debug bool
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.buf = append(e.buf, tagInteger)
+		e.buf = binary.AppendVarint(e.buf, obj.Value)
+	case *object.String:
+		e.buf = append(e.buf, tagString)
+		e.bytes([]byte(obj.Value))
+	case *object.CompiledFunction:
+		e.buf = append(e.buf, tagFunction)
+		e.uvarint(obj.NumLocals)
+		e.uvarint(obj.NumParameters)
+		e.bytes(obj.Instructions)
+		e.bytes([]byte(obj.Source))
+		if e.debug {
+			e.bytes([]byte(obj.Name))
+		}
+		e.lines(obj.Lines)
+	default:
+		return fmt.Errorf("cannot encode %s", obj.Type())
+	}
+
+	return nil
+}
+
+func (e *encoder) lines(t code.LineTable) {
+	if !e.debug {
+		return
+	}
+
+	e.uvarint(len(t))
+	for _, line := range t {
+		e.uvarint(line.Offset)
+		e.uvarint(line.Pos.Offset)
+		e.uvarint(line.Pos.Line)
+		e.uvarint(line.Pos.Column)
+	}
+}
+
+func (e *encoder) strings(s []string) {
+	e.uvarint(len(s))
+	for _, str := range s {
+		e.bytes([]byte(str))
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(len(b))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) uvarint(n int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(n))
+}
+
+// A decoder reads the body of a file. After the first failure it stops
+// reading and returns zero values.
+type decoder struct {
+	data  []byte
+	debug bool
+	err   error
+}
+
+func (d *decoder) fail(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, a...))
+	}
+}
+
+func (d *decoder) constant() object.Object {
+	if d.err != nil {
+		return nil
+	}
+
+	if len(d.data) == 0 {
+		d.fail("truncated")
+		return nil
+	}
+
+	tag := d.data[0]
+	d.data = d.data[1:]
+
+	switch tag {
+	case tagInteger:
+		v, n := binary.Varint(d.data)
+		if n <= 0 {
+			d.fail("bad integer")
+			return nil
+		}
+		d.data = d.data[n:]
+		return &object.Integer{Value: v}
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+	case tagFunction:
+		fn := &object.CompiledFunction{
+			NumLocals:     d.uvarint(),
+			NumParameters: d.uvarint(),
+			Instructions:  d.bytes(),
+			Source:        string(d.bytes()),
+		}
+		if d.debug {
+			fn.Name = string(d.bytes())
+		}
+		fn.Lines = d.lines()
+		return fn
+	}
+
+	d.fail("unknown constant tag %d", tag)
+
+	return nil
+}
+
+func (d *decoder) lines() code.LineTable {
+	if !d.debug {
+		return nil
+	}
+
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+
+	t := make(code.LineTable, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		line := code.Line{Offset: d.uvarint()}
+		line.Pos = token.Position{Offset: d.uvarint(), Line: d.uvarint(), Column: d.uvarint()}
+		t = append(t, line)
+	}
+
+	return t
+}
+
+func (d *decoder) strings() []string {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+
+	s := make([]string, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		s = append(s, string(d.bytes()))
+	}
+
+	return s
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n > len(d.data) {
+		d.fail("truncated")
+		return nil
+	}
+
+	b := bytes.Clone(d.data[:n])
+	d.data = d.data[n:]
+
+	return b
+}
+
+// count reads the length of a list. Every element takes at least a byte,
+// so a length beyond the data left is corrupt.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > len(d.data) {
+		d.fail("truncated")
+		return 0
+	}
+
+	return n
+}
+
+func (d *decoder) uvarint() int {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 || v > math.MaxInt32 {
+		d.fail("bad length")
+		return 0
+	}
+	d.data = d.data[n:]
+
+	return int(v)
+}
@@ -0,0 +1,138 @@
+package mkc
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/parser"
+	"github.com/stretchr/testify/suite"
+)
+
+const input = `let greeting = "hello";
+let f = fn(n) { if (n > 0) { f(n - 1) } else { greeting } };
+puts(f(-9223372036854775807 - 1), [1, 2][0], {"a": fn() { 1 }}["a"]());`
+
+type MKCTestSuite struct {
+	suite.Suite
+}
+
+func TestMKCTestSuite(t *testing.T) {
+	suite.Run(t, new(MKCTestSuite))
+}
+
+func (s *MKCTestSuite) TestRoundTrip() {
+	program := s.compile(input)
+
+	data, err := Marshal(program, true)
+	s.NoError(err)
+	s.True(IsCompiled(data))
+
+	decoded, err := Unmarshal(data)
+	s.NoError(err)
+	s.Equal(program, decoded)
+
+	var names []string
+	for _, constant := range decoded.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			names = append(names, fn.Name)
+		}
+	}
+	s.Equal([]string{"f", ""}, names)
+}
+
+func (s *MKCTestSuite) TestStripped() {
+	program := s.compile(input)
+
+	stripped, err := Marshal(program, false)
+	s.NoError(err)
+
+	full, err := Marshal(program, true)
+	s.NoError(err)
+	s.Less(len(stripped), len(full))
+
+	decoded, err := Unmarshal(stripped)
+	s.NoError(err)
+	s.Equal(program.Instructions, decoded.Instructions)
+	s.Equal(program.Globals, decoded.Globals)
+	s.Equal(program.Builtins, decoded.Builtins)
+	s.Nil(decoded.Lines)
+
+	for i, constant := range decoded.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			s.Equal(program.Constants[i], constant)
+			continue
+		}
+
+		original := program.Constants[i].(*object.CompiledFunction)
+		s.Equal(original.Instructions, fn.Instructions)
+		s.Equal(original.NumLocals, fn.NumLocals)
+		s.Equal(original.NumParameters, fn.NumParameters)
+		s.Empty(fn.Name)
+		s.Nil(fn.Lines)
+	}
+}
+
+func (s *MKCTestSuite) TestErrors() {
+	data, err := Marshal(s.compile(input), true)
+	s.NoError(err)
+
+	_, err = Unmarshal([]byte("let x = 1;"))
+	s.Equal(ErrFormat, err)
+
+	_, err = Unmarshal(data[:6])
+	s.ErrorIs(err, ErrCorrupt)
+
+	corrupt := append([]byte{}, data...)
+	corrupt[20]++
+	_, err = Unmarshal(corrupt)
+	s.ErrorIs(err, ErrCorrupt)
+	s.EqualError(err, "corrupt compiled program: checksum mismatch")
+
+	newer := append([]byte{}, data...)
+	binary.BigEndian.PutUint16(newer[6:], code.Version+1)
+	_, err = Unmarshal(newer)
+	var versionErr *VersionError
+	s.ErrorAs(err, &versionErr)
+	s.Equal(FormatVersion, versionErr.Format)
+	s.Equal(code.Version+1, versionErr.Opcodes)
+	s.Contains(err.Error(), "rebuild it with monkey build")
+
+	binary.BigEndian.PutUint16(newer[4:], FormatVersion+1)
+	_, err = Unmarshal(newer)
+	s.ErrorAs(err, &versionErr)
+	s.Equal(FormatVersion+1, versionErr.Format)
+
+	// A checksum that matches does not make a body valid.
+	truncated := s.resum(data[:len(data)-checksumSize-3])
+	_, err = Unmarshal(truncated)
+	s.ErrorIs(err, ErrCorrupt)
+
+	trailing := s.resum(append(data[:len(data)-checksumSize:len(data)-checksumSize], 0))
+	_, err = Unmarshal(trailing)
+	s.EqualError(err, "corrupt compiled program: 1 bytes of trailing data")
+
+	_, err = Marshal(&compiler.Bytecode{Constants: []object.Object{object.TRUE}}, true)
+	s.EqualError(err, "constant 0: cannot encode BOOLEAN")
+}
+
+// resum appends the checksum of body.
+func (s *MKCTestSuite) resum(body []byte) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte{}, body...), crc32.ChecksumIEEE(body))
+}
+
+func (s *MKCTestSuite) compile(input string) *compiler.Bytecode {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	s.Require().Empty(p.Errors())
+
+	c := compiler.New()
+	s.Require().NoError(c.Compile(program))
+
+	return c.Bytecode()
+}
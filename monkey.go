@@ -0,0 +1,385 @@
+// Package monkey embeds the Monkey programming language in Go programs.
+//
+//	interp := monkey.NewInterpreter(monkey.WithStdout(&out))
+//	interp.Set("limit", 10)
+//	result, err := interp.Eval(ctx, "limit * 2")
+//
+// Lexing, parsing and runtime failures are all reported as *Error.
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/evaluator"
+	"github.com/marcel/monkey/gas"
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/mkc"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/optimizer"
+	"github.com/marcel/monkey/parser"
+	"github.com/marcel/monkey/vm"
+)
+
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// Runtime errors caused by an execution limit match these with errors.Is.
+var (
+	ErrTimeout       = object.ErrTimeout
+	ErrStepLimit     = object.ErrStepLimit
+	ErrStackOverflow = object.ErrStackOverflow
+	ErrMemoryLimit   = object.ErrMemoryLimit
+	ErrOutOfGas      = object.ErrOutOfGas
+)
+
+// DefaultMaxSteps is the number of steps a single call to Eval may take
+// unless WithMaxSteps says otherwise. Calls in tail position never reach
+// the call depth limit, so it is what stops tail recursion that does not
+// end.
+const DefaultMaxSteps = 100_000_000
+
+type AllocStats = object.AllocStats
+
+// An Interpreter evaluates Monkey source. Globals defined by one call to
+// Eval are visible to the next. An Interpreter must not be used by more
+// than one goroutine at a time.
+type Interpreter struct {
+	config
+	evaluator *evaluator.Evaluator
+
+	// The VM engine compiles each program with the symbol table and
+	// constants of the ones before it, and runs it with their globals.
+	// The builtins, allocator and limits are those of the evaluator.
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+	machine     *vm.VM
+}
+
+func NewInterpreter(opts ...Option) *Interpreter {
+	c := config{
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		engine:   TreeWalking,
+		maxSteps: DefaultMaxSteps,
+		maxDepth: evaluator.DefaultMaxDepth,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	e := evaluator.New()
+	e.Alloc.Quota = c.memoryQuota
+	e.Builtins = object.CoreBuiltins(c.stdout, c.stderr, e.Alloc)
+	for _, name := range c.without {
+		e.Builtins.Remove(name)
+	}
+	e.MaxSteps = c.maxSteps
+	e.MaxDepth = c.maxDepth
+
+	if c.gasTable != nil || c.gasLimit > 0 {
+		table := gas.DefaultTable
+		if c.gasTable != nil {
+			table = *c.gasTable
+		}
+		e.Gas = gas.NewMeter(table, c.gasLimit)
+	}
+
+	i := &Interpreter{config: c, evaluator: e}
+	if c.engine == VM {
+		i.symbolTable = compiler.NewGlobalSymbolTable(e.Builtins.Names())
+	}
+
+	return i
+}
+
+// Eval evaluates src and returns the value of its last statement, or
+// object.NULL when that statement has no value.
+func (i *Interpreter) Eval(ctx context.Context, src string) (object.Object, error) {
+	return i.eval(ctx, "", src)
+}
+
+// EvalFile evaluates the file at path like Eval. Errors are reported with
+// the path. A program compiled by monkey build, see package mkc, is run
+// rather than evaluated; this takes the VM engine.
+func (i *Interpreter) EvalFile(ctx context.Context, path string) (object.Object, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, loadError(path, err)
+	}
+
+	if mkc.IsCompiled(src) {
+		return i.load(ctx, path, src)
+	}
+
+	return i.eval(ctx, path, string(src))
+}
+
+// CompileFile compiles the file at path for the VM engine. Errors are
+// reported as they are by EvalFile.
+func CompileFile(path string) (*compiler.Bytecode, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, loadError(path, err)
+	}
+
+	program, err := parse(path, string(src))
+	if err != nil {
+		return nil, err
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return nil, compileError(path, err.(*compiler.Error))
+	}
+
+	return c.Bytecode(), nil
+}
+
+func (i *Interpreter) eval(ctx context.Context, file, src string) (object.Object, error) {
+	program, err := parse(file, src)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.run(ctx, file, program)
+}
+
+// load runs the compiled program data, linked with the ones run before it.
+// It is verified first, since it may not have come from the compiler.
+func (i *Interpreter) load(ctx context.Context, file string, data []byte) (object.Object, error) {
+	if i.engine != VM {
+		return nil, loadError(file, fmt.Errorf("compiled programs can only be run by the %s engine", VM))
+	}
+
+	program, err := mkc.Unmarshal(data)
+	if err == nil {
+		err = vm.Verify(program)
+	}
+	if err != nil {
+		return nil, loadError(file, err)
+	}
+
+	shared := len(i.constants)
+
+	bytecode, err := compiler.NewWithState(i.symbolTable, i.constants).Link(program)
+	if err != nil {
+		return nil, compileError(file, err.(*compiler.Error))
+	}
+
+	return i.runBytecode(ctx, file, bytecode, shared)
+}
+
+func (i *Interpreter) run(ctx context.Context, file string, program *ast.Program) (object.Object, error) {
+	if i.engine == VM {
+		c := compiler.NewWithState(i.symbolTable, i.constants)
+		if err := c.Compile(program); err != nil {
+			return nil, compileError(file, err.(*compiler.Error))
+		}
+
+		return i.runBytecode(ctx, file, c.Bytecode(), len(i.constants))
+	}
+
+	if i.optimize {
+		program = optimizer.Optimize(program)
+	}
+
+	return result(file, i.evaluator.EvalContext(ctx, program, i.evaluator.Globals))
+}
+
+// runBytecode runs bytecode, whose first shared constants are those of the
+// programs run before it.
+func (i *Interpreter) runBytecode(ctx context.Context, file string, bytecode *compiler.Bytecode, shared int) (object.Object, error) {
+	if i.optimize {
+		bytecode = optimizer.Peephole(bytecode, shared)
+	}
+	i.constants = bytecode.Constants
+
+	i.machine = i.newVM(bytecode)
+	obj := i.machine.RunContext(ctx)
+	i.globals = i.machine.Globals
+
+	return result(file, obj)
+}
+
+func result(file string, obj object.Object) (object.Object, error) {
+	switch obj := obj.(type) {
+	case nil:
+		return object.NULL, nil
+	case *object.Error:
+		return nil, runtimeError(file, obj)
+	}
+
+	return obj, nil
+}
+
+func parse(file, src string) (*ast.Program, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+
+	if errors := p.ErrorList(); len(errors) > 0 {
+		return nil, syntaxError(file, errors)
+	}
+
+	return program, nil
+}
+
+func (i *Interpreter) newVM(bytecode *compiler.Bytecode) *vm.VM {
+	machine := vm.NewWithGlobals(bytecode, i.globals)
+	machine.Builtins = i.evaluator.Builtins
+	machine.Alloc = i.evaluator.Alloc
+	machine.Gas = i.evaluator.Gas
+	machine.MaxSteps = i.maxSteps
+	machine.MaxDepth = i.maxDepth
+
+	return machine
+}
+
+// Allocations returns the number of objects and bytes the programs run by i
+// have allocated so far.
+func (i *Interpreter) Allocations() AllocStats {
+	return i.evaluator.Alloc.Stats()
+}
+
+// GasUsed returns the gas the last call to Eval, EvalFile or Call used. It
+// is zero unless the Interpreter was created WithGasLimit or WithGasTable.
+func (i *Interpreter) GasUsed() uint64 {
+	return i.evaluator.Gas.Used()
+}
+
+// GasRemaining returns the gas the last call to Eval, EvalFile or Call left
+// of the limit.
+func (i *Interpreter) GasRemaining() uint64 {
+	return i.evaluator.Gas.Remaining()
+}
+
+// Set binds the global name to value, converted by object.ToObject.
+func (i *Interpreter) Set(name string, value interface{}) error {
+	obj, err := object.ToObject(value)
+	if err != nil {
+		return fmt.Errorf("cannot set %s: %w", name, err)
+	}
+
+	if i.engine == VM {
+		symbol := i.symbolTable.Define(name)
+		if n := symbol.Index + 1; len(i.globals) < n {
+			i.globals = append(i.globals, make([]object.Object, n-len(i.globals))...)
+		}
+		i.globals[symbol.Index] = obj
+	} else {
+		i.evaluator.Globals.Set(name, obj)
+	}
+
+	return nil
+}
+
+// Get returns the value of the global name converted to int64, bool,
+// string, []interface{}, map[string]interface{} or nil. Functions are
+// returned as object.Object.
+func (i *Interpreter) Get(name string) (interface{}, bool) {
+	obj, ok := i.global(name)
+	if !ok {
+		return nil, false
+	}
+
+	v, err := object.FromObject(obj, interfaceType)
+	if err != nil {
+		return obj, true
+	}
+
+	return v.Interface(), true
+}
+
+func (i *Interpreter) global(name string) (object.Object, bool) {
+	if i.engine != VM {
+		return i.evaluator.Globals.Get(name)
+	}
+
+	symbol, ok := i.symbolTable.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope || symbol.Index >= len(i.globals) {
+		return nil, false
+	}
+
+	obj := i.globals[symbol.Index]
+
+	return obj, obj != nil
+}
+
+// Bind exposes the Go function fn to Monkey code as the builtin name.
+func (i *Interpreter) Bind(name string, fn interface{}) error {
+	if err := i.evaluator.Bind(name, fn); err != nil {
+		return err
+	}
+
+	if i.engine == VM {
+		if symbol, ok := i.symbolTable.Resolve(name); !ok || symbol.Scope != compiler.GlobalScope {
+			i.symbolTable.DefineBuiltin(name)
+		}
+	}
+
+	return nil
+}
+
+// Call calls the global function name with args. Runtime errors are
+// returned as an *Error.
+func (i *Interpreter) Call(name string, args ...interface{}) (interface{}, error) {
+	return i.caller().Call(name, args...)
+}
+
+// CallContext calls the global function name like Call, bounding how long
+// it may run by ctx as Eval does.
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	return i.caller().CallContext(ctx, name, args...)
+}
+
+// BindFunc sets the function variable fnPtr points to to a Go function
+// that calls the global Monkey function name. Its runtime errors are
+// returned as an *Error, like those of Call. A function type that starts
+// with a context.Context parameter runs the calls under that context.
+func (i *Interpreter) BindFunc(name string, fnPtr interface{}) error {
+	return i.caller().BindFunc(name, fnPtr)
+}
+
+// caller calls functions of the engine on behalf of the host.
+func (i *Interpreter) caller() object.Caller {
+	c := i.evaluator.Caller()
+	if i.engine == VM {
+		c = object.Caller{Lookup: i.lookupFunction, Apply: i.apply}
+	}
+
+	c.Wrap = func(err *object.Error) error { return runtimeError("", err) }
+
+	return c
+}
+
+func (i *Interpreter) lookupFunction(name string) (object.Object, error) {
+	fn, ok := i.global(name)
+	if !ok {
+		if fn, ok = i.evaluator.Builtins.Lookup(name); !ok {
+			return nil, object.NewError(object.UnknownIdentifier, "identifier not found: %s", name)
+		}
+	}
+
+	switch fn.(type) {
+	case *object.Closure, *object.Builtin:
+		return fn, nil
+	}
+
+	return nil, object.NewError(object.NotAFunction, "not a function: %s", fn.Type())
+}
+
+// apply calls fn in the VM that ran the last program, which can run the
+// closures of that program and of those before it.
+func (i *Interpreter) apply(ctx context.Context, fn object.Object, args []object.Object) object.Object {
+	if i.machine == nil {
+		i.machine = i.newVM(&compiler.Bytecode{})
+	}
+	i.machine.Globals = i.globals
+
+	return i.machine.ApplyContext(ctx, fn, args)
+}
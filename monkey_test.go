@@ -0,0 +1,453 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcel/monkey/gas"
+	"github.com/marcel/monkey/mkc"
+	"github.com/marcel/monkey/object"
+	"github.com/stretchr/testify/suite"
+)
+
+type MonkeyTestSuite struct {
+	suite.Suite
+}
+
+func TestMonkeyTestSuite(t *testing.T) {
+	suite.Run(t, new(MonkeyTestSuite))
+}
+
+func (s *MonkeyTestSuite) TestEval() {
+	interp := NewInterpreter()
+
+	result, err := interp.Eval(context.Background(), "let add = fn(a, b) { a + b }; add(1, 2)")
+	s.NoError(err)
+	s.Equal("3", result.Inspect())
+
+	result, err = interp.Eval(context.Background(), "add(2, 3)")
+	s.NoError(err)
+	s.Equal("5", result.Inspect())
+
+	result, err = interp.Eval(context.Background(), "let x = 1;")
+	s.NoError(err)
+	s.Equal(object.NULL, result)
+}
+
+func (s *MonkeyTestSuite) TestEvalFile() {
+	path := filepath.Join(s.T().TempDir(), "main.mk")
+	s.NoError(os.WriteFile(path, []byte("let x = 1;\nx + true"), 0o644))
+
+	_, err := NewInterpreter().EvalFile(context.Background(), path)
+
+	var e *Error
+	s.True(errors.As(err, &e))
+	s.Equal(RuntimeError, e.Kind)
+	s.Equal(path+":2:3: type mismatch: INTEGER + BOOLEAN", err.Error())
+
+	missing := filepath.Join(s.T().TempDir(), "missing.mk")
+	_, err = NewInterpreter().EvalFile(context.Background(), missing)
+	s.True(errors.Is(err, os.ErrNotExist))
+	s.True(errors.As(err, &e))
+	s.Equal(LoadError, e.Kind)
+	s.Equal(missing+": open: no such file or directory", err.Error())
+
+	_, err = CompileFile(missing)
+	s.True(errors.As(err, &e))
+	s.Equal(LoadError, e.Kind)
+}
+
+func (s *MonkeyTestSuite) TestErrors() {
+	expectations := []struct {
+		Input   string
+		Kind    ErrorKind
+		Message string
+	}{
+		{`let x = "abc`, LexError, "1:9: unterminated string literal"},
+		{"let x = 5 @ 3;", LexError, `1:11: illegal character "@"`},
+		{"let = 5;", ParseError, "1:5: expected next token to be IDENT, got = instead (and 1 more errors)"},
+		{"1 / 0", RuntimeError, "1:3: division by zero"},
+		{"foo", RuntimeError, "1:1: identifier not found: foo"},
+	}
+
+	for _, e := range expectations {
+		_, err := NewInterpreter().Eval(context.Background(), e.Input)
+
+		var monkeyErr *Error
+		s.True(errors.As(err, &monkeyErr), e.Input)
+		s.Equal(e.Kind, monkeyErr.Kind, e.Input)
+		s.Equal(e.Message, err.Error(), e.Input)
+	}
+}
+
+func (s *MonkeyTestSuite) TestRuntimeErrorUnwrap() {
+	_, err := NewInterpreter().Eval(context.Background(), "let f = fn() { 1 / 0 };\nf()")
+
+	var runtimeErr *object.Error
+	s.True(errors.As(err, &runtimeErr))
+	s.Equal(object.DivisionByZero, runtimeErr.Kind)
+	s.Equal("1:18: division by zero\n\tat f (1:18)\n\tat <main> (2:1)", err.(*Error).StackTrace())
+}
+
+func (s *MonkeyTestSuite) TestCanceledContext() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewInterpreter().Eval(ctx, "1")
+	s.ErrorIs(err, context.Canceled)
+	s.ErrorIs(err, ErrTimeout)
+}
+
+func (s *MonkeyTestSuite) TestLimits() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := NewInterpreter().Eval(ctx, "let loop = fn() { loop() }; let f = fn(n) { if (n > 0) { f(n - 1) } }; let g = fn() { f(1000); g() }; g()")
+	s.ErrorIs(err, ErrTimeout)
+	s.ErrorIs(err, context.DeadlineExceeded)
+
+	_, err = NewInterpreter(WithMaxSteps(1000)).Eval(context.Background(), "let f = fn(n) { if (n > 0) { f(n - 1) } }; f(500)")
+	s.ErrorIs(err, ErrStepLimit)
+
+	_, err = NewInterpreter().Eval(context.Background(), "let f = fn() { 1 + f() }; f()")
+	s.ErrorIs(err, ErrStackOverflow)
+	s.NotErrorIs(err, ErrTimeout)
+
+	_, err = NewInterpreter(WithMaxDepth(10)).Eval(context.Background(), "let f = fn(n) { if (n > 0) { 1 + f(n - 1) } else { 0 } }; f(20)")
+	s.ErrorIs(err, ErrStackOverflow)
+
+	// Tail calls never nest, however deep the limit, so tail recursion runs
+	// until the step limit stops it.
+	for _, engine := range []Engine{TreeWalking, VM} {
+		_, err = NewInterpreter(WithEngine(engine), WithMaxDepth(10), WithMaxSteps(100000)).Eval(context.Background(), "let f = fn() { f() }; f()")
+		s.ErrorIs(err, ErrStepLimit, engine.String())
+		s.NotErrorIs(err, ErrStackOverflow, engine.String())
+
+		_, err = NewInterpreter(WithEngine(engine), WithMaxDepth(10)).Eval(context.Background(), "let f = fn(n) { if (n > 0) { f(n - 1) } }; f(20)")
+		s.NoError(err, engine.String())
+	}
+
+	_, err = NewInterpreter(WithEngine(VM)).Eval(context.Background(), "let f = fn() { f() }; f()")
+	s.ErrorIs(err, ErrStepLimit, "the default step limit stops tail recursion")
+	s.Equal(fmt.Sprintf("step limit of %d exceeded", DefaultMaxSteps), err.(*Error).Message)
+}
+
+func (s *MonkeyTestSuite) TestSetGet() {
+	interp := NewInterpreter()
+
+	s.NoError(interp.Set("limit", 10))
+	s.NoError(interp.Set("names", []string{"a", "b"}))
+	s.Error(interp.Set("ch", make(chan int)))
+
+	_, err := interp.Eval(context.Background(), `let double = fn(x) { x * 2 }; let result = double(limit); let first = names[0];`)
+	s.NoError(err)
+
+	result, ok := interp.Get("result")
+	s.True(ok)
+	s.Equal(int64(20), result)
+
+	first, ok := interp.Get("first")
+	s.True(ok)
+	s.Equal("a", first)
+
+	double, ok := interp.Get("double")
+	s.True(ok)
+	s.IsType(&object.Function{}, double)
+
+	_, ok = interp.Get("missing")
+	s.False(ok)
+}
+
+func (s *MonkeyTestSuite) TestOutput() {
+	var out, errOut bytes.Buffer
+
+	interp := NewInterpreter(WithStdout(&out), WithStderr(&errOut), WithEngine(TreeWalking))
+
+	_, err := interp.Eval(context.Background(), `puts("hello"); eputs("oops")`)
+	s.NoError(err)
+	s.Equal("hello\n", out.String())
+	s.Equal("oops\n", errOut.String())
+}
+
+func (s *MonkeyTestSuite) TestHost() {
+	interp := NewInterpreter()
+
+	s.NoError(interp.Bind("greet", func(name string) string { return "hello " + name }))
+	_, err := interp.Eval(context.Background(), `let welcome = fn(name) { greet(name) + "!" };`)
+	s.NoError(err)
+
+	result, err := interp.Call("welcome", "monkey")
+	s.NoError(err)
+	s.Equal("hello monkey!", result)
+
+	var welcome func(string) (string, error)
+	s.NoError(interp.BindFunc("welcome", &welcome))
+	result, err = welcome("go")
+	s.NoError(err)
+	s.Equal("hello go!", result)
+
+	_, err = interp.Call("welcome", 1)
+	var monkeyErr *Error
+	s.True(errors.As(err, &monkeyErr))
+	s.Equal(RuntimeError, monkeyErr.Kind)
+}
+
+func (s *MonkeyTestSuite) TestHostErrors() {
+	for _, engine := range []Engine{TreeWalking, VM} {
+		interp := NewInterpreter(WithEngine(engine))
+		_, err := interp.Eval(context.Background(), "let half = fn(x) { x / 0 };")
+		s.Require().NoError(err)
+
+		var half func(int64) (int64, error)
+		s.NoError(interp.BindFunc("half", &half))
+
+		_, err = half(4)
+		var monkeyErr *Error
+		s.Require().True(errors.As(err, &monkeyErr), engine.String())
+		s.Equal(RuntimeError, monkeyErr.Kind, engine.String())
+		s.Equal("1:22: division by zero", err.Error(), engine.String())
+
+		var objectErr *object.Error
+		s.True(errors.As(err, &objectErr), engine.String())
+
+		var missing func() error
+		err = interp.BindFunc("missing", &missing)
+		s.True(errors.As(err, &monkeyErr), engine.String())
+
+		_, err = interp.Call("missing")
+		s.True(errors.As(err, &monkeyErr), engine.String())
+	}
+}
+
+func (s *MonkeyTestSuite) TestHostContext() {
+	for _, engine := range []Engine{TreeWalking, VM} {
+		interp := NewInterpreter(WithEngine(engine), WithMaxSteps(0))
+		_, err := interp.Eval(context.Background(), "let spin = fn(n) { if (n > 0) { spin(n) } else { n } };")
+		s.Require().NoError(err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err = interp.CallContext(ctx, "spin", 1)
+		cancel()
+		s.ErrorIs(err, ErrTimeout, engine.String())
+		s.ErrorIs(err, context.DeadlineExceeded, engine.String())
+
+		var spin func(context.Context, int64) (int64, error)
+		s.NoError(interp.BindFunc("spin", &spin), engine.String())
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		_, err = spin(ctx, 1)
+		s.ErrorIs(err, context.Canceled, engine.String())
+
+		result, err := spin(context.Background(), 0)
+		s.NoError(err, engine.String())
+		s.Equal(int64(0), result, engine.String())
+	}
+}
+
+func (s *MonkeyTestSuite) TestWithoutBuiltins() {
+	for _, engine := range []Engine{TreeWalking, VM} {
+		var out bytes.Buffer
+		interp := NewInterpreter(WithEngine(engine), WithStdout(&out), WithoutBuiltins("puts", "len"))
+		s.NoError(interp.Bind("len", func(s string) int64 { return 42 }))
+
+		result, err := interp.Eval(context.Background(), `len("abc")`)
+		s.NoError(err, engine.String())
+		s.Equal("42", result.Inspect(), engine.String())
+
+		_, err = interp.Eval(context.Background(), `puts("hello")`)
+		s.EqualError(err, "1:1: identifier not found: puts", engine.String())
+		s.Empty(out.String(), engine.String())
+
+		result, err = interp.Eval(context.Background(), `first([1, 2])`)
+		s.NoError(err, engine.String())
+		s.Equal("1", result.Inspect(), engine.String())
+	}
+}
+
+func (s *MonkeyTestSuite) TestMemoryQuota() {
+	interp := NewInterpreter(WithMemoryQuota(4096))
+
+	_, err := interp.Eval(context.Background(), `let f = fn(s, n) { if (n > 0) { f(s + s, n - 1) } else { s } }; f("x", 20)`)
+	s.ErrorIs(err, ErrMemoryLimit)
+	s.Greater(interp.Allocations().Bytes, int64(4096))
+
+	interp = NewInterpreter()
+	_, err = interp.Eval(context.Background(), `"abc"`)
+	s.NoError(err)
+	s.Equal(AllocStats{Objects: 1, Bytes: 35}, interp.Allocations())
+
+	for _, engine := range []Engine{TreeWalking, VM} {
+		interp = NewInterpreter(WithEngine(engine), WithMemoryQuota(4096))
+		_, err = interp.Eval(context.Background(), `let f = fn(s) { s + "x" };`)
+		s.NoError(err, engine.String())
+
+		for range 100 {
+			_, err = interp.Eval(context.Background(), `f("abc")`)
+			s.Require().NoError(err, engine.String())
+			_, err = interp.Call("f", "abc")
+			s.Require().NoError(err, engine.String())
+		}
+		s.Greater(interp.Allocations().Bytes, int64(4096), engine.String())
+	}
+}
+
+func (s *MonkeyTestSuite) TestGas() {
+	interp := NewInterpreter(WithGasLimit(1000))
+
+	_, err := interp.Eval(context.Background(), "let f = fn(n) { if (n > 0) { f(n - 1) } }; f(10)")
+	s.NoError(err)
+	s.Equal(uint64(131), interp.GasUsed())
+	s.Equal(uint64(869), interp.GasRemaining())
+
+	_, err = interp.Eval(context.Background(), "f(1000)")
+	s.ErrorIs(err, ErrOutOfGas)
+	s.Equal(uint64(0), interp.GasRemaining())
+
+	interp = NewInterpreter(WithGasTable(gas.Table{Call: 1}))
+	_, err = interp.Eval(context.Background(), "let f = fn(n) { if (n > 0) { f(n - 1) } }; f(10)")
+	s.NoError(err)
+	s.Equal(uint64(11), interp.GasUsed())
+}
+
+func (s *MonkeyTestSuite) TestVMEngine() {
+	var out bytes.Buffer
+	interp := NewInterpreter(WithEngine(VM), WithStdout(&out), WithGasLimit(1000))
+	s.Equal("vm", VM.String())
+
+	s.NoError(interp.Set("limit", 10))
+	s.NoError(interp.Bind("greet", func(name string) string { return "hello " + name }))
+
+	result, err := interp.Eval(context.Background(), `
+let f = fn(n) { if (n > 0) { f(n - 1) } };
+let welcome = fn(name) { greet(name) + "!" };
+f(limit);
+puts(welcome("vm"));
+limit * 2`)
+	s.NoError(err)
+	s.Equal("20", result.Inspect())
+	s.Equal("hello vm!\n", out.String())
+	s.Equal(uint64(172), interp.GasUsed(), "the same as for tree-walking")
+
+	welcome, ok := interp.Get("welcome")
+	s.True(ok)
+	s.IsType(&object.Closure{}, welcome)
+
+	_, ok = interp.Get("greet")
+	s.False(ok)
+
+	called, err := interp.Call("welcome", "monkey")
+	s.NoError(err)
+	s.Equal("hello monkey!", called)
+
+	var fn func(string) (string, error)
+	s.NoError(interp.BindFunc("welcome", &fn))
+	called, err = fn("go")
+	s.NoError(err)
+	s.Equal("hello go!", called)
+
+	_, err = interp.Call("limit")
+	s.EqualError(err, "not a function: INTEGER")
+
+	_, err = interp.Eval(context.Background(), "let g = fn() { 1 / 0 };\ng()")
+	s.Equal("1:18: division by zero\n\tat g (1:18)\n\tat <main> (2:1)", err.(*Error).StackTrace())
+
+	_, err = interp.Eval(context.Background(), "f(1000)")
+	s.ErrorIs(err, ErrOutOfGas)
+
+	_, err = NewInterpreter(WithEngine(VM), WithMaxDepth(10)).Eval(context.Background(), "let f = fn(n) { if (n > 0) { 1 + f(n - 1) } else { 0 } }; f(20)")
+	s.ErrorIs(err, ErrStackOverflow)
+
+	_, err = NewInterpreter(WithEngine(VM)).Eval(context.Background(), "let f = fn() { };\nf("+strings.Repeat("1, ", 255)+"1)")
+	var monkeyErr *Error
+	s.True(errors.As(err, &monkeyErr))
+	s.Equal(CompileError, monkeyErr.Kind)
+	s.Equal("2:1: too many arguments in call", err.Error())
+}
+
+func (s *MonkeyTestSuite) TestCompiledPrograms() {
+	dir := s.T().TempDir()
+	source := filepath.Join(dir, "main.mk")
+	s.NoError(os.WriteFile(source, []byte("let double = fn(x) { x * limit };\nlet g = fn() { 1 / 0 };\ndouble(21)"), 0o644))
+
+	program, err := CompileFile(source)
+	s.Require().NoError(err)
+
+	data, err := mkc.Marshal(program, true)
+	s.Require().NoError(err)
+
+	path := filepath.Join(dir, "main.mkc")
+	s.NoError(os.WriteFile(path, data, 0o644))
+
+	interp := NewInterpreter(WithEngine(VM))
+	s.NoError(interp.Set("limit", 2))
+	s.NoError(interp.Set("unused", 0))
+
+	result, err := interp.EvalFile(context.Background(), path)
+	s.NoError(err)
+	s.Equal("42", result.Inspect())
+
+	result, err = interp.Eval(context.Background(), "double(limit)")
+	s.NoError(err)
+	s.Equal("4", result.Inspect(), "globals of compiled programs are shared")
+
+	_, err = interp.Eval(context.Background(), "g()")
+	s.Equal("2:18: division by zero\n\tat g (2:18)\n\tat <main> (1:1)", err.(*Error).StackTrace())
+
+	_, err = NewInterpreter().EvalFile(context.Background(), path)
+	s.EqualError(err, path+": compiled programs can only be run by the vm engine")
+
+	var monkeyErr *Error
+	s.ErrorAs(err, &monkeyErr)
+	s.Equal(LoadError, monkeyErr.Kind)
+
+	corrupt := slices.Clone(data)
+	corrupt[len(corrupt)/2]++
+	s.NoError(os.WriteFile(path, corrupt, 0o644))
+	_, err = NewInterpreter(WithEngine(VM)).EvalFile(context.Background(), path)
+	s.ErrorAs(err, &monkeyErr)
+	s.Equal(LoadError, monkeyErr.Kind)
+	s.Equal(path, monkeyErr.File)
+	s.ErrorIs(err, mkc.ErrCorrupt)
+
+	data[6]++
+	s.NoError(os.WriteFile(path, data, 0o644))
+	_, err = NewInterpreter(WithEngine(VM)).EvalFile(context.Background(), path)
+	var versionErr *mkc.VersionError
+	s.ErrorAs(err, &versionErr)
+	s.ErrorAs(err, &monkeyErr)
+
+	s.NoError(os.WriteFile(source, []byte("let x = ;"), 0o644))
+	_, err = CompileFile(source)
+	s.ErrorContains(err, source+":1:9: ")
+}
+
+func (s *MonkeyTestSuite) TestOptimizations() {
+	input := "let f = fn(x) { x * (2 + 3) };\nif (1 < 2) { f(limit) / (1 - 1) }"
+
+	for _, engine := range []Engine{TreeWalking, VM} {
+		plain := NewInterpreter(WithEngine(engine), WithGasLimit(1000))
+		optimized := NewInterpreter(WithEngine(engine), WithGasLimit(1000), WithOptimizations())
+
+		for _, interp := range []*Interpreter{plain, optimized} {
+			s.NoError(interp.Set("limit", 2))
+
+			_, err := interp.Eval(context.Background(), input)
+			s.EqualError(err, "2:23: division by zero", engine.String())
+
+			result, err := interp.Eval(context.Background(), "f(3) + 2 * 2")
+			s.NoError(err)
+			s.Equal("19", result.Inspect(), engine.String())
+		}
+
+		s.Less(optimized.GasUsed(), plain.GasUsed(), engine.String())
+	}
+}
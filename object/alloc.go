@@ -0,0 +1,132 @@
+package object
+
+// Estimated sizes in bytes of the memory objects take up, including the
+// interface value that refers to them.
+const (
+	wordSize        = 8
+	headerSize      = 2 * wordSize
+	integerSize     = headerSize + wordSize
+	stringSize      = headerSize + 2*wordSize
+	arraySize       = headerSize + 3*wordSize
+	elementSize     = 2 * wordSize
+	hashSize        = headerSize + 8*wordSize
+	hashPairSize    = 6 * wordSize
+	functionSize    = headerSize + 8*wordSize
+	environmentSize = headerSize + 6*wordSize
+	bindingSize     = 4 * wordSize
+)
+
+type (
+	// An Allocator accounts for the objects a program allocates. It counts
+	// allocations, not live memory: objects that are no longer referenced
+	// still count. Once the bytes allocated since the last call to Begin
+	// exceed Quota, allocations fail with a MemoryLimit error; zero means
+	// no quota. A nil *Allocator accounts for nothing.
+	Allocator struct {
+		Quota int64
+		stats AllocStats
+		start int64
+	}
+
+	AllocStats struct {
+		Objects int64
+		Bytes   int64
+	}
+)
+
+func NewAllocator(quota int64) *Allocator {
+	return &Allocator{Quota: quota}
+}
+
+// Alloc accounts for an allocation of size bytes.
+func (a *Allocator) Alloc(size int64) *Error {
+	if a == nil || size == 0 {
+		return nil
+	}
+
+	a.stats.Objects++
+	a.stats.Bytes += size
+
+	if a.Quota > 0 && a.stats.Bytes-a.start > a.Quota {
+		return NewError(MemoryLimit, "memory quota of %d bytes exceeded", a.Quota)
+	}
+
+	return nil
+}
+
+// New accounts for obj, which has just been allocated, and returns it, or
+// the error if it exceeds the quota.
+func (a *Allocator) New(obj Object) Object {
+	if err := a.Alloc(SizeOf(obj)); err != nil {
+		return err
+	}
+
+	return obj
+}
+
+// Environment accounts for a new environment with room for the given
+// number of bindings.
+func (a *Allocator) Environment(bindings int) *Error {
+	return a.Alloc(environmentSize + int64(bindings)*bindingSize)
+}
+
+func (a *Allocator) Stats() AllocStats {
+	if a == nil {
+		return AllocStats{}
+	}
+
+	return a.stats
+}
+
+// Begin starts a new evaluation, which the quota applies to afresh. The
+// counters keep counting.
+func (a *Allocator) Begin() {
+	if a != nil {
+		a.start = a.stats.Bytes
+	}
+}
+
+func (a *Allocator) Reset() {
+	if a != nil {
+		a.stats, a.start = AllocStats{}, 0
+	}
+}
+
+// SizeOf estimates the memory obj takes up itself, not counting the objects
+// it refers to. Singletons such as TRUE and NULL take up none.
+func SizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return integerSize
+	case *String:
+		return stringSize + int64(len(obj.Value))
+	case *Array:
+		return arraySize + int64(len(obj.Elements))*elementSize
+	case *Hash:
+		return hashSize + int64(obj.Len())*hashPairSize
+	case *Function:
+		return functionSize
+	case *Closure:
+		return functionSize + int64(len(obj.Free))*elementSize
+	}
+
+	return 0
+}
+
+// DeepSizeOf estimates the memory obj and the objects it refers to take up.
+func DeepSizeOf(obj Object) int64 {
+	size := SizeOf(obj)
+
+	switch obj := obj.(type) {
+	case *Array:
+		for _, e := range obj.Elements {
+			size += DeepSizeOf(e)
+		}
+	case *Hash:
+		for _, p := range obj.Pairs() {
+			size += DeepSizeOf(p.Key) + DeepSizeOf(p.Value)
+		}
+	}
+
+	return size
+}
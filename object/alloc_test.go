@@ -0,0 +1,71 @@
+package object
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AllocTestSuite struct {
+	suite.Suite
+}
+
+func TestAllocTestSuite(t *testing.T) {
+	suite.Run(t, new(AllocTestSuite))
+}
+
+func (s *AllocTestSuite) TestSizeOf() {
+	s.Equal(int64(0), SizeOf(TRUE))
+	s.Equal(int64(0), SizeOf(NULL))
+	s.Equal(int64(integerSize), SizeOf(&Integer{Value: 1}))
+	s.Equal(int64(stringSize+5), SizeOf(&String{Value: "hello"}))
+
+	array := &Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "ab"}}}
+	s.Equal(int64(arraySize+2*elementSize), SizeOf(array))
+	s.Equal(int64(arraySize+2*elementSize+integerSize+stringSize+2), DeepSizeOf(array))
+}
+
+func (s *AllocTestSuite) TestQuota() {
+	a := NewAllocator(100)
+
+	s.Nil(a.Alloc(60))
+	s.Nil(a.Alloc(0))
+	s.Equal(AllocStats{Objects: 1, Bytes: 60}, a.Stats())
+
+	err := a.Alloc(41)
+	s.NotNil(err)
+	s.Equal(MemoryLimit, err.Kind)
+	s.True(errors.Is(err, ErrMemoryLimit))
+	s.Equal("memory quota of 100 bytes exceeded", err.Message)
+
+	a.Begin()
+	s.Nil(a.Alloc(100))
+	s.Equal(AllocStats{Objects: 3, Bytes: 201}, a.Stats())
+	s.NotNil(a.Alloc(1))
+
+	a.Reset()
+	s.Equal(AllocStats{}, a.Stats())
+	s.Equal(&Integer{Value: 1}, a.New(&Integer{Value: 1}))
+}
+
+func (s *AllocTestSuite) TestNilAllocator() {
+	var a *Allocator
+
+	s.Nil(a.Alloc(1 << 40))
+	s.Equal(AllocStats{}, a.Stats())
+}
+
+func (s *AllocTestSuite) TestBuiltins() {
+	a := NewAllocator(0)
+	builtins := CoreBuiltins(nil, nil, a)
+
+	str, _ := builtins.Lookup("str")
+	hello := &String{Value: "hello"}
+	s.Equal(hello, str.Fn(hello))
+	s.Equal(AllocStats{}, a.Stats(), "arguments returned as is are not allocated")
+
+	push, _ := builtins.Lookup("push")
+	push.Fn(&Array{Elements: []Object{}}, hello)
+	s.Equal(AllocStats{Objects: 1, Bytes: arraySize + elementSize}, a.Stats())
+}
@@ -0,0 +1,294 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+type (
+	BuiltinFunction func(args ...Object) Object
+
+	// A Builtin is a function of the host. Elements, if set, returns the
+	// number of elements a call with args copies, which the engines charge
+	// gas for before they make the call.
+	Builtin struct {
+		Name     string
+		Fn       BuiltinFunction
+		Elements func(args ...Object) int
+	}
+
+	// Builtins is a registry of builtin functions. Names are looked up in
+	// it only after they could not be found in the environment, so a let
+	// binding can shadow a builtin.
+	Builtins struct {
+		builtins map[string]*Builtin
+	}
+)
+
+func (*Builtin) Type() ObjectType  { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string { return "builtin function " + b.Name }
+
+func NewBuiltins(builtins ...*Builtin) *Builtins {
+	b := &Builtins{builtins: make(map[string]*Builtin, len(builtins))}
+
+	for _, builtin := range builtins {
+		b.builtins[builtin.Name] = builtin
+	}
+
+	return b
+}
+
+// CoreBuiltins returns a registry of the functions every interpreter starts
+// with. puts writes to stdout and eputs to stderr; the objects the builtins
+// allocate are accounted for with alloc, which may be nil.
+func CoreBuiltins(stdout, stderr io.Writer, alloc *Allocator) *Builtins {
+	return NewBuiltins(
+		&Builtin{Name: "len", Fn: allocating(alloc, builtinLen)},
+		&Builtin{Name: "puts", Fn: builtinPuts(stdout)},
+		&Builtin{Name: "eputs", Fn: builtinPuts(stderr)},
+		&Builtin{Name: "first", Fn: builtinFirst},
+		&Builtin{Name: "last", Fn: builtinLast},
+		&Builtin{Name: "rest", Fn: allocating(alloc, builtinRest), Elements: restElements},
+		&Builtin{Name: "push", Fn: allocating(alloc, builtinPush), Elements: pushElements},
+		&Builtin{Name: "type", Fn: allocating(alloc, builtinType)},
+		&Builtin{Name: "str", Fn: allocating(alloc, builtinStr)},
+		&Builtin{Name: "int", Fn: allocating(alloc, builtinInt)},
+	)
+}
+
+// allocating wraps a builtin that returns either one of its arguments or a
+// newly allocated object, and accounts for the latter with alloc.
+func allocating(alloc *Allocator, fn BuiltinFunction) BuiltinFunction {
+	if alloc == nil {
+		return fn
+	}
+
+	return func(args ...Object) Object {
+		result := fn(args...)
+		if _, ok := result.(*Error); ok {
+			return result
+		}
+
+		for _, arg := range args {
+			if result == arg {
+				return result
+			}
+		}
+
+		return alloc.New(result)
+	}
+}
+
+func (b *Builtins) Register(name string, fn BuiltinFunction) {
+	b.Add(&Builtin{Name: name, Fn: fn})
+}
+
+func (b *Builtins) Add(builtin *Builtin) {
+	b.builtins[builtin.Name] = builtin
+}
+
+func (b *Builtins) Remove(name string) {
+	delete(b.builtins, name)
+}
+
+func (b *Builtins) Lookup(name string) (*Builtin, bool) {
+	builtin, ok := b.builtins[name]
+
+	return builtin, ok
+}
+
+func (b *Builtins) Names() []string {
+	names := make([]string, 0, len(b.builtins))
+	for name := range b.builtins {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func builtinLen(args ...Object) Object {
+	if err := checkArity("len", args, 1); err != nil {
+		return err
+	}
+
+	switch arg := args[0].(type) {
+	case *String:
+		return &Integer{Value: int64(len(arg.Value))}
+	case *Array:
+		return &Integer{Value: int64(len(arg.Elements))}
+	case *Hash:
+		return &Integer{Value: int64(arg.Len())}
+	}
+
+	return argumentTypeError("len", args[0])
+}
+
+func builtinPuts(w io.Writer) BuiltinFunction {
+	return func(args ...Object) Object {
+		for _, arg := range args {
+			fmt.Fprintln(w, arg.Inspect())
+		}
+
+		return NULL
+	}
+}
+
+func builtinFirst(args ...Object) Object {
+	array, err := arrayArgument("first", args)
+	if err != nil {
+		return err
+	}
+
+	if len(array.Elements) > 0 {
+		return array.Elements[0]
+	}
+
+	return NULL
+}
+
+func builtinLast(args ...Object) Object {
+	array, err := arrayArgument("last", args)
+	if err != nil {
+		return err
+	}
+
+	if length := len(array.Elements); length > 0 {
+		return array.Elements[length-1]
+	}
+
+	return NULL
+}
+
+func builtinRest(args ...Object) Object {
+	array, err := arrayArgument("rest", args)
+	if err != nil {
+		return err
+	}
+
+	length := len(array.Elements)
+	if length == 0 {
+		return NULL
+	}
+
+	elements := make([]Object, length-1)
+	copy(elements, array.Elements[1:])
+
+	return &Array{Elements: elements}
+}
+
+func restElements(args ...Object) int {
+	if len(args) == 1 {
+		if array, ok := args[0].(*Array); ok && len(array.Elements) > 0 {
+			return len(array.Elements) - 1
+		}
+	}
+
+	return 0
+}
+
+func builtinPush(args ...Object) Object {
+	if err := checkArity("push", args, 2); err != nil {
+		return err
+	}
+
+	array, ok := args[0].(*Array)
+	if !ok {
+		return argumentTypeError("push", args[0])
+	}
+
+	length := len(array.Elements)
+	elements := make([]Object, length+1)
+	copy(elements, array.Elements)
+	elements[length] = args[1]
+
+	return &Array{Elements: elements}
+}
+
+func pushElements(args ...Object) int {
+	if len(args) == 2 {
+		if array, ok := args[0].(*Array); ok {
+			return len(array.Elements) + 1
+		}
+	}
+
+	return 0
+}
+
+func builtinType(args ...Object) Object {
+	if err := checkArity("type", args, 1); err != nil {
+		return err
+	}
+
+	return &String{Value: string(args[0].Type())}
+}
+
+func builtinStr(args ...Object) Object {
+	if err := checkArity("str", args, 1); err != nil {
+		return err
+	}
+
+	if s, ok := args[0].(*String); ok {
+		return s
+	}
+
+	return &String{Value: args[0].Inspect()}
+}
+
+func builtinInt(args ...Object) Object {
+	if err := checkArity("int", args, 1); err != nil {
+		return err
+	}
+
+	switch arg := args[0].(type) {
+	case *Integer:
+		return arg
+	case *Boolean:
+		if arg.Value {
+			return &Integer{Value: 1}
+		}
+		return &Integer{Value: 0}
+	case *String:
+		value, err := strconv.ParseInt(arg.Value, 10, 64)
+		if err != nil {
+			return NewError(InvalidArgument, "argument to `int` is not a valid integer: %q", arg.Value)
+		}
+		return &Integer{Value: value}
+	}
+
+	return argumentTypeError("int", args[0])
+}
+
+func arrayArgument(name string, args []Object) (*Array, *Error) {
+	if err := checkArity(name, args, 1); err != nil {
+		return nil, err
+	}
+
+	array, ok := args[0].(*Array)
+	if !ok {
+		return nil, argumentTypeError(name, args[0])
+	}
+
+	return array, nil
+}
+
+func checkArity(name string, args []Object, want int) *Error {
+	if len(args) == want {
+		return nil
+	}
+
+	return NewError(
+		ArityMismatch,
+		"wrong number of arguments to `%s`: want=%d, got=%d",
+		name,
+		want,
+		len(args),
+	)
+}
+
+func argumentTypeError(name string, arg Object) *Error {
+	return NewError(TypeMismatch, "argument to `%s` not supported, got %s", name, arg.Type())
+}
@@ -0,0 +1,202 @@
+package object
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+var (
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+	contextType   = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// A Caller calls Monkey functions from Go on behalf of an engine. Lookup
+// returns the function bound to a name, Apply calls it and ends the call
+// with a Timeout error once ctx is done. Wrap, if set, converts the *Error
+// a call fails with to the error returned.
+type Caller struct {
+	Lookup func(name string) (Object, error)
+	Apply  func(ctx context.Context, fn Object, args []Object) Object
+	Wrap   func(err *Error) error
+}
+
+// Call calls the function bound to name like CallContext, with a context
+// that is never done.
+func (c Caller) Call(name string, args ...interface{}) (interface{}, error) {
+	return c.CallContext(context.Background(), name, args...)
+}
+
+// CallContext calls the function bound to name with args converted by
+// ToObject, under ctx. The result is converted to int64, bool, string,
+// []interface{}, map[string]interface{} or nil; a Monkey runtime error is
+// returned as an *Error.
+func (c Caller) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	fn, err := c.Lookup(name)
+	if err != nil {
+		return nil, c.wrap(err)
+	}
+
+	objects := make([]Object, len(args))
+	for i, arg := range args {
+		if objects[i], err = ToObject(arg); err != nil {
+			return nil, fmt.Errorf("argument %d to %s: %w", i+1, name, err)
+		}
+	}
+
+	result := c.Apply(ctx, fn, objects)
+	if err, ok := result.(*Error); ok {
+		return nil, c.wrap(err)
+	}
+
+	v, err := FromObject(result, interfaceType)
+	if err != nil {
+		return nil, fmt.Errorf("result of %s: %w", name, err)
+	}
+
+	return v.Interface(), nil
+}
+
+// BindFunc sets the function variable fnPtr points to, such as a
+// *func(int64) bool, to a Go function that calls the Monkey function bound
+// to name. Arguments and results are converted as they are for builtins.
+// When the function type starts with a context.Context parameter, calls
+// run under the context passed there. When it ends in an error result,
+// failures are returned there; otherwise they panic with the error.
+func (c Caller) BindFunc(name string, fnPtr interface{}) error {
+	ptr := reflect.ValueOf(fnPtr)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("cannot bind %s: %T is not a pointer to a function", name, fnPtr)
+	}
+
+	t := ptr.Elem().Type()
+	withContext := t.NumIn() > 0 && t.In(0) == contextType
+	withError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+
+	switch {
+	case t.NumOut() > 2:
+		return fmt.Errorf("cannot bind %s: too many results", name)
+	case t.NumOut() == 2 && !withError:
+		return fmt.Errorf("cannot bind %s: second result must be an error", name)
+	}
+
+	fn, err := c.Lookup(name)
+	if err != nil {
+		return c.wrap(err)
+	}
+
+	numIn := t.NumIn()
+	if withContext {
+		numIn--
+	}
+
+	if n, ok := numParameters(fn); ok && !t.IsVariadic() && n != numIn {
+		return fmt.Errorf(
+			"cannot bind %s: function takes %d arguments, %s takes %d",
+			name,
+			n,
+			t,
+			numIn,
+		)
+	}
+
+	impl := func(in []reflect.Value) []reflect.Value {
+		ctx := context.Background()
+		if withContext {
+			if !in[0].IsNil() {
+				ctx = in[0].Interface().(context.Context)
+			}
+			in = in[1:]
+		}
+
+		result, err := c.callWithValues(ctx, name, fn, t, in)
+		return bindResults(t, withError, result, err)
+	}
+
+	ptr.Elem().Set(reflect.MakeFunc(t, impl))
+
+	return nil
+}
+
+func (c Caller) callWithValues(ctx context.Context, name string, fn Object, t reflect.Type, in []reflect.Value) (reflect.Value, error) {
+	if t.IsVariadic() {
+		last := in[len(in)-1]
+		in = in[:len(in)-1]
+
+		for i := 0; i < last.Len(); i++ {
+			in = append(in, last.Index(i))
+		}
+	}
+
+	args := make([]Object, len(in))
+	for i, v := range in {
+		arg, err := ToObject(v.Interface())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("argument %d to %s: %w", i+1, name, err)
+		}
+
+		args[i] = arg
+	}
+
+	result := c.Apply(ctx, fn, args)
+	if err, ok := result.(*Error); ok {
+		return reflect.Value{}, c.wrap(err)
+	}
+
+	if t.NumOut() == 0 || t.NumOut() == 1 && t.Out(0) == errorType {
+		return reflect.Value{}, nil
+	}
+
+	v, err := FromObject(result, t.Out(0))
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("result of %s: %w", name, err)
+	}
+
+	return v, nil
+}
+
+// wrap returns err, converted by Wrap if it is an *Error.
+func (c Caller) wrap(err error) error {
+	if err, ok := err.(*Error); ok && c.Wrap != nil {
+		return c.Wrap(err)
+	}
+
+	return err
+}
+
+// numParameters returns the number of parameters of a Monkey function;
+// builtins take any number of arguments.
+func numParameters(fn Object) (int, bool) {
+	switch fn := fn.(type) {
+	case *Function:
+		return len(fn.Parameters), true
+	case *Closure:
+		return fn.Fn.NumParameters, true
+	}
+
+	return 0, false
+}
+
+func bindResults(t reflect.Type, withError bool, result reflect.Value, err error) []reflect.Value {
+	if err != nil && !withError {
+		panic(err)
+	}
+
+	out := make([]reflect.Value, t.NumOut())
+
+	for i := range out {
+		switch {
+		case i == len(out)-1 && withError:
+			out[i] = reflect.Zero(errorType)
+			if err != nil {
+				out[i] = reflect.ValueOf(&err).Elem()
+			}
+		case err == nil:
+			out[i] = result
+		default:
+			out[i] = reflect.Zero(t.Out(i))
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,461 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// ToObject converts a Go value to a Monkey object. Integers become Integer,
+// booleans Boolean and strings String. Slices and arrays become Array,
+// maps become Hash, and structs become a Hash keyed by field name, or by the
+// name given in a `monkey:"name"` field tag. Nil pointers, slices, maps and
+// interfaces become null. Objects are returned as they are.
+func ToObject(v interface{}) (Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+
+	return toObject(reflect.ValueOf(v))
+}
+
+func toObject(v reflect.Value) (Object, error) {
+	if v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return toObject(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return toArray(v)
+	case reflect.Array:
+		return toArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return toHash(v)
+	case reflect.Struct:
+		return structToHash(v)
+	}
+
+	return nil, fmt.Errorf("cannot convert Go value of type %s", v.Type())
+}
+
+func toArray(v reflect.Value) (Object, error) {
+	elements := make([]Object, v.Len())
+
+	for i := range elements {
+		element, err := toObject(v.Index(i))
+		if err != nil {
+			return nil, fmt.Errorf("index %d: %w", i, err)
+		}
+
+		elements[i] = element
+	}
+
+	return &Array{Elements: elements}, nil
+}
+
+func toHash(v reflect.Value) (Object, error) {
+	keys := v.MapKeys()
+	hash := NewHash(len(keys))
+	pairs := make([]HashPair, 0, len(keys))
+
+	for _, k := range keys {
+		key, err := toObject(k)
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %w", k, err)
+		}
+
+		value, err := toObject(v.MapIndex(k))
+		if err != nil {
+			return nil, fmt.Errorf("key %v: %w", k, err)
+		}
+
+		pairs = append(pairs, HashPair{Key: key, Value: value})
+	}
+
+	// Go maps are unordered; sorting the keys keeps hashes built from them
+	// deterministic.
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+	})
+
+	for _, p := range pairs {
+		key, ok := p.Key.(Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", p.Key.Type())
+		}
+
+		hash.Set(key, p.Value)
+	}
+
+	return hash, nil
+}
+
+func structToHash(v reflect.Value) (Object, error) {
+	t := v.Type()
+	hash := NewHash(t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := fieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+
+		value, err := toObject(v.Field(i))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", t.Field(i).Name, err)
+		}
+
+		hash.Set(&String{Value: name}, value)
+	}
+
+	return hash, nil
+}
+
+// FromObject converts a Monkey object to a Go value of type t. It is the
+// inverse of ToObject; converting to an interface type picks int64, bool,
+// string, []interface{}, map[string]interface{} (or map[interface{}]interface{}
+// when a hash has non-string keys) and nil for null. A nil obj is null, as
+// it is when a builtin returns it.
+func FromObject(obj Object, t reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = NULL
+	}
+
+	if t.Implements(objectType) {
+		if !reflect.TypeOf(obj).AssignableTo(t) {
+			return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+		}
+
+		v := reflect.New(t).Elem()
+		v.Set(reflect.ValueOf(obj))
+
+		return v, nil
+	}
+
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() > 0 {
+			break
+		}
+		v, err := FromObject(obj, naturalType(obj))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return v.Convert(t), nil
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*Integer); ok {
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(i.Value) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*Integer); ok {
+			v := reflect.New(t).Elem()
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return v, nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+	case reflect.Ptr:
+		elem, err := FromObject(obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.Elem())
+		v.Elem().Set(elem)
+		return v, nil
+	case reflect.Slice, reflect.Array:
+		if a, ok := obj.(*Array); ok {
+			return fromArray(a, t)
+		}
+	case reflect.Map:
+		if h, ok := obj.(*Hash); ok {
+			return fromHash(h, t)
+		}
+	case reflect.Struct:
+		if h, ok := obj.(*Hash); ok {
+			return hashToStruct(h, t)
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+func naturalType(obj Object) reflect.Type {
+	switch obj := obj.(type) {
+	case *Integer:
+		return reflect.TypeOf(int64(0))
+	case *Boolean:
+		return reflect.TypeOf(false)
+	case *String:
+		return reflect.TypeOf("")
+	case *Array:
+		return reflect.TypeOf([]interface{}{})
+	case *Hash:
+		for _, p := range obj.Pairs() {
+			if p.Key.Type() != STRING_OBJ {
+				return reflect.TypeOf(map[interface{}]interface{}{})
+			}
+		}
+		return reflect.TypeOf(map[string]interface{}{})
+	}
+
+	return objectType
+}
+
+func fromArray(a *Array, t reflect.Type) (reflect.Value, error) {
+	var v reflect.Value
+
+	if t.Kind() == reflect.Array {
+		if t.Len() != len(a.Elements) {
+			return reflect.Value{}, fmt.Errorf("cannot convert ARRAY of length %d to %s", len(a.Elements), t)
+		}
+		v = reflect.New(t).Elem()
+	} else {
+		v = reflect.MakeSlice(t, len(a.Elements), len(a.Elements))
+	}
+
+	for i, e := range a.Elements {
+		elem, err := FromObject(e, t.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("index %d: %w", i, err)
+		}
+
+		v.Index(i).Set(elem)
+	}
+
+	return v, nil
+}
+
+func fromHash(h *Hash, t reflect.Type) (reflect.Value, error) {
+	v := reflect.MakeMapWithSize(t, h.Len())
+
+	for _, p := range h.Pairs() {
+		key, err := FromObject(p.Key, t.Key())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("key %s: %w", p.Key.Inspect(), err)
+		}
+
+		value, err := FromObject(p.Value, t.Elem())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("key %s: %w", p.Key.Inspect(), err)
+		}
+
+		v.SetMapIndex(key, value)
+	}
+
+	return v, nil
+}
+
+func hashToStruct(h *Hash, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := fieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+
+		obj, ok := h.Get(&String{Value: name})
+		if !ok {
+			continue
+		}
+
+		field, err := FromObject(obj, t.Field(i).Type)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("field %s: %w", t.Field(i).Name, err)
+		}
+
+		v.Field(i).Set(field)
+	}
+
+	return v, nil
+}
+
+func fieldName(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+
+	tag := f.Tag.Get("monkey")
+	if tag == "-" {
+		return "", false
+	}
+
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+
+	return f.Name, true
+}
+
+// NewGoBuiltin wraps the Go function fn as a builtin. Arguments are converted
+// with FromObject and results with ToObject. fn may return nothing, a single
+// value, an error, or a value and an error; a non-nil error, like a failed
+// conversion, is turned into a Monkey runtime error.
+func NewGoBuiltin(name string, fn interface{}) (*Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("cannot bind %s: %T is not a function", name, fn)
+	}
+
+	t := v.Type()
+
+	switch {
+	case t.NumOut() > 2:
+		return nil, fmt.Errorf("cannot bind %s: too many results", name)
+	case t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, fmt.Errorf("cannot bind %s: second result must be an error", name)
+	}
+
+	builtin := func(args ...Object) (result Object) {
+		in, err := goArguments(name, t, args)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				result = NewError(HostError, "%s: panic: %v", name, r)
+				if err, ok := r.(error); ok {
+					result = hostError(name, err)
+				}
+			}
+		}()
+
+		return goResults(name, v.Call(in))
+	}
+
+	return &Builtin{Name: name, Fn: builtin}, nil
+}
+
+func goArguments(name string, t reflect.Type, args []Object) ([]reflect.Value, *Error) {
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+	}
+
+	if len(args) < fixed || !t.IsVariadic() && len(args) > fixed {
+		want := fmt.Sprintf("%d", fixed)
+		if t.IsVariadic() {
+			want = fmt.Sprintf("%d or more", fixed)
+		}
+
+		return nil, NewError(
+			ArityMismatch,
+			"wrong number of arguments to `%s`: want=%s, got=%d",
+			name,
+			want,
+			len(args),
+		)
+	}
+
+	in := make([]reflect.Value, len(args))
+
+	for i, arg := range args {
+		var argType reflect.Type
+		if i < fixed {
+			argType = t.In(i)
+		} else {
+			argType = t.In(fixed).Elem()
+		}
+
+		v, err := FromObject(arg, argType)
+		if err != nil {
+			return nil, NewError(TypeMismatch, "argument %d to `%s`: %s", i+1, name, err)
+		}
+
+		in[i] = v
+	}
+
+	return in, nil
+}
+
+func goResults(name string, out []reflect.Value) Object {
+	if n := len(out); n > 0 && out[n-1].Type() == errorType {
+		if err, _ := out[n-1].Interface().(error); err != nil {
+			return hostError(name, err)
+		}
+
+		out = out[:n-1]
+	}
+
+	if len(out) == 0 {
+		return NULL
+	}
+
+	result, err := toObject(out[0])
+	if err != nil {
+		return NewError(TypeMismatch, "result of `%s`: %s", name, err)
+	}
+
+	return result
+}
+
+// hostError converts an error of the host function name to an *Error. An
+// *Error it wraps, say one of a Monkey function the host called, is
+// copied, since the engines locate the errors of builtins in place.
+func hostError(name string, err error) *Error {
+	var monkeyErr *Error
+	if errors.As(err, &monkeyErr) {
+		copied := *monkeyErr
+		return &copied
+	}
+
+	return NewError(HostError, "%s: %s", name, err)
+}
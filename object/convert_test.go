@@ -0,0 +1,173 @@
+package object
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ConvertTestSuite struct {
+	suite.Suite
+}
+
+type order struct {
+	ID    int64 `monkey:"id"`
+	Items []string
+	Paid  bool
+	note  string
+}
+
+func TestConvertTestSuite(t *testing.T) {
+	suite.Run(t, new(ConvertTestSuite))
+}
+
+func (s *ConvertTestSuite) TestToObject() {
+	expectations := []struct {
+		Value    interface{}
+		Expected string
+	}{
+		{nil, "null"},
+		{42, "42"},
+		{uint8(7), "7"},
+		{true, "true"},
+		{"monkey", "monkey"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{map[string]int{"b": 2, "a": 1}, "{a: 1, b: 2}"},
+		{order{ID: 1, Items: []string{"x"}, Paid: true}, "{id: 1, Items: [x], Paid: true}"},
+		{&order{ID: 2}, "{id: 2, Items: null, Paid: false}"},
+		{(*order)(nil), "null"},
+		{[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{&Integer{Value: 3}, "3"},
+	}
+
+	for _, e := range expectations {
+		obj, err := ToObject(e.Value)
+		s.NoError(err)
+		s.Equal(e.Expected, obj.Inspect())
+	}
+
+	_, err := ToObject(1.5)
+	s.EqualError(err, "cannot convert Go value of type float64")
+
+	_, err = ToObject(uint64(1 << 63))
+	s.EqualError(err, "9223372036854775808 overflows INTEGER")
+}
+
+func (s *ConvertTestSuite) TestFromObject() {
+	hash := NewHash(3)
+	hash.Set(&String{Value: "id"}, &Integer{Value: 7})
+	hash.Set(&String{Value: "Items"}, &Array{Elements: []Object{&String{Value: "a"}}})
+	hash.Set(&String{Value: "Paid"}, TRUE)
+
+	expectations := []struct {
+		Object   Object
+		Target   interface{}
+		Expected interface{}
+	}{
+		{&Integer{Value: 5}, int64(0), int64(5)},
+		{&Integer{Value: 5}, uint16(0), uint16(5)},
+		{TRUE, false, true},
+		{&String{Value: "s"}, "", "s"},
+		{&Array{Elements: []Object{&Integer{Value: 1}}}, []int{}, []int{1}},
+		{hash, order{}, order{ID: 7, Items: []string{"a"}, Paid: true}},
+		{hash, &order{}, &order{ID: 7, Items: []string{"a"}, Paid: true}},
+		{NULL, &order{}, (*order)(nil)},
+		{NULL, []int{}, []int(nil)},
+		{nil, []int{}, []int(nil)},
+		{nil, new(interface{}), nil},
+		{&Integer{Value: 5}, new(interface{}), int64(5)},
+		{hash, new(interface{}), map[string]interface{}{"id": int64(7), "Items": []interface{}{"a"}, "Paid": true}},
+	}
+
+	for _, e := range expectations {
+		t := reflect.TypeOf(e.Target)
+		if p, ok := e.Target.(*interface{}); ok && p != nil {
+			t = t.Elem()
+		}
+
+		v, err := FromObject(e.Object, t)
+		s.NoError(err)
+		s.Equal(e.Expected, v.Interface())
+	}
+
+	failures := []struct {
+		Object  Object
+		Target  interface{}
+		Message string
+	}{
+		{&Integer{Value: 300}, int8(0), "300 overflows int8"},
+		{&Integer{Value: -1}, uint(0), "-1 overflows uint"},
+		{&String{Value: "s"}, int64(0), "cannot convert STRING to int64"},
+		{&Array{Elements: []Object{TRUE}}, []string{}, "index 0: cannot convert BOOLEAN to string"},
+		{&Array{}, &Hash{}, "cannot convert ARRAY to *object.Hash"},
+	}
+
+	for _, f := range failures {
+		_, err := FromObject(f.Object, reflect.TypeOf(f.Target))
+		s.EqualError(err, f.Message)
+	}
+}
+
+func (s *ConvertTestSuite) TestNewGoBuiltin() {
+	join, err := NewGoBuiltin("join", func(sep string, parts ...string) string {
+		return strings.Join(parts, sep)
+	})
+	s.NoError(err)
+
+	s.Equal("a-b-c", join.Fn(&String{Value: "-"}, &String{Value: "a"}, &String{Value: "b"}, &String{Value: "c"}).Inspect())
+	s.Equal("", join.Fn(&String{Value: "-"}).Inspect())
+
+	s.testError(join.Fn(), ArityMismatch, "wrong number of arguments to `join`: want=1 or more, got=0")
+	s.testError(join.Fn(&String{Value: "-"}, &Integer{Value: 1}), TypeMismatch, "argument 2 to `join`: cannot convert INTEGER to string")
+
+	div, err := NewGoBuiltin("div", func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	})
+	s.NoError(err)
+
+	s.Equal("3", div.Fn(&Integer{Value: 7}, &Integer{Value: 2}).Inspect())
+	s.testError(div.Fn(&Integer{Value: 7}, &Integer{Value: 0}), HostError, "div: division by zero")
+	s.testError(div.Fn(&Integer{Value: 7}), ArityMismatch, "wrong number of arguments to `div`: want=2, got=1")
+
+	noop, err := NewGoBuiltin("noop", func() {})
+	s.NoError(err)
+	s.Equal(NULL, noop.Fn())
+
+	boom, err := NewGoBuiltin("boom", func(n int64) int64 { return []int64{}[n] })
+	s.NoError(err)
+	s.testError(boom.Fn(&Integer{Value: 1}), HostError, "boom: runtime error: index out of range [1] with length 0")
+
+	boom, err = NewGoBuiltin("boom", func() { panic("oops") })
+	s.NoError(err)
+	s.testError(boom.Fn(), HostError, "boom: panic: oops")
+
+	hostErr := NewError(DivisionByZero, "division by zero")
+	fail, err := NewGoBuiltin("fail", func() error { return hostErr })
+	s.NoError(err)
+	result := fail.Fn()
+	s.testError(result, DivisionByZero, "division by zero")
+	s.NotSame(hostErr, result, "the engines locate the error in place")
+
+	_, err = NewGoBuiltin("bad", 42)
+	s.EqualError(err, "cannot bind bad: int is not a function")
+
+	_, err = NewGoBuiltin("bad", func() (int, int) { return 0, 0 })
+	s.EqualError(err, "cannot bind bad: second result must be an error")
+}
+
+func (s *ConvertTestSuite) testError(obj Object, kind ErrorKind, message string) {
+	err, ok := obj.(*Error)
+	s.True(ok, "object is not Error. got=%T (%+v)", obj, obj)
+
+	if ok {
+		s.Equal(kind, err.Kind)
+		s.Equal(message, err.Message)
+	}
+}
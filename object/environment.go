@@ -0,0 +1,64 @@
+package object
+
+type (
+	// An Environment holds the bindings of one scope. Scopes nest: lookups
+	// that miss fall through to the enclosing environment, while bindings
+	// are always created in the innermost one.
+	Environment struct {
+		store map[string]Object
+		outer *Environment
+	}
+
+	// A Snapshot is a copy of the bindings of a single environment.
+	Snapshot map[string]Object
+)
+
+func NewEnvironment() *Environment {
+	return &Environment{store: make(map[string]Object)}
+}
+
+func NewEnclosedEnvironment(outer *Environment) *Environment {
+	env := NewEnvironment()
+	env.outer = outer
+
+	return env
+}
+
+func (e *Environment) Get(name string) (Object, bool) {
+	obj, ok := e.store[name]
+	if !ok && e.outer != nil {
+		return e.outer.Get(name)
+	}
+
+	return obj, ok
+}
+
+func (e *Environment) Set(name string, val Object) Object {
+	e.store[name] = val
+
+	return val
+}
+
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Snapshot copies the bindings of e, not including those of enclosing
+// environments. Functions defined in e keep referring to e itself, so
+// restoring a snapshot later makes them see the restored bindings.
+func (e *Environment) Snapshot() Snapshot {
+	snapshot := make(Snapshot, len(e.store))
+	for name, obj := range e.store {
+		snapshot[name] = obj
+	}
+
+	return snapshot
+}
+
+// Restore replaces the bindings of e with those of snapshot.
+func (e *Environment) Restore(snapshot Snapshot) {
+	e.store = make(map[string]Object, len(snapshot))
+	for name, obj := range snapshot {
+		e.store[name] = obj
+	}
+}
@@ -0,0 +1,64 @@
+package object
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type EnvironmentTestSuite struct {
+	suite.Suite
+}
+
+func TestEnvironmentTestSuite(t *testing.T) {
+	suite.Run(t, new(EnvironmentTestSuite))
+}
+
+func (s *EnvironmentTestSuite) TestEnclosedEnvironment() {
+	outer := NewEnvironment()
+	outer.Set("a", &Integer{Value: 1})
+	outer.Set("b", &Integer{Value: 2})
+
+	inner := NewEnclosedEnvironment(outer)
+	inner.Set("b", &Integer{Value: 3})
+
+	a, ok := inner.Get("a")
+	s.True(ok)
+	s.Equal(int64(1), a.(*Integer).Value)
+
+	b, ok := inner.Get("b")
+	s.True(ok)
+	s.Equal(int64(3), b.(*Integer).Value)
+
+	b, ok = outer.Get("b")
+	s.True(ok)
+	s.Equal(int64(2), b.(*Integer).Value)
+
+	_, ok = inner.Get("c")
+	s.False(ok)
+
+	s.Equal(outer, inner.Outer())
+}
+
+func (s *EnvironmentTestSuite) TestSnapshotRestore() {
+	env := NewEnvironment()
+	env.Set("a", &Integer{Value: 1})
+
+	snapshot := env.Snapshot()
+
+	env.Set("a", &Integer{Value: 2})
+	env.Set("b", &Integer{Value: 3})
+
+	env.Restore(snapshot)
+
+	a, ok := env.Get("a")
+	s.True(ok)
+	s.Equal(int64(1), a.(*Integer).Value)
+
+	_, ok = env.Get("b")
+	s.False(ok)
+
+	env.Set("b", &Integer{Value: 4})
+	_, ok = snapshot["b"]
+	s.False(ok)
+}
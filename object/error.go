@@ -0,0 +1,154 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/marcel/monkey/token"
+)
+
+const (
+	UnknownError ErrorKind = iota
+	TypeMismatch
+	UnknownOperator
+	UnknownIdentifier
+	NotAFunction
+	DivisionByZero
+	ArityMismatch
+	InvalidArgument
+	HostError
+	Timeout
+	StepLimit
+	StackOverflow
+	MemoryLimit
+	OutOfGas
+)
+
+// maxTraceFrames is the number of frames StackTrace prints before it elides
+// the middle of a deep stack.
+const maxTraceFrames = 20
+
+type (
+	ErrorKind int
+
+	// An Error is a Monkey runtime error. It is both a Monkey value and a Go
+	// error, so embedders can retrieve it with errors.As. Errors of the kinds
+	// that enforce execution limits match ErrTimeout, ErrStepLimit,
+	// ErrStackOverflow, ErrMemoryLimit and ErrOutOfGas with errors.Is; Err
+	// is the cause of a Timeout, the error of the context that ended the
+	// execution.
+	Error struct {
+		Kind    ErrorKind
+		Message string
+		Pos     token.Position
+		Stack   []Frame
+		Err     error
+	}
+
+	// A Frame is one active call at the time an error occurred, innermost
+	// first. Pos is the position the frame had reached: the failing
+	// expression for the innermost frame, the call into the next frame for
+	// all others. Elided is the number of calls in tail position the frame
+	// took the place of, the last of them made by the next frame.
+	Frame struct {
+		Name   string
+		Pos    token.Position
+		Elided int
+	}
+)
+
+var (
+	ErrTimeout       = errors.New("execution timed out")
+	ErrStepLimit     = errors.New("step limit exceeded")
+	ErrStackOverflow = errors.New("stack overflow")
+	ErrMemoryLimit   = errors.New("memory quota exceeded")
+	ErrOutOfGas      = errors.New("out of gas")
+)
+
+var limitErrors = map[ErrorKind]error{
+	Timeout:       ErrTimeout,
+	StepLimit:     ErrStepLimit,
+	StackOverflow: ErrStackOverflow,
+	MemoryLimit:   ErrMemoryLimit,
+	OutOfGas:      ErrOutOfGas,
+}
+
+var errorKindNames = map[ErrorKind]string{
+	UnknownError:      "unknown error",
+	TypeMismatch:      "type mismatch",
+	UnknownOperator:   "unknown operator",
+	UnknownIdentifier: "unknown identifier",
+	NotAFunction:      "not a function",
+	DivisionByZero:    "division by zero",
+	ArityMismatch:     "arity mismatch",
+	InvalidArgument:   "invalid argument",
+	HostError:         "host error",
+	Timeout:           "timeout",
+	StepLimit:         "step limit",
+	StackOverflow:     "stack overflow",
+	MemoryLimit:       "memory limit",
+	OutOfGas:          "out of gas",
+}
+
+// NewError creates an error without a position or stack trace; the
+// evaluator fills those in when the error is returned from a builtin.
+func NewError(kind ErrorKind, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := errorKindNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+func (*Error) Type() ObjectType  { return ERROR_OBJ }
+func (e *Error) Inspect() string { return "ERROR: " + e.Message }
+
+func (e *Error) Error() string {
+	if !e.Pos.IsValid() {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.Error())
+
+	for i, f := range e.Stack {
+		if n := len(e.Stack); n > maxTraceFrames && i >= maxTraceFrames/2 && i < n-maxTraceFrames/2 {
+			if i == maxTraceFrames/2 {
+				fmt.Fprintf(&out, "\n\t... %d more frames", n-maxTraceFrames)
+			}
+			continue
+		}
+
+		fmt.Fprintf(&out, "\n\tat %s (%s)", f.Name, f.Pos)
+
+		switch f.Elided {
+		case 0:
+		case 1:
+			out.WriteString("\n\t... 1 tail call elided")
+		default:
+			fmt.Fprintf(&out, "\n\t... %d tail calls elided", f.Elided)
+		}
+	}
+
+	return out.String()
+}
+
+func (e *Error) Is(target error) bool {
+	err, ok := limitErrors[e.Kind]
+
+	return ok && err == target
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
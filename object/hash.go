@@ -0,0 +1,99 @@
+package object
+
+import (
+	"bytes"
+	"strings"
+)
+
+type (
+	Hashable interface {
+		Object
+		HashKey() HashKey
+	}
+
+	HashKey struct {
+		Type  ObjectType
+		Value int64
+		Str   string
+	}
+
+	HashPair struct {
+		Key   Object
+		Value Object
+	}
+
+	// A Hash maps hashable keys to values and remembers the order in which
+	// keys were first inserted, which is the order it is inspected in.
+	Hash struct {
+		index map[HashKey]int
+		pairs []HashPair
+	}
+)
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: i.Value}
+}
+
+func (b *Boolean) HashKey() HashKey {
+	var value int64
+	if b.Value {
+		value = 1
+	}
+
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+func (s *String) HashKey() HashKey {
+	return HashKey{Type: s.Type(), Str: s.Value}
+}
+
+func NewHash(size int) *Hash {
+	return &Hash{index: make(map[HashKey]int, size), pairs: make([]HashPair, 0, size)}
+}
+
+func (h *Hash) Set(key Hashable, value Object) {
+	hk := key.HashKey()
+
+	if i, ok := h.index[hk]; ok {
+		h.pairs[i].Value = value
+		return
+	}
+
+	h.index[hk] = len(h.pairs)
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	i, ok := h.index[key.HashKey()]
+	if !ok {
+		return nil, false
+	}
+
+	return h.pairs[i].Value, true
+}
+
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// Pairs returns the pairs of h in insertion order. The slice must not be
+// modified.
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
+}
+
+func (*Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, p := range h.pairs {
+		pairs = append(pairs, p.Key.Inspect()+": "+p.Value.Inspect())
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
@@ -0,0 +1,152 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/code"
+)
+
+const (
+	INTEGER_OBJ      ObjectType = "INTEGER"
+	BOOLEAN_OBJ      ObjectType = "BOOLEAN"
+	NULL_OBJ         ObjectType = "NULL"
+	RETURN_VALUE_OBJ ObjectType = "RETURN_VALUE"
+	ERROR_OBJ        ObjectType = "ERROR"
+	FUNCTION_OBJ     ObjectType = "FUNCTION"
+	STRING_OBJ       ObjectType = "STRING"
+	ARRAY_OBJ        ObjectType = "ARRAY"
+	HASH_OBJ         ObjectType = "HASH"
+	BUILTIN_OBJ      ObjectType = "BUILTIN"
+
+	COMPILED_FUNCTION_OBJ ObjectType = "COMPILED_FUNCTION"
+)
+
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
+type (
+	ObjectType string
+
+	Object interface {
+		Type() ObjectType
+		Inspect() string
+	}
+
+	Integer struct {
+		Value int64
+	}
+
+	Boolean struct {
+		Value bool
+	}
+
+	Null struct{}
+
+	String struct {
+		Value string
+	}
+
+	Array struct {
+		Elements []Object
+	}
+
+	ReturnValue struct {
+		Value Object
+	}
+
+	Function struct {
+		Name       string
+		Parameters []*ast.Identifier
+		Body       *ast.BlockStatement
+		Env        *Environment
+	}
+
+	// A CompiledFunction is the bytecode of a function literal. It is a
+	// constant of the compiled program; the value of the literal is a
+	// Closure over it. Source is the literal as a Function inspects it.
+	CompiledFunction struct {
+		Name          string
+		Source        string
+		Instructions  code.Instructions
+		Lines         code.LineTable
+		NumLocals     int
+		NumParameters int
+	}
+
+	// A Closure is a function of a compiled program, along with the free
+	// variables it refers to, which the VM holds in cells of its own. It
+	// has the type of a Function.
+	Closure struct {
+		Fn   *CompiledFunction
+		Free []Object
+	}
+)
+
+func (*Integer) Type() ObjectType  { return INTEGER_OBJ }
+func (i *Integer) Inspect() string { return fmt.Sprintf("%d", i.Value) }
+
+func (*Boolean) Type() ObjectType  { return BOOLEAN_OBJ }
+func (b *Boolean) Inspect() string { return fmt.Sprintf("%t", b.Value) }
+
+func (*Null) Type() ObjectType { return NULL_OBJ }
+func (*Null) Inspect() string  { return "null" }
+
+func (*String) Type() ObjectType  { return STRING_OBJ }
+func (s *String) Inspect() string { return s.Value }
+
+func (*Array) Type() ObjectType { return ARRAY_OBJ }
+func (a *Array) Inspect() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+
+	return out.String()
+}
+
+func (*ReturnValue) Type() ObjectType   { return RETURN_VALUE_OBJ }
+func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
+
+func (*Function) Type() ObjectType { return FUNCTION_OBJ }
+func (f *Function) Inspect() string {
+	return FunctionSource(f.Parameters, f.Body)
+}
+
+// FunctionSource returns how a function with parameters and body is
+// inspected, by either engine.
+func FunctionSource(parameters []*ast.Identifier, body *ast.BlockStatement) string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("fn(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
+func (*CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%s]", cf.Name)
+}
+
+func (*Closure) Type() ObjectType  { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string { return c.Fn.Source }
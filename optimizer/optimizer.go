@@ -0,0 +1,265 @@
+package optimizer
+
+import (
+	"strconv"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/token"
+)
+
+// Optimize folds constant expressions, removes arithmetic identities and
+// prunes if branches that can never be taken. The program is rewritten in
+// place and returned.
+//
+// Every rewrite preserves runtime behaviour exactly: integer arithmetic wraps
+// around like int64, and expressions that would fail at runtime, such as a
+// division by zero or an operator applied to mismatched types, are left
+// untouched so that they still fail when evaluated.
+func Optimize(program *ast.Program) *ast.Program {
+	statements(program.Statements)
+
+	return program
+}
+
+func statements(stmts []ast.Statement) {
+	for _, s := range stmts {
+		statement(s)
+	}
+}
+
+func statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		stmt.Expression = expression(stmt.Expression)
+	case *ast.LetStatement:
+		stmt.Value = expression(stmt.Value)
+	case *ast.ReturnStatement:
+		stmt.ReturnValue = expression(stmt.ReturnValue)
+	case *ast.BlockStatement:
+		statements(stmt.Statements)
+	}
+}
+
+func expression(exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		exp.Right = expression(exp.Right)
+		return prefix(exp)
+	case *ast.InfixExpression:
+		exp.Left = expression(exp.Left)
+		exp.Right = expression(exp.Right)
+		return infix(exp)
+	case *ast.IfExpression:
+		exp.Condition = expression(exp.Condition)
+		statement(exp.Consequence)
+		if exp.Alternative != nil {
+			statement(exp.Alternative)
+		}
+		return branch(exp)
+	case *ast.FunctionLiteral:
+		if exp.Body != nil {
+			statement(exp.Body)
+		}
+	case *ast.CallExpression:
+		exp.Function = expression(exp.Function)
+		for i, a := range exp.Arguments {
+			exp.Arguments[i] = expression(a)
+		}
+	case *ast.ArrayLiteral:
+		for i, e := range exp.Elements {
+			exp.Elements[i] = expression(e)
+		}
+	case *ast.HashLiteral:
+		for i, p := range exp.Pairs {
+			exp.Pairs[i] = ast.HashPair{Key: expression(p.Key), Value: expression(p.Value)}
+		}
+	case *ast.IndexExpression:
+		exp.Left = expression(exp.Left)
+		exp.Index = expression(exp.Index)
+	}
+
+	return exp
+}
+
+func prefix(pe *ast.PrefixExpression) ast.Expression {
+	switch right := pe.Right.(type) {
+	case *ast.IntegerLiteral:
+		switch pe.Operator {
+		case "-":
+			return integer(pe.Pos(), -right.Value)
+		case "!":
+			return boolean(pe.Pos(), false)
+		}
+	case *ast.Boolean:
+		if pe.Operator == "!" {
+			return boolean(pe.Pos(), !right.Value)
+		}
+	}
+
+	return pe
+}
+
+func infix(ie *ast.InfixExpression) ast.Expression {
+	if left, ok := ie.Left.(*ast.IntegerLiteral); ok {
+		if right, ok := ie.Right.(*ast.IntegerLiteral); ok {
+			return foldIntegers(ie, left.Value, right.Value)
+		}
+	}
+
+	if left, ok := ie.Left.(*ast.Boolean); ok {
+		if right, ok := ie.Right.(*ast.Boolean); ok {
+			return foldBooleans(ie, left.Value, right.Value)
+		}
+	}
+
+	return simplify(ie)
+}
+
+func foldIntegers(ie *ast.InfixExpression, left, right int64) ast.Expression {
+	switch ie.Operator {
+	case "+":
+		return integer(ie.Pos(), left+right)
+	case "-":
+		return integer(ie.Pos(), left-right)
+	case "*":
+		return integer(ie.Pos(), left*right)
+	case "/":
+		if right == 0 {
+			return ie
+		}
+		return integer(ie.Pos(), left/right)
+	case "<":
+		return boolean(ie.Pos(), left < right)
+	case ">":
+		return boolean(ie.Pos(), left > right)
+	case "==":
+		return boolean(ie.Pos(), left == right)
+	case "!=":
+		return boolean(ie.Pos(), left != right)
+	}
+
+	return ie
+}
+
+func foldBooleans(ie *ast.InfixExpression, left, right bool) ast.Expression {
+	switch ie.Operator {
+	case "==":
+		return boolean(ie.Pos(), left == right)
+	case "!=":
+		return boolean(ie.Pos(), left != right)
+	}
+
+	return ie
+}
+
+// simplify removes additive and multiplicative identities. The remaining
+// operand must be known to evaluate to an integer: `x + 0` is an error
+// rather than x when x is a boolean.
+func simplify(ie *ast.InfixExpression) ast.Expression {
+	switch ie.Operator {
+	case "+":
+		if isIntegerValue(ie.Right, 0) && isInteger(ie.Left) {
+			return ie.Left
+		}
+		if isIntegerValue(ie.Left, 0) && isInteger(ie.Right) {
+			return ie.Right
+		}
+	case "-":
+		if isIntegerValue(ie.Right, 0) && isInteger(ie.Left) {
+			return ie.Left
+		}
+	case "*":
+		if isIntegerValue(ie.Right, 1) && isInteger(ie.Left) {
+			return ie.Left
+		}
+		if isIntegerValue(ie.Left, 1) && isInteger(ie.Right) {
+			return ie.Right
+		}
+	case "/":
+		if isIntegerValue(ie.Right, 1) && isInteger(ie.Left) {
+			return ie.Left
+		}
+	}
+
+	return ie
+}
+
+// branch prunes the branch of an if expression that its constant condition
+// rules out. The expression itself is kept, with a condition that is always
+// true, so that the value of the taken branch is still its result.
+func branch(ie *ast.IfExpression) ast.Expression {
+	truthy, ok := constantTruthiness(ie.Condition)
+	if !ok {
+		return ie
+	}
+
+	switch {
+	case truthy:
+		ie.Alternative = nil
+	case ie.Alternative != nil:
+		ie.Condition = boolean(ie.Condition.Pos(), true)
+		ie.Consequence = ie.Alternative
+		ie.Alternative = nil
+	default:
+		ie.Consequence = &ast.BlockStatement{
+			Token:      ie.Consequence.Token,
+			Statements: []ast.Statement{},
+		}
+	}
+
+	return ie
+}
+
+func constantTruthiness(exp ast.Expression) (bool, bool) {
+	switch exp := exp.(type) {
+	case *ast.Boolean:
+		return exp.Value, true
+	case *ast.IntegerLiteral:
+		return true, true
+	}
+
+	return false, false
+}
+
+// isInteger reports whether exp evaluates to an integer whenever its
+// evaluation succeeds.
+func isInteger(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return exp.Operator == "-"
+	case *ast.InfixExpression:
+		switch exp.Operator {
+		case "-", "*", "/":
+			return true
+		case "+":
+			return isInteger(exp.Left) && isInteger(exp.Right)
+		}
+	}
+
+	return false
+}
+
+func isIntegerValue(exp ast.Expression, value int64) bool {
+	il, ok := exp.(*ast.IntegerLiteral)
+
+	return ok && il.Value == value
+}
+
+func integer(pos token.Position, value int64) *ast.IntegerLiteral {
+	tok := token.INT.Token(strconv.FormatInt(value, 10))
+	tok.Position = pos
+
+	return &ast.IntegerLiteral{Token: tok, Value: value}
+}
+
+func boolean(pos token.Position, value bool) *ast.Boolean {
+	tok := token.FALSE.Token("false")
+	if value {
+		tok = token.TRUE.Token("true")
+	}
+	tok.Position = pos
+
+	return &ast.Boolean{Token: tok, Value: value}
+}
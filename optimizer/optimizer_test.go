@@ -0,0 +1,262 @@
+package optimizer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/parser"
+	"github.com/marcel/monkey/vm"
+	"github.com/stretchr/testify/suite"
+)
+
+type OptimizerTestSuite struct {
+	suite.Suite
+}
+
+func TestOptimizerTestSuite(t *testing.T) {
+	suite.Run(t, new(OptimizerTestSuite))
+}
+
+func (s *OptimizerTestSuite) TestOptimize() {
+	expectations := []struct {
+		Input    string
+		Expected string
+	}{
+		{"2 * 3 + 1", "7"},
+		{"!true", "false"},
+		{"!!5", "true"},
+		{"-(2 - 5)", "3"},
+		{"1 < 2 == true", "true"},
+		{"true != false", "true"},
+		{"9223372036854775807 + 1", "-9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "-9223372036854775808"},
+		{"1 / 0", "(1 / 0)"},
+		{"4 / (2 - 2)", "(4 / 0)"},
+		{"-true", "(-true)"},
+		{"true + 1", "(true + 1)"},
+		{"true < false", "(true < false)"},
+		{"1 == true", "(1 == true)"},
+		{"x * 1", "(x * 1)"},
+		{"(x - y) * 1", "(x - y)"},
+		{"1 * (x * y)", "(x * y)"},
+		{"(x / y) + 0", "(x / y)"},
+		{"0 + -x", "(-x)"},
+		{"(a + b) + 0", "((a + b) + 0)"},
+		{"((a - 1) + (b * 2)) - 0", "((a - 1) + (b * 2))"},
+		{"(x * 2) / (3 - 2)", "(x * 2)"},
+		{"let f = fn(x) { return x * (2 + 3); };", "let f = fn(x) return (x * 5);;"},
+		{"add(1 + 2, f(3 * 3))", "add(3, f(9))"},
+		{`[1 + 1, {"a" + "b": 2 * 2}[x * 1]]`, "[2, ({(a + b):4}[(x * 1)])]"},
+		{`"a" + 0`, "(a + 0)"},
+		{"if (1 < 2) { a } else { b }", "iftrue a"},
+		{"if (2 < 1) { a } else { b }", "iftrue b"},
+		{"if (!true) { a }", "iffalse "},
+		{"if (5) { a } else { b }", "if5 a"},
+		{"if (x) { 1 + 1 } else { 2 * 2 }", "ifx 2else 4"},
+	}
+
+	for _, e := range expectations {
+		p := parser.New(lexer.New(e.Input))
+		program := p.ParseProgram()
+		s.Empty(p.Errors())
+
+		s.Equal(e.Expected, Optimize(program).String(), e.Input)
+	}
+}
+
+func (s *OptimizerTestSuite) TestPeephole() {
+	expectations := []struct {
+		Input     string
+		Expected  []string
+		Constants []object.Object
+	}{
+		{
+			"1 + 2 * 3 - -4",
+			[]string{"OpConstant 0", "OpReturnValue"},
+			[]object.Object{&object.Integer{Value: 11}},
+		},
+		{
+			"9223372036854775807 + 1 > 0",
+			[]string{"OpFalse", "OpReturnValue"},
+			[]object.Object{},
+		},
+		{
+			"1 / 0",
+			[]string{"OpConstant 0", "OpConstant 1", "OpDiv", "OpReturnValue"},
+			[]object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 0}},
+		},
+		{
+			`1; "a"; !true; x`,
+			[]string{"OpGetGlobal 0", "OpReturnValue"},
+			[]object.Object{},
+		},
+		{
+			"if (true) { 10 } else { 20 }",
+			[]string{"OpConstant 0", "OpReturnValue"},
+			[]object.Object{&object.Integer{Value: 10}},
+		},
+		{
+			`if ("") { 10 }`,
+			[]string{"OpConstant 0", "OpReturnValue"},
+			[]object.Object{&object.Integer{Value: 10}},
+		},
+		{
+			"if (1 > 2) { 10 }",
+			[]string{"OpNull", "OpReturnValue"},
+			[]object.Object{},
+		},
+		{
+			`let a = "x"; let b = 2; let c = "x"; [b, 2, a + c]`,
+			[]string{
+				"OpConstant 0", "OpSetGlobal 0",
+				"OpConstant 1", "OpSetGlobal 1",
+				"OpConstant 0", "OpSetGlobal 2",
+				"OpGetGlobal 1", "OpConstant 1", "OpGetGlobal 0", "OpGetGlobal 2", "OpAdd", "OpArray 3",
+				"OpReturnValue",
+			},
+			[]object.Object{&object.String{Value: "x"}, &object.Integer{Value: 2}},
+		},
+		{
+			"if (x) { if (y) { 1 } else { 2 } } else { 3 }",
+			[]string{
+				"OpGetGlobal 0", "OpJumpNotTruthy 24",
+				"OpGetGlobal 1", "OpJumpNotTruthy 18",
+				"OpConstant 0", "OpJump 27",
+				"OpConstant 1", "OpJump 27",
+				"OpConstant 2",
+				"OpReturnValue",
+			},
+			[]object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 2}, &object.Integer{Value: 3}},
+		},
+	}
+
+	for _, e := range expectations {
+		bytecode := Peephole(s.compile(e.Input), 0)
+
+		s.Equal(e.Expected, s.disassemble(bytecode.Instructions), e.Input)
+		s.Equal(e.Constants, bytecode.Constants, e.Input)
+		s.NoError(vm.Verify(bytecode), e.Input)
+	}
+}
+
+func (s *OptimizerTestSuite) TestPeepholeFunctions() {
+	bytecode := Peephole(s.compile("let f = fn(x) { let y = 2 * 3; x; if (true) { y } else { fn() { x } } }; f(1)"), 0)
+
+	s.Require().Len(bytecode.Constants, 3)
+	s.Equal(&object.Integer{Value: 1}, bytecode.Constants[1])
+	s.Equal(&object.Integer{Value: 6}, bytecode.Constants[2])
+
+	fn := bytecode.Constants[0].(*object.CompiledFunction)
+	s.Equal("f", fn.Name)
+	s.Equal(
+		[]string{
+			"OpGetLocal 0", "OpCell 0", "OpSetCell 0",
+			"OpConstant 2", "OpSetLocal 1", "OpGetLocal 1", "OpReturnValue",
+		},
+		s.disassemble(fn.Instructions),
+		"the unreachable closure is dropped, and its constant with it, but x is still held in a cell",
+	)
+	s.Equal(&object.Integer{Value: 6}, vm.New(bytecode).Run())
+}
+
+func (s *OptimizerTestSuite) TestPeepholeLines() {
+	input := `let a = 1 + 2;
+if (true) {
+  a / (4 - 4)
+}`
+	bytecode := Peephole(s.compile(input), 0)
+
+	s.Equal(
+		[]string{"OpConstant 0", "OpSetGlobal 0", "OpGetGlobal 0", "OpConstant 1", "OpDiv", "OpReturnValue"},
+		s.disassemble(bytecode.Instructions),
+	)
+	s.Equal("1:11", bytecode.Lines.Lookup(0).String())
+	s.Equal("1:5", bytecode.Lines.Lookup(3).String())
+	s.Equal("3:3", bytecode.Lines.Lookup(6).String())
+	s.Equal("3:10", bytecode.Lines.Lookup(9).String())
+	s.Equal("3:5", bytecode.Lines.Lookup(12).String())
+
+	err, ok := vm.New(bytecode).Run().(*object.Error)
+	s.Require().True(ok)
+	s.Equal("3:5: division by zero", err.Error())
+}
+
+func (s *OptimizerTestSuite) TestPeepholeShared() {
+	symbolTable := compiler.NewGlobalSymbolTable(compiler.DefaultBuiltins())
+
+	first := compiler.NewWithState(symbolTable, []object.Object{})
+	s.Require().NoError(first.Compile(s.parse("let f = fn() { 5 }; 1 + 1")))
+	shared := Peephole(first.Bytecode(), 0).Constants
+
+	second := compiler.NewWithState(symbolTable, shared)
+	s.Require().NoError(second.Compile(s.parse("f() + 5 + 2 * 1 + 2 * 4")))
+	bytecode := Peephole(second.Bytecode(), len(shared))
+
+	s.Equal(shared, bytecode.Constants[:len(shared)], "shared constants keep their indices")
+	s.Len(bytecode.Constants, len(shared)+1, "new constants are merged with shared ones")
+	s.Equal(&object.Integer{Value: 8}, bytecode.Constants[len(shared)])
+	s.Equal(
+		[]string{
+			"OpGetGlobal 0", "OpCall 0",
+			"OpConstant 0", "OpAdd",
+			"OpConstant 2", "OpAdd",
+			"OpConstant 3", "OpAdd",
+			"OpReturnValue",
+		},
+		s.disassemble(bytecode.Instructions),
+	)
+}
+
+// TestPeepholeBehavior checks that optimized programs compute what the
+// programs they were optimized from do.
+func (s *OptimizerTestSuite) TestPeepholeBehavior() {
+	inputs := []string{
+		"let x = 5; if (x > 2) { x * 2 } else { x / 0 }",
+		"let f = fn(n) { if (n < 2) { n } else { f(n - 1) + f(n - 2) } }; f(10)",
+		"let a = [1 + 1, 2 * 2]; let h = {\"k\" + \"\": -a[1]}; h[\"k\"]",
+		"let g = fn(x) { fn(y) { if (false) { 0 } else { x + y + (3 - 3) } } }; g(1)(2)",
+		"if (!!1 == true) { 1; 2; 3 }",
+		"let x = if (true) { 1 }; let y = if (null) { 2 }; [x, y, -(-9223372036854775807 - 1)]",
+		"len(\"abc\") * 3 / 0",
+		"let z = fn() { return 1; 2 }; z() + (2 - 1) * 0",
+	}
+
+	for _, input := range inputs {
+		bytecode := s.compile(input)
+		optimized := Peephole(bytecode, 0)
+
+		s.NoError(vm.Verify(optimized), input)
+		s.Equal(vm.New(bytecode).Run().Inspect(), vm.New(optimized).Run().Inspect(), input)
+		s.LessOrEqual(len(optimized.Instructions), len(bytecode.Instructions), input)
+	}
+}
+
+func (s *OptimizerTestSuite) compile(input string) *compiler.Bytecode {
+	c := compiler.New()
+	s.Require().NoError(c.Compile(s.parse(input)), input)
+
+	return c.Bytecode()
+}
+
+func (s *OptimizerTestSuite) parse(input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	s.Require().Empty(p.Errors(), input)
+
+	return program
+}
+
+// disassemble returns the instructions of ins without their offsets.
+func (s *OptimizerTestSuite) disassemble(ins code.Instructions) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(ins.String()), "\n") {
+		lines = append(lines, strings.SplitN(line, " ", 2)[1])
+	}
+
+	return lines
+}
@@ -0,0 +1,543 @@
+package optimizer
+
+import (
+	"math"
+
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/token"
+)
+
+const maxConstants = math.MaxUint16 + 1
+
+type (
+	// A peephole optimizes the functions of a program, which share its
+	// constants.
+	peephole struct {
+		constants []object.Object
+		shared    int
+	}
+
+	// A function is a sequence of decoded instructions. Jumps refer to the
+	// index of their target rather than its offset.
+	function []instruction
+
+	instruction struct {
+		op       code.Opcode
+		operands []int
+		pos      token.Position
+	}
+
+	// A constantKey identifies an integer or string constant by value.
+	constantKey struct {
+		t     object.ObjectType
+		value interface{}
+	}
+)
+
+// Peephole returns program with its instructions simplified: constant
+// arithmetic is folded, values pushed only to be popped are dropped, jumps
+// to jumps go straight to their final target, conditional jumps on
+// constants become unconditional or vanish, and code that can no longer be
+// reached is removed. Identical constants are merged and unused ones
+// dropped. Line tables follow the instructions they describe.
+//
+// The first shared constants belong to programs compiled earlier with the
+// same state, whose closures may still refer to them; they keep their
+// indices and their functions are left as they are. program is not
+// changed.
+//
+// The result behaves like program except for the gas, steps and
+// allocations the optimized operations no longer take.
+func Peephole(program *compiler.Bytecode, shared int) *compiler.Bytecode {
+	p := &peephole{constants: append([]object.Object{}, program.Constants...), shared: shared}
+
+	main := p.optimize(decode(program.Instructions, program.Lines))
+
+	functions := map[int]function{}
+	for i := shared; i < len(program.Constants); i++ {
+		if fn, ok := program.Constants[i].(*object.CompiledFunction); ok {
+			functions[i] = p.optimize(decode(fn.Instructions, fn.Lines))
+		}
+	}
+
+	indexes := p.compact(main, functions)
+
+	optimized := &compiler.Bytecode{
+		Constants: p.constants,
+		Globals:   program.Globals,
+		Builtins:  program.Builtins,
+	}
+
+	optimized.Instructions, optimized.Lines = main.encode(indexes)
+
+	for i, f := range functions {
+		index, ok := indexes[i]
+		if !ok {
+			continue
+		}
+
+		fn := *program.Constants[i].(*object.CompiledFunction)
+		fn.Instructions, fn.Lines = f.encode(indexes)
+		p.constants[index] = &fn
+	}
+
+	return optimized
+}
+
+func decode(ins code.Instructions, lines code.LineTable) function {
+	var f function
+	at := map[int]int{}
+
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			return nil
+		}
+
+		operands, read := code.ReadOperands(def, ins[ip+1:])
+		at[ip] = len(f)
+		f = append(f, instruction{op: code.Opcode(ins[ip]), operands: operands, pos: lines.Lookup(ip)})
+
+		ip += 1 + read
+	}
+
+	for i, ins := range f {
+		if ins.isJump() {
+			f[i] = ins.withTarget(at[ins.target()])
+		}
+	}
+
+	return f
+}
+
+// encode returns the instructions of f and their line table, with
+// constants renumbered by indexes.
+func (f function) encode(indexes map[int]int) (code.Instructions, code.LineTable) {
+	offsets := make([]int, len(f))
+	offset := 0
+	for i, ins := range f {
+		offsets[i] = offset
+		offset += len(code.Make(ins.op, ins.operands...))
+	}
+
+	instructions := code.Instructions{}
+	var lines code.LineTable
+
+	for i, ins := range f {
+		operands := append([]int{}, ins.operands...)
+		switch {
+		case ins.isJump():
+			operands[len(operands)-1] = offsets[ins.target()]
+		case ins.op == code.OpConstant || ins.op == code.OpClosure:
+			if index, ok := indexes[operands[0]]; ok {
+				operands[0] = index
+			}
+		}
+
+		lines = lines.Add(offsets[i], ins.pos)
+		instructions = append(instructions, code.Make(ins.op, operands...)...)
+	}
+
+	return instructions, lines
+}
+
+// isJump reports whether ins may jump to the instruction its last operand
+// refers to.
+func (ins instruction) isJump() bool {
+	switch ins.op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpGetFreeIfBound, code.OpGetGlobalIfBound:
+		return true
+	}
+
+	return false
+}
+
+func (ins instruction) target() int {
+	return ins.operands[len(ins.operands)-1]
+}
+
+// withTarget returns a copy of ins, a jump, that jumps to target.
+func (ins instruction) withTarget(target int) instruction {
+	ins.operands = append([]int{}, ins.operands...)
+	ins.operands[len(ins.operands)-1] = target
+
+	return ins
+}
+
+// optimize applies the rewrites to f until none applies any more.
+func (p *peephole) optimize(f function) function {
+	if len(f) == 0 {
+		return f
+	}
+
+	for {
+		changed := f.threadJumps()
+
+		removed := make([]bool, len(f))
+		targets := f.targets()
+
+		for i := 0; i < len(f); i++ {
+			// Only the first instruction of a rewritten sequence may be
+			// jumped to.
+			following := func(n int) bool {
+				if i+n >= len(f) {
+					return false
+				}
+				for j := i + 1; j <= i+n; j++ {
+					if targets[j] || removed[j] {
+						return false
+					}
+				}
+				return true
+			}
+
+			ins := f[i]
+			if removed[i] {
+				continue
+			}
+
+			switch {
+			case following(2) && p.foldBinary(f, i):
+				removed[i+1], removed[i+2] = true, true
+			case following(1) && p.foldUnary(f, i):
+				removed[i+1] = true
+			case following(1) && f[i+1].op == code.OpPop && pure(ins.op):
+				removed[i], removed[i+1] = true, true
+			case following(1) && f[i+1].op == code.OpJumpNotTruthy && p.constantCondition(ins) != nil:
+				if *p.constantCondition(ins) {
+					removed[i], removed[i+1] = true, true
+				} else {
+					f[i] = instruction{op: code.OpJump, operands: f[i+1].operands, pos: f[i+1].pos}
+					removed[i+1] = true
+				}
+			case ins.op == code.OpJump && ins.operands[0] == i+1:
+				removed[i] = true
+			default:
+				continue
+			}
+
+			changed = true
+		}
+
+		f = f.remove(removed)
+
+		unreachable := f.unreachable()
+		for _, r := range unreachable {
+			changed = changed || r
+		}
+		f = f.remove(unreachable)
+
+		if !changed {
+			return f
+		}
+	}
+}
+
+// threadJumps points jumps whose target is an unconditional jump at the
+// target of that jump.
+func (f function) threadJumps() bool {
+	changed := false
+
+	for i, ins := range f {
+		if !ins.isJump() {
+			continue
+		}
+
+		target := ins.target()
+		for n := 0; n < len(f) && f[target].op == code.OpJump && f[target].operands[0] != target; n++ {
+			target = f[target].operands[0]
+		}
+
+		if target != ins.target() {
+			f[i] = ins.withTarget(target)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// targets reports which instructions are jumped to.
+func (f function) targets() []bool {
+	targets := make([]bool, len(f))
+	for _, ins := range f {
+		if ins.isJump() {
+			targets[ins.target()] = true
+		}
+	}
+
+	return targets
+}
+
+// unreachable reports which instructions no path from the first one
+// reaches.
+func (f function) unreachable() []bool {
+	reached := make([]bool, len(f))
+
+	for work := []int{0}; len(work) > 0; {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if i >= len(f) || reached[i] {
+			continue
+		}
+		reached[i] = true
+
+		switch f[i].op {
+		case code.OpReturnValue, code.OpReturn:
+		case code.OpJump:
+			work = append(work, f[i].operands[0])
+		case code.OpJumpNotTruthy, code.OpGetFreeIfBound, code.OpGetGlobalIfBound:
+			work = append(work, i+1, f[i].target())
+		default:
+			work = append(work, i+1)
+		}
+	}
+
+	for i := range reached {
+		reached[i] = !reached[i]
+	}
+
+	return reached
+}
+
+// remove drops the instructions marked removed. Jumps to a removed
+// instruction go to the first one after it that is kept.
+func (f function) remove(removed []bool) function {
+	indexes := make([]int, len(f)+1)
+	kept := function{}
+
+	for i, ins := range f {
+		indexes[i] = len(kept)
+		if !removed[i] {
+			kept = append(kept, ins)
+		}
+	}
+	indexes[len(f)] = len(kept)
+
+	for i, ins := range kept {
+		if ins.isJump() {
+			kept[i] = ins.withTarget(indexes[ins.target()])
+		}
+	}
+
+	return kept
+}
+
+// foldBinary replaces an operator applied to two integer constants at
+// f[i] and f[i+1] with its result. Divisions by zero are left to fail at
+// runtime.
+func (p *peephole) foldBinary(f function, i int) bool {
+	left, ok := p.integer(f[i])
+	if !ok {
+		return false
+	}
+
+	right, ok := p.integer(f[i+1])
+	if !ok {
+		return false
+	}
+
+	var result instruction
+
+	switch f[i+2].op {
+	case code.OpAdd:
+		result, ok = p.pushInteger(left + right)
+	case code.OpSub:
+		result, ok = p.pushInteger(left - right)
+	case code.OpMul:
+		result, ok = p.pushInteger(left * right)
+	case code.OpDiv:
+		if right == 0 {
+			return false
+		}
+		result, ok = p.pushInteger(left / right)
+	case code.OpLessThan:
+		result = pushBoolean(left < right)
+	case code.OpGreaterThan:
+		result = pushBoolean(left > right)
+	case code.OpEqual:
+		result = pushBoolean(left == right)
+	case code.OpNotEqual:
+		result = pushBoolean(left != right)
+	default:
+		return false
+	}
+
+	if !ok {
+		return false
+	}
+
+	result.pos = f[i+2].pos
+	f[i] = result
+
+	return true
+}
+
+// foldUnary replaces a prefix operator applied to the constant at f[i]
+// with its result.
+func (p *peephole) foldUnary(f function, i int) bool {
+	var result instruction
+
+	switch f[i+1].op {
+	case code.OpMinus:
+		value, ok := p.integer(f[i])
+		if !ok {
+			return false
+		}
+
+		if result, ok = p.pushInteger(-value); !ok {
+			return false
+		}
+	case code.OpBang:
+		truthy := p.constantCondition(f[i])
+		if truthy == nil {
+			return false
+		}
+
+		result = pushBoolean(!*truthy)
+	default:
+		return false
+	}
+
+	result.pos = f[i+1].pos
+	f[i] = result
+
+	return true
+}
+
+// constantCondition returns whether the value ins pushes is truthy, or nil
+// if that is not known before the program runs.
+func (p *peephole) constantCondition(ins instruction) *bool {
+	truthy := true
+
+	switch ins.op {
+	case code.OpTrue:
+	case code.OpFalse, code.OpNull:
+		truthy = false
+	case code.OpConstant:
+		switch p.constants[ins.operands[0]].(type) {
+		case *object.Integer, *object.String:
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	return &truthy
+}
+
+func (p *peephole) integer(ins instruction) (int64, bool) {
+	if ins.op != code.OpConstant {
+		return 0, false
+	}
+
+	integer, ok := p.constants[ins.operands[0]].(*object.Integer)
+	if !ok {
+		return 0, false
+	}
+
+	return integer.Value, true
+}
+
+// pushInteger returns an instruction that pushes value, which is added to
+// the constants unless they are full.
+func (p *peephole) pushInteger(value int64) (instruction, bool) {
+	if len(p.constants) >= maxConstants {
+		return instruction{}, false
+	}
+
+	p.constants = append(p.constants, &object.Integer{Value: value})
+
+	return instruction{op: code.OpConstant, operands: []int{len(p.constants) - 1}}, true
+}
+
+func pushBoolean(value bool) instruction {
+	if value {
+		return instruction{op: code.OpTrue, operands: []int{}}
+	}
+
+	return instruction{op: code.OpFalse, operands: []int{}}
+}
+
+// pure reports whether op only pushes a value, without any other effect or
+// any way to fail in the programs the compiler produces.
+func pure(op code.Opcode) bool {
+	switch op {
+	case code.OpConstant, code.OpTrue, code.OpFalse, code.OpNull,
+		code.OpGetLocal, code.OpGetCell, code.OpGetFree, code.OpGetFreeCell:
+		return true
+	}
+
+	return false
+}
+
+// compact drops the constants after the shared ones that main and
+// functions no longer refer to and merges identical integers and strings.
+// It returns the new index of every constant kept.
+func (p *peephole) compact(main function, functions map[int]function) map[int]int {
+	used := map[int]bool{}
+
+	var mark func(f function)
+	mark = func(f function) {
+		for _, ins := range f {
+			if ins.op != code.OpConstant && ins.op != code.OpClosure {
+				continue
+			}
+
+			index := ins.operands[0]
+			if index < p.shared || used[index] {
+				continue
+			}
+
+			used[index] = true
+			if fn, ok := functions[index]; ok {
+				mark(fn)
+			}
+		}
+	}
+	mark(main)
+
+	constants := p.constants[:p.shared:p.shared]
+	indexes := map[int]int{}
+	byValue := map[constantKey]int{}
+
+	for i, constant := range p.constants {
+		if i >= p.shared && !used[i] {
+			continue
+		}
+
+		var key *constantKey
+		switch constant := constant.(type) {
+		case *object.Integer:
+			key = &constantKey{constant.Type(), constant.Value}
+		case *object.String:
+			key = &constantKey{constant.Type(), constant.Value}
+		}
+
+		if key != nil {
+			if index, ok := byValue[*key]; ok {
+				indexes[i] = index
+				continue
+			}
+		}
+
+		index := i
+		if i >= p.shared {
+			index = len(constants)
+			constants = append(constants, constant)
+		}
+
+		indexes[i] = index
+		if key != nil {
+			byValue[*key] = index
+		}
+	}
+
+	p.constants = constants
+
+	return indexes
+}
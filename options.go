@@ -0,0 +1,115 @@
+package monkey
+
+import (
+	"io"
+
+	"github.com/marcel/monkey/gas"
+)
+
+const (
+	// TreeWalking evaluates programs by walking their AST.
+	TreeWalking Engine = iota
+	// VM compiles programs to bytecode and runs them on a virtual machine.
+	VM
+)
+
+type (
+	// An Engine is the way an Interpreter executes programs.
+	Engine int
+
+	Option func(*config)
+
+	config struct {
+		stdout      io.Writer
+		stderr      io.Writer
+		engine      Engine
+		maxSteps    int64
+		maxDepth    int
+		memoryQuota int64
+		gasLimit    uint64
+		gasTable    *gas.Table
+		optimize    bool
+		without     []string
+	}
+)
+
+var engineNames = map[Engine]string{
+	TreeWalking: "tree-walking",
+	VM:          "vm",
+}
+
+func (e Engine) String() string {
+	if name, ok := engineNames[e]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// WithStdout sets where puts writes to.
+func WithStdout(w io.Writer) Option {
+	return func(c *config) { c.stdout = w }
+}
+
+// WithStderr sets where eputs writes to.
+func WithStderr(w io.Writer) Option {
+	return func(c *config) { c.stderr = w }
+}
+
+// WithoutBuiltins removes the builtins names from the Interpreter, so that
+// programs cannot call them. Interpreter.Bind binds another function to a
+// name, builtin or not.
+func WithoutBuiltins(names ...string) Option {
+	return func(c *config) { c.without = append(c.without, names...) }
+}
+
+// WithMaxSteps limits the number of steps a single call to Eval may take;
+// exceeding it fails with ErrStepLimit. The default is DefaultMaxSteps;
+// zero means no limit.
+func WithMaxSteps(n int64) Option {
+	return func(c *config) { c.maxSteps = n }
+}
+
+// WithMaxDepth limits the depth of nested function calls; exceeding it
+// fails with ErrStackOverflow. Zero means no limit, which lets deep
+// recursion crash the Go program. Calls in tail position reuse the frame
+// of their caller and never reach the limit: tail recursion runs until
+// the step limit, gas or the deadline of the context stops it.
+func WithMaxDepth(n int) Option {
+	return func(c *config) { c.maxDepth = n }
+}
+
+// WithMemoryQuota limits the number of bytes a single call to Eval,
+// EvalFile or Call may allocate; exceeding it fails with ErrMemoryLimit.
+// Zero, the default, means no limit. What is accounted depends on the
+// engine, so the same program may use several times more of the quota on
+// the evaluator than on the VM.
+func WithMemoryQuota(bytes int64) Option {
+	return func(c *config) { c.memoryQuota = bytes }
+}
+
+// WithGasLimit limits the gas a single call to Eval may use; exceeding it
+// fails with ErrOutOfGas. Costs are those of gas.DefaultTable unless
+// WithGasTable is given too.
+func WithGasLimit(limit uint64) Option {
+	return func(c *config) { c.gasLimit = limit }
+}
+
+// WithGasTable meters gas with the costs of table.
+func WithGasTable(table gas.Table) Option {
+	return func(c *config) { c.gasTable = &table }
+}
+
+// WithEngine sets the engine programs are executed with.
+func WithEngine(e Engine) Option {
+	return func(c *config) { c.engine = e }
+}
+
+// WithOptimizations optimizes programs before they are executed: the
+// tree-walking engine folds constant expressions of the AST, see
+// optimizer.Optimize, and the VM engine simplifies the compiled
+// instructions, see optimizer.Peephole. Results and errors stay the same,
+// but the gas, steps and allocations they take may be fewer.
+func WithOptimizations() Option {
+	return func(c *config) { c.optimize = true }
+}
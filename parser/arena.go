@@ -0,0 +1,87 @@
+package parser
+
+import "github.com/marcel/monkey/ast"
+
+// maxSlabSize is the largest number of nodes of a type an arena allocates
+// at once. Slabs start small and double in size up to it, so that little
+// is wasted on small programs.
+const maxSlabSize = 256
+
+type (
+	// A slab holds nodes that have been allocated but not handed out yet,
+	// size the number allocated last.
+	slab[T any] struct {
+		free []T
+		size int
+	}
+
+	// An arena allocates the nodes of an AST, up to maxSize at once. With
+	// a maxSize of 1, the default, every node is allocated by itself.
+	arena struct {
+		maxSize int
+
+		identifiers slab[ast.Identifier]
+		integers    slab[ast.IntegerLiteral]
+		strings     slab[ast.StringLiteral]
+		booleans    slab[ast.Boolean]
+		arrays      slab[ast.ArrayLiteral]
+		hashes      slab[ast.HashLiteral]
+		functions   slab[ast.FunctionLiteral]
+		prefixes    slab[ast.PrefixExpression]
+		infixes     slab[ast.InfixExpression]
+		ifs         slab[ast.IfExpression]
+		calls       slab[ast.CallExpression]
+		indexes     slab[ast.IndexExpression]
+		expressions slab[ast.ExpressionStatement]
+		lets        slab[ast.LetStatement]
+		returns     slab[ast.ReturnStatement]
+		blocks      slab[ast.BlockStatement]
+
+		statementLists  slab[ast.Statement]
+		expressionLists slab[ast.Expression]
+		identifierLists slab[*ast.Identifier]
+		pairLists       slab[ast.HashPair]
+	}
+
+	// scratch holds the lists being parsed, nested ones above those they
+	// are nested in, until they are complete and copied to the arena.
+	scratch struct {
+		statements  []ast.Statement
+		expressions []ast.Expression
+		identifiers []*ast.Identifier
+		pairs       []ast.HashPair
+	}
+)
+
+// node returns a new node from the slab s of the parser's arena, set to
+// value.
+func node[T any](p *Parser, s *slab[T], value T) *T {
+	if len(s.free) == 0 {
+		s.size = min(max(2*s.size, 8), p.arena.maxSize)
+		s.free = make([]T, s.size)
+	}
+
+	n := &s.free[0]
+	s.free = s.free[1:]
+	*n = value
+
+	return n
+}
+
+// list returns a copy of items from the slab s of the parser's arena.
+func list[T any](p *Parser, s *slab[T], items []T) []T {
+	if len(items) == 0 {
+		return []T{}
+	}
+
+	if len(s.free) < len(items) {
+		s.size = min(max(2*s.size, 8), p.arena.maxSize)
+		s.free = make([]T, max(s.size, len(items)))
+	}
+
+	l := s.free[:len(items):len(items)]
+	s.free = s.free[len(items):]
+	copy(l, items)
+
+	return l
+}
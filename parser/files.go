@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"context"
+	"sync"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/cst"
+	"github.com/marcel/monkey/lexer"
+)
+
+type (
+	// A File is a source to parse, named for reporting.
+	File struct {
+		Name   string
+		Source string
+	}
+
+	// A Result is the program parsed from a File, and the errors found in
+	// it. SyntaxTree is set WithSyntaxTree.
+	Result struct {
+		Name       string
+		Program    *ast.Program
+		Errors     []*Error
+		SyntaxTree *cst.Node
+	}
+)
+
+// ParseFiles parses files on a pool of workers, as many as WithWorkers
+// sets, each with a parser of its own, and returns the results in the
+// order of files. The parsers of a worker share their arena, so that
+// slabs are not left partly empty at the end of every source. When ctx is
+// done before all files are parsed, it returns the error of ctx.
+func ParseFiles(ctx context.Context, files []File, opts ...Option) ([]Result, error) {
+	results := make([]Result, len(files))
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for range min(newConfig(opts).workers, len(files)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var last *Parser
+			for i := range indices {
+				p := New(lexer.New(files[i].Source), opts...)
+				if last != nil {
+					p.arena, p.scratch = last.arena, last.scratch
+				}
+
+				program := p.ParseProgram()
+				results[i] = Result{Name: files[i].Name, Program: program, Errors: p.ErrorList(), SyntaxTree: p.SyntaxTree()}
+				last = p
+			}
+		}()
+	}
+
+	err := feed(ctx, indices, len(files))
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// feed sends the indices of n files to the workers until ctx is done, and
+// closes indices.
+func feed(ctx context.Context, indices chan<- int, n int) error {
+	defer close(indices)
+
+	for i := range n {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
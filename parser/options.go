@@ -0,0 +1,46 @@
+package parser
+
+import "runtime"
+
+type (
+	Option func(*config)
+
+	config struct {
+		arena      bool
+		syntaxTree bool
+		workers    int
+	}
+)
+
+// WithArena makes the parser allocate the nodes of the AST from an arena:
+// in slabs of many nodes at once rather than one by one, which takes the
+// garbage collector far less work. A slab stays in memory for as long as
+// any node in it is referenced, so it pays off for ASTs that are kept or
+// dropped as a whole.
+func WithArena() Option {
+	return func(c *config) { c.arena = true }
+}
+
+// WithSyntaxTree makes the parser build the concrete syntax tree of the
+// source alongside its AST, for SyntaxTree to return.
+func WithSyntaxTree() Option {
+	return func(c *config) { c.syntaxTree = true }
+}
+
+// WithWorkers sets the number of sources ParseFiles parses at a time. It
+// defaults to GOMAXPROCS.
+func WithWorkers(n int) Option {
+	return func(c *config) { c.workers = n }
+}
+
+func newConfig(opts []Option) config {
+	c := config{workers: runtime.GOMAXPROCS(0)}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	c.workers = max(c.workers, 1)
+
+	return c
+}
@@ -3,8 +3,10 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/cst"
 	"github.com/marcel/monkey/lexer"
 	"github.com/marcel/monkey/token"
 )
@@ -18,8 +20,15 @@ const (
 	PRODUCT     // *
 	PREFIX      // -X or !X
 	CALL        // myFunc(X)
+	INDEX       // array[index]
 )
 
+// MaxDepth is the deepest expressions can nest: parenthesized, as operands
+// or in the blocks of if expressions and functions. Deeper programs are
+// rejected with an error, so that neither parsing nor the engines run out
+// of stack on them.
+const MaxDepth = 10000
+
 type (
 	Precedence int
 
@@ -27,18 +36,42 @@ type (
 		l                  *lexer.Lexer
 		curToken           token.Token
 		peekToken          token.Token
-		errors             []string
-		prefixParsingFuncs map[token.Type]prefixParsingFunc
-		infixParsingFuncs  map[token.Type]infixParsingFunc
+		errors             []*Error
+		depth              int
+		arena              arena
+		scratch            scratch
+		tree               *cst.Builder
+		syntaxTree         *cst.Node
+		curSource          source
+		peekSource         source
+		prefixParsingFuncs [token.NumTypes]prefixParsingFunc
+		infixParsingFuncs  [token.NumTypes]infixParsingFunc
+	}
+
+	// An Error is a syntax error. Lexical errors are those caused by an
+	// ILLEGAL token, such as an unterminated string.
+	Error struct {
+		Pos     token.Position
+		Message string
+		Lexical bool
 	}
 
 	prefixParsingFunc func() ast.Expression
 
+	// bailout is panicked with to abandon parsing.
+	bailout struct{}
+
+	// source is the source of a token, for the syntax tree.
+	source struct {
+		trivia string
+		text   string
+	}
+
 	infixParsingFunc func(ast.Expression) ast.Expression
 )
 
 var (
-	precedences = map[token.Type]Precedence{
+	precedences = [token.NumTypes]Precedence{
 		token.EQ:       EQUALS,
 		token.NOT_EQ:   EQUALS,
 		token.LT:       LESSGREATER,
@@ -48,19 +81,57 @@ var (
 		token.SLASH:    PRODUCT,
 		token.ASTERISK: PRODUCT,
 		token.LPAREN:   CALL,
+		token.LBRACKET: INDEX,
+	}
+
+	// prefixKinds and infixKinds are the kinds of the nodes of the syntax
+	// tree for the expressions parsed by the prefix and infix parsing
+	// functions of a token.
+	prefixKinds = [token.NumTypes]cst.Kind{
+		token.IDENT:    cst.Identifier,
+		token.INT:      cst.IntegerLiteral,
+		token.STRING:   cst.StringLiteral,
+		token.TRUE:     cst.Boolean,
+		token.FALSE:    cst.Boolean,
+		token.BANG:     cst.PrefixExpression,
+		token.MINUS:    cst.PrefixExpression,
+		token.LPAREN:   cst.GroupedExpression,
+		token.IF:       cst.IfExpression,
+		token.FUNCTION: cst.FunctionLiteral,
+		token.LBRACKET: cst.ArrayLiteral,
+		token.LBRACE:   cst.HashLiteral,
+	}
+	infixKinds = [token.NumTypes]cst.Kind{
+		token.PLUS:     cst.InfixExpression,
+		token.MINUS:    cst.InfixExpression,
+		token.SLASH:    cst.InfixExpression,
+		token.ASTERISK: cst.InfixExpression,
+		token.EQ:       cst.InfixExpression,
+		token.NOT_EQ:   cst.InfixExpression,
+		token.LT:       cst.InfixExpression,
+		token.GT:       cst.InfixExpression,
+		token.LPAREN:   cst.CallExpression,
+		token.LBRACKET: cst.IndexExpression,
 	}
 )
 
-func New(l *lexer.Lexer) *Parser {
+func New(l *lexer.Lexer, opts ...Option) *Parser {
 	p := &Parser{
-		l:                  l,
-		errors:             []string{},
-		prefixParsingFuncs: make(map[token.Type]prefixParsingFunc),
-		infixParsingFuncs:  make(map[token.Type]infixParsingFunc),
+		l:      l,
+		errors: []*Error{},
+		arena:  arena{maxSize: 1},
+	}
+
+	c := newConfig(opts)
+	if c.arena {
+		p.arena.maxSize = maxSlabSize
 	}
 
 	p.registerPrefix(p.parseIdentifier, token.IDENT)
 	p.registerPrefix(p.parseIntegerLiteral, token.INT)
+	p.registerPrefix(p.parseStringLiteral, token.STRING)
+	p.registerPrefix(p.parseArrayLiteral, token.LBRACKET)
+	p.registerPrefix(p.parseHashLiteral, token.LBRACE)
 	p.registerPrefix(p.parseGroupedExpression, token.LPAREN)
 	p.registerPrefix(p.parseIfExpression, token.IF)
 	p.registerPrefix(p.parseFunctionLiteral, token.FUNCTION)
@@ -76,6 +147,7 @@ func New(l *lexer.Lexer) *Parser {
 	)
 
 	p.registerInfix(p.parseCallExpression, token.LPAREN)
+	p.registerInfix(p.parseIndexExpression, token.LBRACKET)
 	p.registerInfix(
 		p.parseInfixExpression,
 		token.PLUS,
@@ -91,17 +163,51 @@ func New(l *lexer.Lexer) *Parser {
 	p.nextToken()
 	p.nextToken()
 
+	if c.syntaxTree {
+		p.tree = &cst.Builder{}
+	}
+
 	return p
 }
 
 func (p *Parser) Errors() []string {
+	messages := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		messages[i] = err.Message
+	}
+
+	return messages
+}
+
+// ErrorList returns the errors reported by Errors along with their
+// positions.
+func (p *Parser) ErrorList() []*Error {
 	return p.errors
 }
 
-func (p *Parser) ParseProgram() *ast.Program {
-	program := &ast.Program{}
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+func (p *Parser) ParseProgram() (program *ast.Program) {
+	program = &ast.Program{}
 	program.Statements = []ast.Statement{}
 
+	if p.tree != nil {
+		p.tree.StartNode(cst.Program)
+		p.tree.Token(p.curToken.Type, p.curSource.trivia, p.curSource.text)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+		}
+
+		p.finishSyntaxTree()
+	}()
+
 	for p.curToken.Type != token.EOF {
 		stmt := p.parseStatement()
 
@@ -128,23 +234,73 @@ func (p *Parser) registerInfix(fn infixParsingFunc, types ...token.Type) {
 }
 
 func (p *Parser) nextToken() {
-	p.curToken = p.peekToken
+	p.curToken, p.curSource = p.peekToken, p.peekSource
 	p.peekToken = p.l.NextToken()
+	p.peekSource.trivia, p.peekSource.text = p.l.Source()
+
+	if p.tree != nil {
+		p.tree.Token(p.curToken.Type, p.curSource.trivia, p.curSource.text)
+	}
+}
+
+// SyntaxTree returns the syntax tree of the program ParseProgram parsed,
+// if the parser was made WithSyntaxTree, and nil otherwise.
+func (p *Parser) SyntaxTree() *cst.Node {
+	return p.syntaxTree
+}
+
+// mark returns the checkpoint of the syntax tree before the current token.
+func (p *Parser) mark() int {
+	if p.tree == nil {
+		return 0
+	}
+
+	return p.tree.Checkpoint() - 1
+}
+
+// syntax starts a node of kind of the syntax tree at mark, and returns the
+// function finishing it.
+func (p *Parser) syntax(mark int, kind cst.Kind) func() {
+	if p.tree == nil {
+		return func() {}
+	}
+
+	p.tree.StartNodeAt(mark, kind)
+
+	return p.tree.FinishNode
+}
+
+// finishSyntaxTree adds the tokens left after an error that abandoned
+// parsing to the syntax tree, so that it holds the whole source, and
+// finishes it.
+func (p *Parser) finishSyntaxTree() {
+	if p.tree == nil {
+		return
+	}
+
+	for !p.curTokenIs(token.EOF) {
+		p.nextToken()
+	}
+
+	p.syntaxTree = cst.NewRoot(p.tree.Finish())
 }
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
+		defer p.syntax(p.mark(), cst.LetStatement)()
 		return p.parseLetStatement()
 	case token.RETURN:
+		defer p.syntax(p.mark(), cst.ReturnStatement)()
 		return p.parseReturnStatement()
 	default:
+		defer p.syntax(p.mark(), cst.ExpressionStatement)()
 		return p.parseExpressionStatement()
 	}
 }
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt := node(p, &p.arena.expressions, ast.ExpressionStatement{Token: p.curToken})
 	stmt.Expression = p.parseExpression(LOWEST)
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -154,13 +310,19 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseExpression(precedence Precedence) ast.Expression {
+	defer func(depth int) { p.depth = depth }(p.depth)
+	p.nest()
+
 	prefix := p.prefixParsingFuncs[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParsingFuncError(p.curToken.Type)
 		return nil
 	}
 
+	mark := p.mark()
+	finish := p.syntax(mark, prefixKinds[p.curToken.Type])
 	leftExp := prefix()
+	finish()
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecendence() {
 		infix := p.infixParsingFuncs[p.peekToken.Type]
@@ -170,17 +332,29 @@ func (p *Parser) parseExpression(precedence Precedence) ast.Expression {
 
 		p.nextToken()
 
+		// The expression so far becomes an operand of the next.
+		p.nest()
+		finish = p.syntax(mark, infixKinds[p.curToken.Type])
 		leftExp = infix(leftExp)
+		finish()
 	}
 
 	return leftExp
 }
 
+// nest enters a level of nesting, and abandons parsing beyond MaxDepth.
+func (p *Parser) nest() {
+	if p.depth++; p.depth > MaxDepth {
+		p.errorf(p.curToken, "expression nested more than %d levels deep", MaxDepth)
+		panic(bailout{})
+	}
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
-	expression := &ast.PrefixExpression{
+	expression := node(p, &p.arena.prefixes, ast.PrefixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
-	}
+	})
 
 	p.nextToken()
 	expression.Right = p.parseExpression(PREFIX)
@@ -189,11 +363,11 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-	expression := &ast.InfixExpression{
+	expression := node(p, &p.arena.infixes, ast.InfixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
 		Left:     left,
-	}
+	})
 
 	precedence := p.curPrecendence()
 	p.nextToken()
@@ -203,34 +377,49 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
-	exp := &ast.CallExpression{Token: p.curToken, Function: function}
-	exp.Arguments = p.parseCallArguments()
+	exp := node(p, &p.arena.calls, ast.CallExpression{Token: p.curToken, Function: function})
+	exp.Arguments = p.parseExpressionList(token.RPAREN)
 
 	return exp
 }
 
-func (p *Parser) parseCallArguments() []ast.Expression {
-	args := []ast.Expression{}
-	if p.peekTokenIs(token.RPAREN) {
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	exp := node(p, &p.arena.indexes, ast.IndexExpression{Token: p.curToken, Left: left})
+
+	p.nextToken()
+	exp.Index = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return exp
+}
+
+func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
+	if p.peekTokenIs(end) {
 		p.nextToken()
-		return args
+		return []ast.Expression{}
 	}
 
+	start := len(p.scratch.expressions)
+	defer func() { p.scratch.expressions = p.scratch.expressions[:start] }()
+
 	p.nextToken()
 
-	args = append(args, p.parseExpression(LOWEST))
+	p.scratch.expressions = append(p.scratch.expressions, p.parseExpression(LOWEST))
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		p.scratch.expressions = append(p.scratch.expressions, p.parseExpression(LOWEST))
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(end) {
 		return nil
 	}
 
-	return args
+	return list(p, &p.arena.expressionLists, p.scratch.expressions[start:])
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
@@ -245,7 +434,7 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 }
 
 func (p *Parser) parseIfExpression() ast.Expression {
-	expression := &ast.IfExpression{Token: p.curToken}
+	expression := node(p, &p.arena.ifs, ast.IfExpression{Token: p.curToken})
 
 	if !p.expectPeek(token.LPAREN) {
 		return nil
@@ -279,30 +468,38 @@ func (p *Parser) parseIfExpression() ast.Expression {
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
-	block := &ast.BlockStatement{Token: p.curToken}
-	block.Statements = []ast.Statement{}
+	defer p.syntax(p.mark(), cst.BlockStatement)()
+
+	block := node(p, &p.arena.blocks, ast.BlockStatement{Token: p.curToken})
+
+	start := len(p.scratch.statements)
+	defer func() { p.scratch.statements = p.scratch.statements[:start] }()
 
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
 		if stmt := p.parseStatement(); stmt != nil {
-			block.Statements = append(block.Statements, stmt)
+			p.scratch.statements = append(p.scratch.statements, stmt)
 		}
 
 		p.nextToken()
 	}
 
+	block.Statements = list(p, &p.arena.statementLists, p.scratch.statements[start:])
+
 	return block
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
-	lit := &ast.FunctionLiteral{Token: p.curToken}
+	lit := node(p, &p.arena.functions, ast.FunctionLiteral{Token: p.curToken})
 
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	if lit.Parameters = p.parseFunctionParameters(); lit.Parameters == nil {
+		return nil
+	}
 
 	if !p.expectPeek(token.LBRACE) {
 		return nil
@@ -314,47 +511,96 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 }
 
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
-
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return []*ast.Identifier{}
 	}
 
-	p.nextToken()
+	start := len(p.scratch.identifiers)
+	defer func() { p.scratch.identifiers = p.scratch.identifiers[:start] }()
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+
+		p.syntax(p.mark(), cst.Identifier)()
+
+		ident := node(p, &p.arena.identifiers, ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		p.scratch.identifiers = append(p.scratch.identifiers, ident)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
-	return identifiers
+	return list(p, &p.arena.identifierLists, p.scratch.identifiers[start:])
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
-	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return node(p, &p.arena.identifiers, ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+}
+
+func (p *Parser) parseStringLiteral() ast.Expression {
+	return node(p, &p.arena.strings, ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+}
+
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := node(p, &p.arena.arrays, ast.ArrayLiteral{Token: p.curToken})
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+
+	return array
+}
+
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := node(p, &p.arena.hashes, ast.HashLiteral{Token: p.curToken})
+
+	start := len(p.scratch.pairs)
+	defer func() { p.scratch.pairs = p.scratch.pairs[:start] }()
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+
+		p.scratch.pairs = append(p.scratch.pairs, ast.HashPair{Key: key, Value: value})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	hash.Pairs = list(p, &p.arena.pairLists, p.scratch.pairs[start:])
+
+	return hash
 }
 
 func (p *Parser) parseBoolean() ast.Expression {
-	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
+	return node(p, &p.arena.booleans, ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)})
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
-	lit := &ast.IntegerLiteral{Token: p.curToken}
+	lit := node(p, &p.arena.integers, ast.IntegerLiteral{Token: p.curToken})
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 
@@ -363,7 +609,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
-	stmt := &ast.ReturnStatement{Token: p.curToken}
+	stmt := node(p, &p.arena.returns, ast.ReturnStatement{Token: p.curToken})
 	p.nextToken()
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
@@ -376,13 +622,14 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := node(p, &p.arena.lets, ast.LetStatement{Token: p.curToken})
 
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.syntax(p.mark(), cst.Identifier)()
+	stmt.Name = node(p, &p.arena.identifiers, ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -392,6 +639,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
+
 	for p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -408,19 +659,11 @@ func (p *Parser) peekTokenIs(t token.Type) bool {
 }
 
 func (p *Parser) peekPrecendence() Precedence {
-	if precedence, ok := precedences[p.peekToken.Type]; ok {
-		return precedence
-	}
-
-	return LOWEST
+	return max(precedences[p.peekToken.Type], LOWEST)
 }
 
 func (p *Parser) curPrecendence() Precedence {
-	if precedence, ok := precedences[p.curToken.Type]; ok {
-		return precedence
-	}
-
-	return LOWEST
+	return max(precedences[p.curToken.Type], LOWEST)
 }
 
 func (p *Parser) expectPeek(t token.Type) bool {
@@ -435,16 +678,32 @@ func (p *Parser) expectPeek(t token.Type) bool {
 }
 
 func (p *Parser) peekError(t token.Type) {
-	msg := fmt.Sprintf(
-		"expected next token to be %s, got %s instead",
-		t,
-		p.peekToken.Type,
-	)
+	if p.peekTokenIs(token.ILLEGAL) {
+		p.illegalTokenError(p.peekToken)
+		return
+	}
 
-	p.errors = append(p.errors, msg)
+	p.errorf(p.peekToken, "expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
 
 func (p *Parser) noPrefixParsingFuncError(t token.Type) {
-	msg := fmt.Sprintf("no prefix parsing function for %s found", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.illegalTokenError(p.curToken)
+		return
+	}
+
+	p.errorf(p.curToken, "no prefix parsing function for %s found", t)
+}
+
+func (p *Parser) illegalTokenError(tok token.Token) {
+	msg := fmt.Sprintf("illegal character %q", tok.Literal)
+	if strings.HasPrefix(tok.Literal, `"`) {
+		msg = "unterminated string literal"
+	}
+
+	p.errors = append(p.errors, &Error{Pos: tok.Position, Message: msg, Lexical: true})
+}
+
+func (p *Parser) errorf(tok token.Token, format string, args ...interface{}) {
+	p.errors = append(p.errors, &Error{Pos: tok.Position, Message: fmt.Sprintf(format, args...)})
 }
@@ -1,12 +1,17 @@
 package parser
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/cst"
 	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/token"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -119,6 +124,14 @@ func (s *ParserTestSuite) TestOperatorPrecedenceParsing() {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"a * [1, 2, 3, 4][b * c] * d",
+			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
+		},
+		{
+			"add(a * b[2], b[1], 2 * [1, 2][1])",
+			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
+		},
 	}
 
 	for _, e := range expectations {
@@ -245,6 +258,27 @@ func (s *ParserTestSuite) TestFunctionParameterParsing() {
 	}
 }
 
+func (s *ParserTestSuite) TestFunctionParameterErrors() {
+	expectations := []struct {
+		Input    string
+		Expected string
+	}{
+		{"fn(1) { 1 }", "1:4: expected next token to be IDENT, got INT instead"},
+		{`fn("x") { 1 }`, "1:4: expected next token to be IDENT, got STRING instead"},
+		{"fn(x, ) { x }", "1:7: expected next token to be IDENT, got ) instead"},
+		{"fn(x, [y]) { x }", "1:7: expected next token to be IDENT, got [ instead"},
+		{"fn(x y) { x }", "1:6: expected next token to be ), got IDENT instead"},
+	}
+
+	for _, e := range expectations {
+		p := New(lexer.New(e.Input))
+		p.ParseProgram()
+
+		s.Require().NotEmpty(p.ErrorList(), e.Input)
+		s.Equal(e.Expected, p.ErrorList()[0].Error(), e.Input)
+	}
+}
+
 func (s *ParserTestSuite) TestCallExpressionParsing() {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -440,6 +474,79 @@ func (s *ParserTestSuite) TestLetStatements() {
 	}
 }
 
+func (s *ParserTestSuite) TestStringLiteralExpression() {
+	input := `"hello world";`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	s.checkParserErrors(p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	s.True(ok)
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	s.True(ok)
+	s.Equal("hello world", literal.Value)
+}
+
+func (s *ParserTestSuite) TestArrayLiteralParsing() {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	s.checkParserErrors(p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	s.True(ok)
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	s.True(ok)
+
+	s.Len(array.Elements, 3)
+	s.testIntegerLiteral(array.Elements[0], 1)
+	s.testInfixExpression(array.Elements[1], 2, "*", 2)
+	s.testInfixExpression(array.Elements[2], 3, "+", 3)
+}
+
+func (s *ParserTestSuite) TestIndexExpressionParsing() {
+	input := "myArray[1 + 1]"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	s.checkParserErrors(p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	s.True(ok)
+	index, ok := stmt.Expression.(*ast.IndexExpression)
+	s.True(ok)
+
+	s.testIdentifier(index.Left, "myArray")
+	s.testInfixExpression(index.Index, 1, "+", 1)
+}
+
+func (s *ParserTestSuite) TestHashLiteralParsing() {
+	expectations := []struct {
+		Input    string
+		Expected string
+	}{
+		{`{}`, `{}`},
+		{`{"one": 1, "two": 2, "three": 3}`, `{one:1, two:2, three:3}`},
+		{`{true: 1, 2: "two"}`, `{true:1, 2:two}`},
+		{`{"one": 0 + 1, "two": 10 - 8}`, `{one:(0 + 1), two:(10 - 8)}`},
+	}
+
+	for _, e := range expectations {
+		p := New(lexer.New(e.Input))
+		program := p.ParseProgram()
+		s.checkParserErrors(p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		s.True(ok)
+		_, ok = stmt.Expression.(*ast.HashLiteral)
+		s.True(ok)
+
+		s.Equal(e.Expected, program.String())
+	}
+}
+
 func (s *ParserTestSuite) testIntegerLiteral(il ast.Expression, value int64) {
 	integ, ok := il.(*ast.IntegerLiteral)
 	s.True(ok)
@@ -517,3 +624,410 @@ func (s *ParserTestSuite) testInfixExpression(
 	s.Equal(operator, opExp.Operator)
 	s.testLiteralExpression(opExp.Right, right)
 }
+
+func (s *ParserTestSuite) TestFunctionLiteralWithName() {
+	input := `let myFunction = fn() { };`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	s.checkParserErrors(p)
+	s.Len(program.Statements, 1)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	s.True(ok)
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	s.True(ok)
+	s.Equal("myFunction", function.Name)
+}
+
+func (s *ParserTestSuite) TestErrorList() {
+	expectations := []struct {
+		Input    string
+		Expected []string
+		Lexical  []bool
+	}{
+		{"let = 5;", []string{"1:5: expected next token to be IDENT, got = instead", "1:5: no prefix parsing function for = found"}, []bool{false, false}},
+		{"let x = 5 @ 3;", []string{"1:11: illegal character \"@\""}, []bool{true}},
+		{"puts(\"abc", []string{"1:6: unterminated string literal", "1:10: expected next token to be ), got EOF instead"}, []bool{true, false}},
+	}
+
+	for _, e := range expectations {
+		p := New(lexer.New(e.Input))
+		p.ParseProgram()
+
+		errors := []string{}
+		lexical := []bool{}
+		for _, err := range p.ErrorList() {
+			errors = append(errors, err.Error())
+			lexical = append(lexical, err.Lexical)
+		}
+
+		s.Equal(e.Expected, errors, e.Input)
+		s.Equal(e.Lexical, lexical, e.Input)
+	}
+}
+
+func (s *ParserTestSuite) TestMaxDepth() {
+	expectations := []struct {
+		Input    string
+		Expected string
+	}{
+		{strings.Repeat("(", MaxDepth-1) + "1" + strings.Repeat(")", MaxDepth-1), ""},
+		{strings.Repeat("(", MaxDepth) + "1" + strings.Repeat(")", MaxDepth), "1:10001: expression nested more than 10000 levels deep"},
+		{strings.Repeat("-", MaxDepth+1) + "1", "1:10001: expression nested more than 10000 levels deep"},
+		{strings.Repeat("[", MaxDepth+1), "1:10001: expression nested more than 10000 levels deep"},
+		{strings.Repeat("if (true) { ", MaxDepth+1), "1:119993: expression nested more than 10000 levels deep"},
+		{"1" + strings.Repeat(" + 1", MaxDepth), "1:39997: expression nested more than 10000 levels deep"},
+		{"f" + strings.Repeat("()", MaxDepth), "1:20000: expression nested more than 10000 levels deep"},
+	}
+
+	for _, e := range expectations {
+		p := New(lexer.New(e.Input))
+		s.NotNil(p.ParseProgram())
+
+		if e.Expected == "" {
+			s.checkParserErrors(p)
+			continue
+		}
+
+		s.Require().Len(p.ErrorList(), 1)
+		s.Equal(e.Expected, p.ErrorList()[0].Error())
+	}
+}
+
+func FuzzParseProgram(f *testing.F) {
+	for _, seed := range []string{
+		"let x = 5; let y = x * (2 + 3);",
+		"let add = fn(a, b) { return a + b; }; add(1, 2);",
+		"if (x < 10) { puts(x) } else { -x }",
+		`let h = {"a": [1, 2][0], true: !false, 3: fn() {}}; h["a"]`,
+		`"unterminated`,
+		"let = 5; fn(1) {}; [1, 2; {1 2}",
+		"a[1](2)[3] == b != c > d",
+		"99999999999999999999",
+		"((((((((((1))))))))))",
+		"x @ y\x00z",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := New(lexer.New(input), WithSyntaxTree())
+		program := p.ParseProgram()
+
+		if program == nil {
+			t.Fatal("no program returned")
+		}
+
+		if text := p.SyntaxTree().Text(); text != input {
+			t.Fatalf("syntax tree of %q is %q", input, text)
+		}
+
+		for _, err := range p.ErrorList() {
+			if !err.Pos.IsValid() {
+				t.Fatalf("error without position: %s", err.Message)
+			}
+		}
+
+		if len(p.Errors()) == 0 {
+			if node := incomplete(program); node != nil {
+				t.Fatalf("no errors, but %T is incomplete", node)
+			}
+		}
+	})
+}
+
+// incomplete returns the first node of program missing a child, if any.
+func incomplete(program *ast.Program) ast.Node {
+	var missing ast.Node
+
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node == nil || missing != nil {
+			return false
+		}
+
+		v := reflect.ValueOf(node).Elem()
+		for i := range v.NumField() {
+			if v.Type().Field(i).Name == "Alternative" {
+				continue
+			}
+
+			if field := v.Field(i); hasNil(field) {
+				missing = node
+			}
+		}
+
+		return missing == nil
+	})
+
+	return missing
+}
+
+// hasNil reports whether v is nil or holds nil elements or fields.
+func hasNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	case reflect.Slice:
+		for i := range v.Len() {
+			if hasNil(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		if _, ok := v.Interface().(ast.HashPair); ok {
+			return hasNil(v.Field(0)) || hasNil(v.Field(1))
+		}
+	}
+
+	return false
+}
+
+func (s *ParserTestSuite) TestArena() {
+	source := generateSource(1000)
+
+	p := New(lexer.New(source))
+	program := p.ParseProgram()
+	s.checkParserErrors(p)
+
+	p = New(lexer.New(source), WithArena())
+	s.Equal(program, p.ParseProgram())
+	s.checkParserErrors(p)
+}
+
+func (s *ParserTestSuite) TestParseFiles() {
+	files := []File{
+		{Name: "a.mk", Source: "let a = 1;"},
+		{Name: "b.mk", Source: "let = 2;"},
+		{Name: "c.mk", Source: generateSource(200)},
+	}
+	for i := range 100 {
+		files = append(files, File{Name: fmt.Sprintf("%d.mk", i), Source: fmt.Sprintf("%d + %d", i, i)})
+	}
+
+	for _, opts := range [][]Option{nil, {WithArena()}, {WithWorkers(1)}, {WithWorkers(3), WithArena()}} {
+		results, err := ParseFiles(context.Background(), files, opts...)
+		s.Require().NoError(err)
+		s.Require().Len(results, len(files))
+
+		for i, result := range results {
+			p := New(lexer.New(files[i].Source))
+			s.Equal(files[i].Name, result.Name)
+			s.Equal(p.ParseProgram(), result.Program, result.Name)
+			s.Equal(p.ErrorList(), result.Errors, result.Name)
+		}
+
+		s.Len(results[1].Errors, 2)
+	}
+
+	results, err := ParseFiles(context.Background(), nil)
+	s.NoError(err)
+	s.Empty(results)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = ParseFiles(ctx, files)
+	s.ErrorIs(err, context.Canceled)
+}
+
+func (s *ParserTestSuite) TestSyntaxTree() {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", "(Program EOF)"},
+		{
+			"let x = 5;",
+			"(Program (LetStatement let (Identifier x) = (IntegerLiteral 5) ;) EOF)",
+		},
+		{
+			" return  a ;\n",
+			"(Program (ReturnStatement return (Identifier a) ;) EOF)",
+		},
+		{
+			"-a * (b + c)",
+			"(Program (ExpressionStatement (InfixExpression (PrefixExpression - (Identifier a)) * " +
+				"(GroupedExpression ( (InfixExpression (Identifier b) + (Identifier c)) )))) EOF)",
+		},
+		{
+			"a + b + c",
+			"(Program (ExpressionStatement (InfixExpression (InfixExpression (Identifier a) + " +
+				"(Identifier b)) + (Identifier c))) EOF)",
+		},
+		{
+			"f(1, g)[0]",
+			"(Program (ExpressionStatement (IndexExpression (CallExpression (Identifier f) ( " +
+				"(IntegerLiteral 1) , (Identifier g) )) [ (IntegerLiteral 0) ])) EOF)",
+		},
+		{
+			`if (true) { "a" } else { }`,
+			"(Program (ExpressionStatement (IfExpression if ( (Boolean true) ) (BlockStatement { " +
+				`(ExpressionStatement (StringLiteral "a")) }) else (BlockStatement { }))) EOF)`,
+		},
+		{
+			`fn(x, y) { [x, {"k": y}] }`,
+			"(Program (ExpressionStatement (FunctionLiteral fn ( (Identifier x) , (Identifier y) ) " +
+				"(BlockStatement { (ExpressionStatement (ArrayLiteral [ (Identifier x) , " +
+				`(HashLiteral { (StringLiteral "k") : (Identifier y) }) ])) }))) EOF)`,
+		},
+		{
+			"let = 5; x",
+			"(Program (LetStatement let) (ExpressionStatement =) (ExpressionStatement " +
+				"(IntegerLiteral 5) ;) (ExpressionStatement (Identifier x)) EOF)",
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input), WithSyntaxTree())
+		p.ParseProgram()
+
+		tree := p.SyntaxTree()
+		s.Equal(tt.input, tree.Text())
+		s.Equal(tt.expected, dump(tree), tt.input)
+	}
+
+	p := New(lexer.New("x"))
+	p.ParseProgram()
+	s.Nil(p.SyntaxTree())
+}
+
+func (s *ParserTestSuite) TestSyntaxTreeLossless() {
+	for _, input := range []string{
+		generateSource(100),
+		"let x = 5;;\r\n\t  x\n  \n",
+		`"unterminated`,
+		"let = 5; fn(1) {}; [1, 2; {1 2}",
+		"x @ y\x00z é",
+		strings.Repeat("(", MaxDepth) + "1" + strings.Repeat(")", MaxDepth) + " + 1;\nx ",
+	} {
+		p := New(lexer.New(input), WithSyntaxTree())
+		heap := New(lexer.New(input))
+
+		s.Equal(heap.ParseProgram(), p.ParseProgram())
+		s.Equal(input, p.SyntaxTree().Text())
+	}
+}
+
+func (s *ParserTestSuite) TestSyntaxTreeRewrite() {
+	input := "let total = price * (1 + rate);\n\nputs(total);\n"
+
+	p := New(lexer.New(input), WithSyntaxTree())
+	program := p.ParseProgram()
+	s.checkParserErrors(p)
+
+	// The node of an infix expression is the parent of its operator.
+	infix := program.Statements[0].(*ast.LetStatement).Value.(*ast.InfixExpression)
+	operator := p.SyntaxTree().TokenAt(infix.Token.Position.Offset)
+	s.Equal(cst.InfixExpression, operator.Parent().Kind())
+
+	node := operator.Parent().Children()[2]
+	s.Equal(cst.GroupedExpression, node.Kind())
+	s.Equal(" (1 + rate)", node.Text())
+
+	replaced := node.Replace(cst.NewNode(cst.Identifier, cst.NewToken(token.IDENT, node.Green().Trivia(), "factor")))
+	s.Equal("let total = price * factor;\n\nputs(total);\n", replaced.Root().Text())
+	s.Equal(node.Offset(), replaced.Offset())
+	s.Equal(input, p.SyntaxTree().Text())
+
+	// Nodes next to the one replaced are shared by both trees.
+	s.Same(p.SyntaxTree().Children()[1].Green(), replaced.Root().Children()[1].Green())
+}
+
+// dump returns a syntax tree with nodes in parentheses, tokens without
+// their trivia and EOF by its type.
+func dump(n *cst.Node) string {
+	if n.Kind() == cst.Token {
+		if n.Type() == token.EOF {
+			return "EOF"
+		}
+		return strings.TrimLeft(n.Text(), " \t\r\n")
+	}
+
+	parts := []string{n.Kind().String()}
+	for _, child := range n.Children() {
+		parts = append(parts, dump(child))
+	}
+
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func BenchmarkLargeSource(b *testing.B) {
+	source := generateSource(20000)
+
+	if p := New(lexer.New(source)); len(p.ParseProgram().Statements) != 20000 || len(p.Errors()) > 0 {
+		b.Fatal(p.Errors())
+	}
+
+	b.Run("lex", func(b *testing.B) {
+		b.SetBytes(int64(len(source)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l := lexer.New(source)
+			for l.NextToken().Type != token.EOF {
+			}
+		}
+	})
+
+	b.Run("parse", func(b *testing.B) {
+		b.SetBytes(int64(len(source)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			New(lexer.New(source)).ParseProgram()
+		}
+	})
+}
+
+// generateSource returns a program of n functions, each calling the one
+// before it, about 150 bytes apiece.
+func generateSource(n int) string {
+	var out strings.Builder
+
+	name := func(i int) string {
+		var s []byte
+		for ; ; i = i/26 - 1 {
+			s = append(s, byte('a'+i%26))
+			if i < 26 {
+				return "g" + string(s)
+			}
+		}
+	}
+
+	out.WriteString("let ga = fn(x, y) { x };\n")
+	for i := 1; i < n; i++ {
+		fmt.Fprintf(&out, `let %s = fn(count, total) {
+	let next = count * 2 + total / 3;
+	if (next > 10 == !false) { [next, "text", {"key": next}][0] } else { %s(next - 1, total) }
+};
+`, name(i), name(i-1))
+	}
+
+	return out.String()
+}
+
+func BenchmarkParseFiles(b *testing.B) {
+	files := make([]File, 1000)
+	for i := range files {
+		files[i] = File{Name: fmt.Sprintf("%d.mk", i), Source: generateSource(100)}
+	}
+
+	for _, bench := range []struct {
+		name string
+		opts []Option
+	}{
+		{"sequential", []Option{WithWorkers(1)}},
+		{"sequential-arena", []Option{WithWorkers(1), WithArena()}},
+		{"parallel", nil},
+		{"parallel-arena", []Option{WithArena()}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseFiles(context.Background(), files, bench.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
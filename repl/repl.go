@@ -5,17 +5,20 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/marcel/monkey/evaluator"
 	"github.com/marcel/monkey/lexer"
-	"github.com/marcel/monkey/token"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/parser"
 )
 
 const PROMPT = ">> "
 
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
+	e := evaluator.New()
 
 	for {
-		fmt.Print(PROMPT)
+		fmt.Fprint(out, PROMPT)
 
 		scanned := scanner.Scan()
 		if !scanned {
@@ -23,10 +26,26 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		line := scanner.Text()
-		lex := lexer.New(line)
+		p := parser.New(lexer.New(line))
 
-		for tok := lex.NextToken(); tok.Type != token.EOF; tok = lex.NextToken() {
-			fmt.Printf("%+v\n", tok)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			printParserErrors(out, p.Errors())
+			continue
 		}
+
+		switch evaluated := e.Eval(program, e.Globals).(type) {
+		case nil:
+		case *object.Error:
+			fmt.Fprintln(out, "ERROR: "+evaluated.StackTrace())
+		default:
+			fmt.Fprintln(out, evaluated.Inspect())
+		}
+	}
+}
+
+func printParserErrors(out io.Writer, errors []string) {
+	for _, msg := range errors {
+		fmt.Fprintf(out, "\t%s\n", msg)
 	}
 }
@@ -1,54 +1,102 @@
 package token
 
+import "fmt"
+
 const (
-	ILLEGAL Type = "ILLEGAL"
-	EOF     Type = "EOF"
+	ILLEGAL Type = iota
+	EOF
 
 	// Identifiers + literals
-	IDENT Type = "IDENT"
-	INT   Type = "INT"
+	IDENT
+	INT
+	STRING
 
 	// Operators
-	ASSIGN   Type = "="
-	PLUS     Type = "+"
-	MINUS    Type = "-"
-	BANG     Type = "!"
-	ASTERISK Type = "*"
-	SLASH    Type = "/"
-	LT       Type = "<"
-	GT       Type = ">"
-	EQ       Type = "=="
-	NOT_EQ   Type = "!="
+	ASSIGN
+	PLUS
+	MINUS
+	BANG
+	ASTERISK
+	SLASH
+	LT
+	GT
+	EQ
+	NOT_EQ
 
 	// Delimiters
-	COMMA     Type = ","
-	SEMICOLON Type = ";"
+	COMMA
+	SEMICOLON
+	COLON
 
-	LPAREN Type = "("
-	RPAREN Type = ")"
-	LBRACE Type = "{"
-	RBRACE Type = "}"
+	LPAREN
+	RPAREN
+	LBRACE
+	RBRACE
+	LBRACKET
+	RBRACKET
 
 	// Keywords
-	FUNCTION Type = "FUNCTION"
-	LET      Type = "LET"
-	TRUE     Type = "TRUE"
-	FALSE    Type = "FALSE"
-	IF       Type = "IF"
-	ELSE     Type = "ELSE"
-	RETURN   Type = "RETURN"
+	FUNCTION
+	LET
+	TRUE
+	FALSE
+	IF
+	ELSE
+	RETURN
+
+	// NumTypes is the number of token types, for tables indexed by them.
+	NumTypes
 )
 
 var (
-	SingleByteLiteralToType = map[byte]Type{
+	names = [NumTypes]string{
+		ILLEGAL:   "ILLEGAL",
+		EOF:       "EOF",
+		IDENT:     "IDENT",
+		INT:       "INT",
+		STRING:    "STRING",
+		ASSIGN:    "=",
+		PLUS:      "+",
+		MINUS:     "-",
+		BANG:      "!",
+		ASTERISK:  "*",
+		SLASH:     "/",
+		LT:        "<",
+		GT:        ">",
+		EQ:        "==",
+		NOT_EQ:    "!=",
+		COMMA:     ",",
+		SEMICOLON: ";",
+		COLON:     ":",
+		LPAREN:    "(",
+		RPAREN:    ")",
+		LBRACE:    "{",
+		RBRACE:    "}",
+		LBRACKET:  "[",
+		RBRACKET:  "]",
+		FUNCTION:  "FUNCTION",
+		LET:       "LET",
+		TRUE:      "TRUE",
+		FALSE:     "FALSE",
+		IF:        "IF",
+		ELSE:      "ELSE",
+		RETURN:    "RETURN",
+	}
+
+	// singleByteTypes holds the types of the bytes that are tokens by
+	// themselves, and ILLEGAL for all others.
+	singleByteTypes = [256]Type{
 		0:   EOF,
 		'+': PLUS,
 		',': COMMA,
 		';': SEMICOLON,
+		':': COLON,
 		'(': LPAREN,
 		')': RPAREN,
 		'{': LBRACE,
 		'}': RBRACE,
+		'[': LBRACKET,
+		']': RBRACKET,
 		'-': MINUS,
 		'*': ASTERISK,
 		'/': SLASH,
@@ -56,23 +104,44 @@ var (
 		'>': GT,
 	}
 
-	Keywords = map[string]Type{
-		"fn":     FUNCTION,
-		"let":    LET,
-		"true":   TRUE,
-		"false":  FALSE,
-		"if":     IF,
-		"else":   ELSE,
-		"return": RETURN,
-	}
+	keywords = [...]string{"fn", "let", "true", "false", "if", "else", "return"}
+
+	// SingleByteLiteralToType maps the bytes that are tokens by themselves
+	// to their types. The lexer does not consult it, see LookupByte.
+	SingleByteLiteralToType = func() map[byte]Type {
+		m := make(map[byte]Type)
+		for b, t := range singleByteTypes {
+			if t != ILLEGAL {
+				m[byte(b)] = t
+			}
+		}
+		return m
+	}()
+
+	// Keywords maps the keywords to their types. The lexer does not consult
+	// it, see LookupIdent.
+	Keywords = func() map[string]Type {
+		m := make(map[string]Type, len(keywords))
+		for _, keyword := range keywords {
+			m[keyword] = LookupIdent(keyword)
+		}
+		return m
+	}()
 )
 
 type (
-	Type string
+	Type uint8
 
 	Token struct {
-		Type    Type
-		Literal string
+		Type     Type
+		Literal  string
+		Position Position
+	}
+
+	Position struct {
+		Offset int
+		Line   int
+		Column int
 	}
 )
 
@@ -80,9 +149,52 @@ func (t Token) TokenLiteral() string {
 	return t.Literal
 }
 
+func (t Token) Pos() Position {
+	return t.Position
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+func (t Type) String() string {
+	if t < NumTypes {
+		return names[t]
+	}
+
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// LookupByte returns the type of the token the byte ch is by itself, or
+// ILLEGAL when it is not one.
+func LookupByte(ch byte) Type {
+	return singleByteTypes[ch]
+}
+
 func LookupIdent(ident string) Type {
-	if t, ok := Keywords[ident]; ok {
-		return t
+	switch ident {
+	case "fn":
+		return FUNCTION
+	case "let":
+		return LET
+	case "true":
+		return TRUE
+	case "false":
+		return FALSE
+	case "if":
+		return IF
+	case "else":
+		return ELSE
+	case "return":
+		return RETURN
 	}
 
 	return IDENT
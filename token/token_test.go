@@ -0,0 +1,68 @@
+package token
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type TokenTestSuite struct {
+	suite.Suite
+}
+
+func TestTokenTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenTestSuite))
+}
+
+func (s *TokenTestSuite) TestString() {
+	seen := map[string]bool{}
+	for t := range NumTypes {
+		name := t.String()
+		s.NotEmpty(name, "type %d", t)
+		s.False(seen[name], name)
+		seen[name] = true
+	}
+
+	s.Equal("IDENT", IDENT.String())
+	s.Equal("!=", NOT_EQ.String())
+	s.Equal("Type(200)", Type(200).String())
+}
+
+func (s *TokenTestSuite) TestLookupIdent() {
+	expectations := map[string]Type{
+		"fn":     FUNCTION,
+		"let":    LET,
+		"true":   TRUE,
+		"false":  FALSE,
+		"if":     IF,
+		"else":   ELSE,
+		"return": RETURN,
+		"fun":    IDENT,
+		"Let":    IDENT,
+		"x":      IDENT,
+	}
+
+	for ident, expected := range expectations {
+		s.Equal(expected, LookupIdent(ident), ident)
+	}
+
+	for ident, expected := range Keywords {
+		s.Equal(expected, LookupIdent(ident), ident)
+	}
+	s.Len(Keywords, 7)
+}
+
+func (s *TokenTestSuite) TestLookupByte() {
+	s.Equal(PLUS, LookupByte('+'))
+	s.Equal(EOF, LookupByte(0))
+	s.Equal(ILLEGAL, LookupByte('='), "= may start ==")
+	s.Equal(ILLEGAL, LookupByte('a'))
+
+	for b := range 256 {
+		t, ok := SingleByteLiteralToType[byte(b)]
+		s.Equal(LookupByte(byte(b)) != ILLEGAL, ok, "byte %d", b)
+		if ok {
+			s.Equal(LookupByte(byte(b)), t, "byte %d", b)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/token"
+)
+
+// A frame is a call in progress. ip is the offset of the next instruction
+// to execute and basePointer the index on the stack of its first local;
+// the closure called sits just below it.
+type frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+	// main is set for the frame of the main program, host for a call the
+	// host made rather than the program. elided counts the calls in tail
+	// position the frame has taken the place of.
+	main   bool
+	host   bool
+	elided int
+}
+
+func (f *frame) name() string {
+	switch {
+	case f.main:
+		return mainFrameName
+	case f.cl.Fn.Name == "":
+		return anonymousFrameName
+	}
+
+	return f.cl.Fn.Name
+}
+
+// pos returns the position of the instruction the frame executes, or made
+// the call into the next frame.
+func (f *frame) pos() token.Position {
+	return f.cl.Fn.Lines.Lookup(f.ip - 1)
+}
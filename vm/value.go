@@ -0,0 +1,150 @@
+package vm
+
+import (
+	"github.com/marcel/monkey/object"
+)
+
+const (
+	// unbound is the kind of the zero value, held by globals and locals
+	// that have not been bound yet.
+	unbound valueKind = iota
+	integerValue
+	booleanValue
+	nullValue
+	objectValue
+)
+
+// integerSize is the memory an integer result is accounted for, boxed or
+// not.
+var integerSize = object.SizeOf(&object.Integer{})
+
+type (
+	valueKind uint8
+
+	// A cell holds a local that closures capture, so that they see the
+	// values it is bound to after they were created. It is unbound until
+	// the let statement that binds the local runs. The stack holds cells,
+	// and closures their free variables, as objects, which never leave
+	// the VM.
+	cell struct {
+		value value
+	}
+
+	// A value is what the stack, and so the locals and operands of the VM,
+	// hold. Integers, booleans and null are held by the value itself, so
+	// that computing them allocates nothing; num is the integer, or 1 for
+	// true. Other objects are held in obj, which also keeps the
+	// *object.Integer an integer came from, if any, so that it is boxed
+	// again without allocating.
+	value struct {
+		kind valueKind
+		num  int64
+		obj  object.Object
+	}
+)
+
+var (
+	nullVal  = value{kind: nullValue}
+	trueVal  = value{kind: booleanValue, num: 1}
+	falseVal = value{kind: booleanValue}
+)
+
+func integer(i int64) value {
+	return value{kind: integerValue, num: i}
+}
+
+func boolean(b bool) value {
+	if b {
+		return trueVal
+	}
+
+	return falseVal
+}
+
+// valueOf returns the value holding obj. A nil obj is unbound.
+func valueOf(obj object.Object) value {
+	switch obj := obj.(type) {
+	case nil:
+		return value{}
+	case *object.Integer:
+		return value{kind: integerValue, num: obj.Value, obj: obj}
+	case *object.Boolean:
+		return boolean(obj.Value)
+	case *object.Null:
+		return nullVal
+	}
+
+	return value{kind: objectValue, obj: obj}
+}
+
+// object returns v as an object, boxing integers that are not boxed yet.
+func (v value) object() object.Object {
+	switch v.kind {
+	case unbound:
+		return nil
+	case integerValue:
+		if v.obj == nil {
+			return &object.Integer{Value: v.num}
+		}
+	case booleanValue:
+		return nativeBoolToBooleanObject(v.num != 0)
+	case nullValue:
+		return NULL
+	}
+
+	return v.obj
+}
+
+func (v value) Type() object.ObjectType {
+	switch v.kind {
+	case integerValue:
+		return object.INTEGER_OBJ
+	case booleanValue:
+		return object.BOOLEAN_OBJ
+	case nullValue, unbound:
+		return object.NULL_OBJ
+	}
+
+	return v.obj.Type()
+}
+
+// is reports whether v and other are the same value: equal integers or
+// booleans, both null or the same object.
+func (v value) is(other value) bool {
+	if v.kind != other.kind {
+		return false
+	}
+
+	switch v.kind {
+	case integerValue, booleanValue:
+		return v.num == other.num
+	case objectValue:
+		return v.obj == other.obj
+	}
+
+	return true
+}
+
+func (*cell) Type() object.ObjectType { return "CELL" }
+func (*cell) Inspect() string         { return "cell" }
+
+func (v value) isTruthy() bool {
+	switch v.kind {
+	case nullValue:
+		return false
+	case booleanValue:
+		return v.num != 0
+	}
+
+	return true
+}
+
+// objects boxes values.
+func objects(values []value) []object.Object {
+	objs := make([]object.Object, len(values))
+	for i, v := range values {
+		objs[i] = v.object()
+	}
+
+	return objs
+}
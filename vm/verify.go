@@ -0,0 +1,370 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/object"
+)
+
+const (
+	InvalidOpcode VerifyErrorKind = iota
+	TruncatedInstruction
+	OperandOutOfRange
+	InvalidJump
+	StackUnderflow
+	StackMismatch
+	MissingReturn
+	InvalidFunction
+)
+
+// maxLocals is the number of locals a function can address.
+const maxLocals = math.MaxUint8 + 1
+
+type (
+	VerifyErrorKind int
+
+	// A VerifyError is a problem Verify found in a program. Function is the
+	// index of the constant it was found in, or -1 for the main program,
+	// and Offset that of the instruction, or -1 for a problem of the
+	// function as a whole.
+	VerifyError struct {
+		Kind     VerifyErrorKind
+		Function int
+		Offset   int
+		Message  string
+	}
+
+	// A verifier checks the functions of a program and the closures they
+	// create. free holds the number of free variables each function refers
+	// to, by constant index.
+	verifier struct {
+		program  *compiler.Bytecode
+		free     map[int]int
+		closures []closureSite
+	}
+
+	// A closureSite is an OpClosure instruction, checked once the free
+	// variables of every function are known.
+	closureSite struct {
+		function int
+		offset   int
+		constant int
+		numFree  int
+	}
+
+	instruction struct {
+		offset   int
+		op       code.Opcode
+		operands []int
+		next     int
+	}
+)
+
+var verifyErrorKindNames = map[VerifyErrorKind]string{
+	InvalidOpcode:        "invalid opcode",
+	TruncatedInstruction: "truncated instruction",
+	OperandOutOfRange:    "operand out of range",
+	InvalidJump:          "invalid jump",
+	StackUnderflow:       "stack underflow",
+	StackMismatch:        "stack mismatch",
+	MissingReturn:        "missing return",
+	InvalidFunction:      "invalid function",
+}
+
+func (k VerifyErrorKind) String() string {
+	if name, ok := verifyErrorKindNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("VerifyErrorKind(%d)", int(k))
+}
+
+func (e *VerifyError) Error() string {
+	where := "main program"
+	if e.Function >= 0 {
+		where = fmt.Sprintf("function %d", e.Function)
+	}
+
+	if e.Offset >= 0 {
+		where = fmt.Sprintf("%s at %04d", where, e.Offset)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", where, e.Kind, e.Message)
+}
+
+// Verify checks that running program cannot make the VM fail other than
+// with a runtime error: that its instructions are defined and complete,
+// that their operands refer to constants, globals, builtins, locals and
+// free variables that exist, that jumps land on instructions and that
+// every path through a function keeps the stack balanced and ends in a
+// return. Programs from untrusted sources, such as .mkc files, must pass
+// it before they are run. The first problem found is returned as a
+// *VerifyError.
+func Verify(program *compiler.Bytecode) error {
+	v := &verifier{program: program, free: map[int]int{}}
+
+	main := &object.CompiledFunction{Instructions: program.Instructions}
+	if err := v.function(-1, main); err != nil {
+		return err
+	}
+
+	for i, constant := range program.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if err := v.function(i, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, site := range v.closures {
+		if need := v.free[site.constant]; site.numFree < need {
+			return &VerifyError{
+				Kind:     OperandOutOfRange,
+				Function: site.function,
+				Offset:   site.offset,
+				Message:  fmt.Sprintf("function %d refers to %d free variables, closure has %d", site.constant, need, site.numFree),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) function(index int, fn *object.CompiledFunction) *VerifyError {
+	errorf := func(kind VerifyErrorKind, offset int, format string, a ...interface{}) *VerifyError {
+		return &VerifyError{Kind: kind, Function: index, Offset: offset, Message: fmt.Sprintf(format, a...)}
+	}
+
+	if fn.NumLocals < 0 || fn.NumLocals > maxLocals {
+		return errorf(InvalidFunction, -1, "%d locals", fn.NumLocals)
+	}
+
+	if fn.NumParameters < 0 || fn.NumParameters > fn.NumLocals {
+		return errorf(InvalidFunction, -1, "%d parameters for %d locals", fn.NumParameters, fn.NumLocals)
+	}
+
+	instructions, err := decode(fn.Instructions)
+	if err != nil {
+		err.Function = index
+		return err
+	}
+
+	if len(instructions) == 0 {
+		return errorf(MissingReturn, -1, "no instructions")
+	}
+
+	// at maps the offset of every instruction to its index.
+	at := make(map[int]int, len(instructions))
+	for i, ins := range instructions {
+		at[ins.offset] = i
+	}
+
+	for _, ins := range instructions {
+		if err := v.operands(index, fn, ins, at); err != nil {
+			return err
+		}
+	}
+
+	// The depth of the stack above the locals when each instruction runs;
+	// -1 for instructions not reached yet.
+	depths := make([]int, len(instructions))
+	for i := range depths {
+		depths[i] = -1
+	}
+	depths[0] = 0
+
+	for work := []int{0}; len(work) > 0; {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		ins := instructions[i]
+		pops, pushes := stackEffect(ins)
+		if depths[i] < pops {
+			return errorf(StackUnderflow, ins.offset, "%s needs %d values, stack has %d", ins.op, pops, depths[i])
+		}
+
+		for k, next := range successors(ins) {
+			if next >= len(fn.Instructions) {
+				return errorf(MissingReturn, ins.offset, "execution continues past the end")
+			}
+
+			depth := depths[i] - pops + pushes
+			if k > 0 && pushesOnJump(ins.op) {
+				depth++
+			}
+
+			j := at[next]
+			switch depths[j] {
+			case -1:
+				depths[j] = depth
+				work = append(work, j)
+			case depth:
+			default:
+				return errorf(StackMismatch, next, "stack has %d values on one path and %d on another", depths[j], depth)
+			}
+		}
+	}
+
+	return nil
+}
+
+// decode splits ins into instructions.
+func decode(ins code.Instructions) ([]instruction, *VerifyError) {
+	var instructions []instruction
+
+	for ip := 0; ip < len(ins); {
+		def, err := code.Lookup(ins[ip])
+		if err != nil {
+			return nil, &VerifyError{Kind: InvalidOpcode, Offset: ip, Message: err.Error()}
+		}
+
+		width := 0
+		for _, w := range def.OperandWidths {
+			width += w
+		}
+
+		if ip+1+width > len(ins) {
+			return nil, &VerifyError{Kind: TruncatedInstruction, Offset: ip, Message: def.Name}
+		}
+
+		operands, _ := code.ReadOperands(def, ins[ip+1:])
+		instructions = append(instructions, instruction{
+			offset:   ip,
+			op:       code.Opcode(ins[ip]),
+			operands: operands,
+			next:     ip + 1 + width,
+		})
+
+		ip += 1 + width
+	}
+
+	return instructions, nil
+}
+
+// operands checks the operands of ins, which is part of the function with
+// the given constant index.
+func (v *verifier) operands(index int, fn *object.CompiledFunction, ins instruction, at map[int]int) *VerifyError {
+	outOfRange := func(what string, n int) *VerifyError {
+		return &VerifyError{
+			Kind:     OperandOutOfRange,
+			Function: index,
+			Offset:   ins.offset,
+			Message:  fmt.Sprintf("%s %d of %d", what, ins.operands[0], n),
+		}
+	}
+
+	switch ins.op {
+	case code.OpConstant:
+		if ins.operands[0] >= len(v.program.Constants) {
+			return outOfRange("constant", len(v.program.Constants))
+		}
+		if _, ok := v.program.Constants[ins.operands[0]].(*object.CompiledFunction); ok {
+			return &VerifyError{Kind: OperandOutOfRange, Function: index, Offset: ins.offset, Message: "constant is a function"}
+		}
+	case code.OpClosure:
+		if ins.operands[0] >= len(v.program.Constants) {
+			return outOfRange("constant", len(v.program.Constants))
+		}
+		if _, ok := v.program.Constants[ins.operands[0]].(*object.CompiledFunction); !ok {
+			return &VerifyError{Kind: OperandOutOfRange, Function: index, Offset: ins.offset, Message: "constant is not a function"}
+		}
+		v.closures = append(v.closures, closureSite{index, ins.offset, ins.operands[0], ins.operands[1]})
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpGetGlobalIfBound:
+		if ins.operands[0] >= len(v.program.Globals) {
+			return outOfRange("global", len(v.program.Globals))
+		}
+	case code.OpGetBuiltin:
+		if ins.operands[0] >= len(v.program.Builtins) {
+			return outOfRange("builtin", len(v.program.Builtins))
+		}
+	case code.OpGetLocal, code.OpSetLocal, code.OpCell, code.OpGetCell, code.OpSetCell:
+		if ins.operands[0] >= fn.NumLocals {
+			return outOfRange("local", fn.NumLocals)
+		}
+	case code.OpGetFree, code.OpGetFreeCell, code.OpGetFreeIfBound:
+		if index < 0 {
+			return outOfRange("free variable", 0)
+		}
+		if n := ins.operands[0] + 1; n > v.free[index] {
+			v.free[index] = n
+		}
+	case code.OpHash:
+		if ins.operands[0]%2 != 0 {
+			return &VerifyError{Kind: OperandOutOfRange, Function: index, Offset: ins.offset, Message: "odd number of hash elements"}
+		}
+	}
+
+	if target, ok := jumpTarget(ins); ok {
+		if _, ok := at[target]; !ok {
+			return &VerifyError{
+				Kind:     InvalidJump,
+				Function: index,
+				Offset:   ins.offset,
+				Message:  fmt.Sprintf("target %04d is not an instruction", target),
+			}
+		}
+	}
+
+	return nil
+}
+
+// jumpTarget returns the offset ins may jump to, its last operand, if it
+// is a jump.
+func jumpTarget(ins instruction) (int, bool) {
+	switch ins.op {
+	case code.OpJump, code.OpJumpNotTruthy, code.OpGetFreeIfBound, code.OpGetGlobalIfBound:
+		return ins.operands[len(ins.operands)-1], true
+	}
+
+	return 0, false
+}
+
+// pushesOnJump reports whether op pushes a value only when it jumps.
+func pushesOnJump(op code.Opcode) bool {
+	return op == code.OpGetFreeIfBound || op == code.OpGetGlobalIfBound
+}
+
+// stackEffect returns the number of values ins pops off the stack and the
+// number it pushes when it does not jump.
+func stackEffect(ins instruction) (int, int) {
+	switch ins.op {
+	case code.OpPop, code.OpSetGlobal, code.OpSetLocal, code.OpSetCell, code.OpJumpNotTruthy, code.OpReturnValue:
+		return 1, 0
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan, code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang:
+		return 1, 1
+	case code.OpArray, code.OpHash:
+		return ins.operands[0], 1
+	case code.OpCall, code.OpTailCall:
+		return ins.operands[0] + 1, 1
+	case code.OpClosure:
+		return ins.operands[1], 1
+	case code.OpJump, code.OpReturn, code.OpCell, code.OpGetFreeIfBound, code.OpGetGlobalIfBound:
+		return 0, 0
+	}
+
+	return 0, 1
+}
+
+// successors returns the offsets of the instructions that may run after
+// ins, the next one first. A tail call of a builtin continues with the
+// next instruction.
+func successors(ins instruction) []int {
+	switch ins.op {
+	case code.OpReturnValue, code.OpReturn:
+		return nil
+	case code.OpJump:
+		return []int{ins.operands[0]}
+	}
+
+	if target, ok := jumpTarget(ins); ok {
+		return []int{ins.next, target}
+	}
+
+	return []int{ins.next}
+}
@@ -0,0 +1,826 @@
+// Package vm runs the bytecode the compiler package produces, with the
+// semantics of the tree-walking evaluator.
+package vm
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/gas"
+	"github.com/marcel/monkey/object"
+)
+
+const (
+	mainFrameName      = "<main>"
+	anonymousFrameName = "<anonymous>"
+
+	// StackSize is the number of values the stack starts out with room
+	// for. It grows as needed.
+	StackSize = 2048
+
+	// DefaultMaxDepth is the call depth New limits VMs to, the same as
+	// that of the evaluator.
+	DefaultMaxDepth = 10000
+
+	// contextCheckInterval is the number of instructions between two
+	// checks of whether the context of a run is done.
+	contextCheckInterval = 1024
+)
+
+var (
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+)
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+// A VM runs a compiled program. Globals holds the values of the globals of
+// the program, by the indices the compiler assigned; a slot that is nil has
+// not been bound yet. Builtins is where the builtins the program refers to
+// are looked up by name. A VM must not be used by more than one goroutine
+// at a time.
+//
+// MaxSteps limits the number of instructions a single run, or call from
+// the host, may execute and MaxDepth the depth of nested function calls;
+// zero means no limit. Alloc accounts for the objects the program
+// allocates. Gas, when set, meters the cost of each run or call from the
+// host, starting from zero every time; operations cost the same as they do
+// in the evaluator.
+type VM struct {
+	Builtins *object.Builtins
+	Globals  []object.Object
+	Alloc    *object.Allocator
+	Gas      *gas.Meter
+	MaxSteps int64
+	MaxDepth int
+
+	main         *object.Closure
+	constants    []value
+	globalNames  []string
+	builtinNames []string
+	builtins     []object.Object
+
+	stack  []value
+	sp     int
+	frames []frame
+
+	ctx     context.Context
+	steps   int64
+	running bool
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobals(bytecode, nil)
+}
+
+// NewWithGlobals creates a VM that runs bytecode with the globals of an
+// earlier program compiled with the same symbol table.
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	alloc := object.NewAllocator(0)
+
+	if n := len(bytecode.Globals); len(globals) < n {
+		globals = append(globals, make([]object.Object, n-len(globals))...)
+	}
+
+	mainFn := &object.CompiledFunction{
+		Name:         mainFrameName,
+		Instructions: bytecode.Instructions,
+		Lines:        bytecode.Lines,
+	}
+
+	constants := make([]value, len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		constants[i] = valueOf(constant)
+	}
+
+	return &VM{
+		Builtins:     object.CoreBuiltins(os.Stdout, os.Stderr, alloc),
+		Globals:      globals,
+		Alloc:        alloc,
+		MaxDepth:     DefaultMaxDepth,
+		main:         &object.Closure{Fn: mainFn},
+		constants:    constants,
+		globalNames:  bytecode.Globals,
+		builtinNames: bytecode.Builtins,
+		stack:        make([]value, StackSize),
+		frames:       make([]frame, 0, 64),
+	}
+}
+
+// Run runs the program and returns its result: the value it returns, or
+// the *object.Error it failed with.
+func (vm *VM) Run() object.Object {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext runs the program like Run and ends the run with a Timeout
+// error once ctx is done.
+func (vm *VM) RunContext(ctx context.Context) object.Object {
+	defer vm.begin(ctx)()
+
+	vm.push(valueOf(vm.main))
+	vm.frames = append(vm.frames, frame{cl: vm.main, basePointer: vm.sp, main: true})
+
+	return vm.execute(0)
+}
+
+// Apply calls fn with args on behalf of the host, which is not a Monkey
+// frame, and returns its result.
+func (vm *VM) Apply(fn object.Object, args []object.Object) object.Object {
+	return vm.ApplyContext(context.Background(), fn, args)
+}
+
+// ApplyContext calls fn like Apply and ends the call with a Timeout error
+// once ctx is done.
+func (vm *VM) ApplyContext(ctx context.Context, fn object.Object, args []object.Object) object.Object {
+	defer vm.begin(ctx)()
+
+	sp, depth := vm.sp, len(vm.frames)
+
+	vm.push(valueOf(fn))
+	for _, arg := range args {
+		vm.push(valueOf(arg))
+	}
+
+	if err := vm.call(len(args), false); err != nil {
+		vm.sp = sp
+		return err
+	}
+
+	if len(vm.frames) == depth {
+		// fn was a builtin, its result is on the stack.
+		return vm.pop().object()
+	}
+
+	vm.frames[depth].host = true
+
+	return vm.execute(depth)
+}
+
+// begin starts a run requested by the host and returns the function that
+// ends it. Runs a builtin starts while another one is running count toward
+// the running one.
+func (vm *VM) begin(ctx context.Context) func() {
+	if vm.running {
+		return func() {}
+	}
+
+	vm.running, vm.ctx, vm.steps = true, ctx, 0
+	vm.Gas.Reset()
+	vm.Alloc.Begin()
+	vm.resolveBuiltins()
+
+	return func() { vm.running, vm.ctx = false, nil }
+}
+
+// resolveBuiltins looks up the builtins the program refers to. Those that
+// are missing fail when the program uses them.
+func (vm *VM) resolveBuiltins() {
+	vm.builtins = make([]object.Object, len(vm.builtinNames))
+
+	for i, name := range vm.builtinNames {
+		if builtin, ok := vm.Builtins.Lookup(name); ok {
+			vm.builtins[i] = builtin
+		}
+	}
+}
+
+// execute runs the frames above depth until the one at depth returns, and
+// returns its result. When it fails, those frames and their values are
+// dropped from the stack.
+func (vm *VM) execute(depth int) object.Object {
+	sp := vm.frames[depth].basePointer - 1
+
+	result, err := vm.loop(depth)
+	if err != nil {
+		vm.frames = vm.frames[:depth]
+		vm.sp = sp
+		return err
+	}
+
+	return result.object()
+}
+
+func (vm *VM) loop(depth int) (value, *object.Error) {
+	for {
+		if err := vm.step(); err != nil {
+			return value{}, err
+		}
+
+		f := &vm.frames[len(vm.frames)-1]
+		ins := f.cl.Fn.Instructions
+		ip := f.ip
+		op := code.Opcode(ins[ip])
+
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			f.ip = ip + 3
+			vm.push(vm.constants[code.ReadUint16(ins[ip+1:])])
+
+		case code.OpPop:
+			f.ip = ip + 1
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			f.ip = ip + 1
+			err = vm.executeBinaryOperation(op)
+
+		case code.OpTrue:
+			f.ip = ip + 1
+			vm.push(trueVal)
+
+		case code.OpFalse:
+			f.ip = ip + 1
+			vm.push(falseVal)
+
+		case code.OpNull:
+			f.ip = ip + 1
+			vm.push(nullVal)
+
+		case code.OpMinus:
+			f.ip = ip + 1
+			err = vm.executeMinusOperator()
+
+		case code.OpBang:
+			f.ip = ip + 1
+			if err = vm.charge(gas.Prefix, 1); err == nil {
+				vm.push(boolean(!vm.pop().isTruthy()))
+			}
+
+		case code.OpJumpNotTruthy:
+			f.ip = ip + 3
+			if !vm.pop().isTruthy() {
+				f.ip = int(code.ReadUint16(ins[ip+1:]))
+			}
+
+		case code.OpJump:
+			f.ip = int(code.ReadUint16(ins[ip+1:]))
+
+		case code.OpGetGlobal:
+			f.ip = ip + 3
+			index := code.ReadUint16(ins[ip+1:])
+			if global := vm.Globals[index]; global != nil {
+				vm.push(valueOf(global))
+			} else {
+				err = vm.newError(object.UnknownIdentifier, "identifier not found: %s", vm.globalNames[index])
+			}
+
+		case code.OpSetGlobal:
+			f.ip = ip + 3
+			vm.Globals[code.ReadUint16(ins[ip+1:])] = vm.pop().object()
+
+		case code.OpGetLocal:
+			f.ip = ip + 2
+			vm.push(vm.stack[f.basePointer+int(code.ReadUint8(ins[ip+1:]))])
+
+		case code.OpSetLocal:
+			f.ip = ip + 2
+			vm.stack[f.basePointer+int(code.ReadUint8(ins[ip+1:]))] = vm.pop()
+
+		case code.OpGetBuiltin:
+			f.ip = ip + 2
+			index := code.ReadUint8(ins[ip+1:])
+			if builtin := vm.builtins[index]; builtin != nil {
+				vm.push(value{kind: objectValue, obj: builtin})
+			} else {
+				err = vm.newError(object.UnknownIdentifier, "identifier not found: %s", vm.builtinNames[index])
+			}
+
+		case code.OpGetFree:
+			f.ip = ip + 2
+			var v value
+			if v, err = vm.cellValue(f.cl.Free[code.ReadUint8(ins[ip+1:])]); err == nil {
+				vm.push(v)
+			}
+
+		case code.OpGetFreeCell:
+			f.ip = ip + 2
+			vm.push(valueOf(f.cl.Free[code.ReadUint8(ins[ip+1:])]))
+
+		case code.OpCell:
+			f.ip = ip + 2
+			vm.stack[f.basePointer+int(code.ReadUint8(ins[ip+1:]))] = value{kind: objectValue, obj: &cell{}}
+
+		case code.OpGetCell:
+			f.ip = ip + 2
+			var v value
+			if v, err = vm.cellValue(vm.stack[f.basePointer+int(code.ReadUint8(ins[ip+1:]))].obj); err == nil {
+				vm.push(v)
+			}
+
+		case code.OpSetCell:
+			f.ip = ip + 2
+			if c, ok := vm.stack[f.basePointer+int(code.ReadUint8(ins[ip+1:]))].obj.(*cell); ok {
+				c.value = vm.pop()
+			} else {
+				err = vm.newError(object.UnknownError, "local %d is not a cell", code.ReadUint8(ins[ip+1:]))
+			}
+
+		case code.OpGetFreeIfBound:
+			f.ip = ip + 4
+			c, ok := f.cl.Free[code.ReadUint8(ins[ip+1:])].(*cell)
+			switch {
+			case !ok:
+				err = vm.newError(object.UnknownError, "free variable %d is not a cell", code.ReadUint8(ins[ip+1:]))
+			case c.value.kind != unbound:
+				vm.push(c.value)
+				f.ip = int(code.ReadUint16(ins[ip+2:]))
+			}
+
+		case code.OpGetGlobalIfBound:
+			f.ip = ip + 5
+			if global := vm.Globals[code.ReadUint16(ins[ip+1:])]; global != nil {
+				vm.push(valueOf(global))
+				f.ip = int(code.ReadUint16(ins[ip+3:]))
+			}
+
+		case code.OpArray:
+			f.ip = ip + 3
+			err = vm.buildArray(int(code.ReadUint16(ins[ip+1:])))
+
+		case code.OpHash:
+			f.ip = ip + 3
+			err = vm.buildHash(int(code.ReadUint16(ins[ip+1:])))
+
+		case code.OpIndex:
+			f.ip = ip + 1
+			err = vm.executeIndexExpression()
+
+		case code.OpCall:
+			f.ip = ip + 2
+			err = vm.call(int(code.ReadUint8(ins[ip+1:])), false)
+
+		case code.OpTailCall:
+			f.ip = ip + 2
+			err = vm.call(int(code.ReadUint8(ins[ip+1:])), true)
+
+		case code.OpReturnValue, code.OpReturn:
+			f.ip = ip + 1
+			result := nullVal
+			if op == code.OpReturnValue {
+				result = vm.pop()
+			}
+
+			vm.sp = f.basePointer - 1
+			vm.frames = vm.frames[:len(vm.frames)-1]
+
+			if len(vm.frames) == depth {
+				return result, nil
+			}
+			vm.push(result)
+
+		case code.OpClosure:
+			f.ip = ip + 4
+			err = vm.pushClosure(int(code.ReadUint16(ins[ip+1:])), int(code.ReadUint8(ins[ip+3:])))
+
+		default:
+			f.ip = ip + 1
+			err = vm.newError(object.UnknownError, "unknown opcode %d", op)
+		}
+
+		if err != nil {
+			return value{}, err
+		}
+	}
+}
+
+func (vm *VM) push(v value) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, make([]value, len(vm.stack))...)
+	}
+
+	vm.stack[vm.sp] = v
+	vm.sp++
+}
+
+func (vm *VM) pop() value {
+	vm.sp--
+
+	return vm.stack[vm.sp]
+}
+
+// call calls the function below the numArgs arguments on top of the stack.
+// A Monkey function gets a frame of its own, or, for a tail call, that of
+// the function making the call; builtins are called right away so that
+// their errors are reported in the frame that called them.
+func (vm *VM) call(numArgs int, tail bool) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	if builtin, ok := callee.obj.(*object.Builtin); ok {
+		if err := vm.charge(gas.Builtin, 1); err != nil {
+			return err
+		}
+		args := objects(vm.stack[vm.sp-numArgs : vm.sp])
+		if builtin.Elements != nil {
+			if err := vm.charge(gas.Element, builtin.Elements(args...)); err != nil {
+				return err
+			}
+		}
+		return vm.callBuiltin(builtin, args)
+	}
+
+	cl, ok := callee.obj.(*object.Closure)
+	if !ok {
+		return vm.newError(object.NotAFunction, "not a function: %s", callee.Type())
+	}
+
+	if err := vm.charge(gas.Call, 1); err != nil {
+		return err
+	}
+
+	if numArgs != cl.Fn.NumParameters {
+		return vm.newError(
+			object.ArityMismatch,
+			"wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters,
+			numArgs,
+		)
+	}
+
+	if tail {
+		// The arguments take the place of the frame's closure and locals.
+		f := &vm.frames[len(vm.frames)-1]
+		base := f.basePointer - 1
+		copy(vm.stack[base:], vm.stack[vm.sp-1-numArgs:vm.sp])
+
+		vm.sp = base + 1 + numArgs
+		f.cl, f.ip, f.basePointer = cl, 0, base+1
+		f.elided++
+	} else {
+		if vm.MaxDepth > 0 && vm.depth() >= vm.MaxDepth {
+			return vm.newError(object.StackOverflow, "stack overflow: maximum call depth of %d exceeded", vm.MaxDepth)
+		}
+
+		vm.frames = append(vm.frames, frame{cl: cl, basePointer: vm.sp - numArgs})
+	}
+
+	for locals := vm.frames[len(vm.frames)-1].basePointer + cl.Fn.NumLocals; vm.sp < locals; {
+		vm.push(value{})
+	}
+
+	return nil
+}
+
+// depth returns the number of calls of Monkey functions in progress.
+func (vm *VM) depth() int {
+	depth := len(vm.frames)
+	if depth > 0 && vm.frames[0].main {
+		depth--
+	}
+
+	return depth
+}
+
+// callBuiltin calls builtin and gives any error it returns the position of
+// the call, since builtins have no position of their own.
+func (vm *VM) callBuiltin(builtin *object.Builtin, args []object.Object) *object.Error {
+	result := builtin.Fn(args...)
+
+	if err, ok := result.(*object.Error); ok {
+		if err.Stack == nil {
+			located := vm.newError(err.Kind, "%s", err.Message)
+			err.Pos, err.Stack = located.Pos, located.Stack
+		}
+		return err
+	}
+
+	if result == nil {
+		result = NULL
+	}
+
+	vm.sp -= len(args) + 1
+	vm.push(valueOf(result))
+
+	return nil
+}
+
+func (vm *VM) pushClosure(constIndex, numFree int) *object.Error {
+	fn, ok := vm.constants[constIndex].obj.(*object.CompiledFunction)
+	if !ok {
+		return vm.newError(object.NotAFunction, "not a function: %s", vm.constants[constIndex].Type())
+	}
+
+	free := objects(vm.stack[vm.sp-numFree : vm.sp])
+	vm.sp -= numFree
+
+	cl := &object.Closure{Fn: fn, Free: free}
+	if err := vm.alloc(cl); err != nil {
+		return err
+	}
+
+	vm.push(value{kind: objectValue, obj: cl})
+
+	return nil
+}
+
+// cellValue returns the value of obj, a cell that is bound.
+func (vm *VM) cellValue(obj object.Object) (value, *object.Error) {
+	c, ok := obj.(*cell)
+	if !ok || c.value.kind == unbound {
+		return value{}, vm.newError(object.UnknownError, "variable is not a bound cell")
+	}
+
+	return c.value, nil
+}
+
+func (vm *VM) buildArray(numElements int) *object.Error {
+	if err := vm.charge(gas.Element, numElements); err != nil {
+		return err
+	}
+
+	elements := objects(vm.stack[vm.sp-numElements : vm.sp])
+	vm.sp -= numElements
+
+	array := &object.Array{Elements: elements}
+	if err := vm.alloc(array); err != nil {
+		return err
+	}
+
+	vm.push(value{kind: objectValue, obj: array})
+
+	return nil
+}
+
+func (vm *VM) buildHash(numElements int) *object.Error {
+	hash := object.NewHash(numElements / 2)
+
+	for i := vm.sp - numElements; i < vm.sp; i += 2 {
+		key, ok := vm.stack[i].object().(object.Hashable)
+		if !ok {
+			return vm.newError(object.TypeMismatch, "unusable as hash key: %s", vm.stack[i].Type())
+		}
+
+		hash.Set(key, vm.stack[i+1].object())
+	}
+	vm.sp -= numElements
+
+	if err := vm.charge(gas.Element, numElements/2); err != nil {
+		return err
+	}
+
+	if err := vm.alloc(hash); err != nil {
+		return err
+	}
+
+	vm.push(value{kind: objectValue, obj: hash})
+
+	return nil
+}
+
+func (vm *VM) executeIndexExpression() *object.Error {
+	index := vm.pop()
+	left := vm.pop()
+
+	if err := vm.charge(gas.Index, 1); err != nil {
+		return err
+	}
+
+	switch left := left.obj.(type) {
+	case *object.Array:
+		if index.kind != integerValue {
+			break
+		}
+
+		if i := index.num; i < 0 || i >= int64(len(left.Elements)) {
+			vm.push(nullVal)
+		} else {
+			vm.push(valueOf(left.Elements[i]))
+		}
+
+		return nil
+	case *object.Hash:
+		key, ok := index.object().(object.Hashable)
+		if !ok {
+			return vm.newError(object.TypeMismatch, "unusable as hash key: %s", index.Type())
+		}
+
+		if element, ok := left.Get(key); ok {
+			vm.push(valueOf(element))
+		} else {
+			vm.push(nullVal)
+		}
+
+		return nil
+	}
+
+	return vm.newError(
+		object.TypeMismatch,
+		"index operator not supported: %s[%s]",
+		left.Type(),
+		index.Type(),
+	)
+}
+
+func (vm *VM) executeMinusOperator() *object.Error {
+	right := vm.pop()
+
+	if err := vm.charge(gas.Prefix, 1); err != nil {
+		return err
+	}
+
+	if right.kind == integerValue {
+		return vm.pushInteger(-right.num)
+	}
+
+	return vm.newError(object.UnknownOperator, "unknown operator: -%s", right.Type())
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) *object.Error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if err := vm.charge(gas.Infix, 1); err != nil {
+		return err
+	}
+
+	operator := infixOperators[op]
+
+	if left.kind == integerValue && right.kind == integerValue {
+		return vm.executeIntegerOperation(op, left.num, right.num)
+	}
+
+	leftStr, leftIsString := left.obj.(*object.String)
+	rightStr, rightIsString := right.obj.(*object.String)
+
+	switch {
+	case leftIsString && rightIsString:
+		return vm.executeStringOperation(op, leftStr.Value, rightStr.Value)
+	case op == code.OpEqual:
+		vm.push(boolean(left.is(right)))
+		return nil
+	case op == code.OpNotEqual:
+		vm.push(boolean(!left.is(right)))
+		return nil
+	case left.Type() != right.Type():
+		return vm.newError(
+			object.TypeMismatch,
+			"type mismatch: %s %s %s",
+			left.Type(),
+			operator,
+			right.Type(),
+		)
+	}
+
+	return vm.newError(
+		object.UnknownOperator,
+		"unknown operator: %s %s %s",
+		left.Type(),
+		operator,
+		right.Type(),
+	)
+}
+
+func (vm *VM) executeIntegerOperation(op code.Opcode, left, right int64) *object.Error {
+	switch op {
+	case code.OpAdd:
+		return vm.pushInteger(left + right)
+	case code.OpSub:
+		return vm.pushInteger(left - right)
+	case code.OpMul:
+		return vm.pushInteger(left * right)
+	case code.OpDiv:
+		if right == 0 {
+			return vm.newError(object.DivisionByZero, "division by zero")
+		}
+		return vm.pushInteger(left / right)
+	case code.OpLessThan:
+		vm.push(boolean(left < right))
+	case code.OpGreaterThan:
+		vm.push(boolean(left > right))
+	case code.OpEqual:
+		vm.push(boolean(left == right))
+	case code.OpNotEqual:
+		vm.push(boolean(left != right))
+	}
+
+	return nil
+}
+
+func (vm *VM) executeStringOperation(op code.Opcode, left, right string) *object.Error {
+	switch op {
+	case code.OpAdd:
+		if err := vm.charge(gas.Byte, len(left)+len(right)); err != nil {
+			return err
+		}
+		s := &object.String{Value: left + right}
+		if err := vm.alloc(s); err != nil {
+			return err
+		}
+		vm.push(value{kind: objectValue, obj: s})
+	case code.OpEqual:
+		vm.push(boolean(left == right))
+	case code.OpNotEqual:
+		vm.push(boolean(left != right))
+	default:
+		return vm.newError(
+			object.UnknownOperator,
+			"unknown operator: %s %s %s",
+			object.STRING_OBJ,
+			infixOperators[op],
+			object.STRING_OBJ,
+		)
+	}
+
+	return nil
+}
+
+// pushInteger pushes the result of an integer operation. It is accounted
+// for like an *object.Integer, although it is only boxed when it is stored
+// in an object.
+func (vm *VM) pushInteger(i int64) *object.Error {
+	if err := vm.Alloc.Alloc(integerSize); err != nil {
+		return vm.newError(err.Kind, "%s", err.Message)
+	}
+
+	vm.push(integer(i))
+
+	return nil
+}
+
+func (vm *VM) step() *object.Error {
+	vm.steps++
+
+	if vm.MaxSteps > 0 && vm.steps > vm.MaxSteps {
+		return vm.newError(object.StepLimit, "step limit of %d exceeded", vm.MaxSteps)
+	}
+
+	if vm.ctx != nil && (vm.steps == 1 || vm.steps%contextCheckInterval == 0) {
+		if err := vm.ctx.Err(); err != nil {
+			timeout := vm.newError(object.Timeout, "execution stopped: %s", err)
+			timeout.Err = err
+			return timeout
+		}
+	}
+
+	return nil
+}
+
+// charge charges the gas for n operations of kind op.
+func (vm *VM) charge(op gas.Op, n int) *object.Error {
+	if vm.Gas.Charge(op, n) {
+		return nil
+	}
+
+	return vm.newError(object.OutOfGas, "out of gas: limit of %d exceeded", vm.Gas.Limit)
+}
+
+// alloc accounts for obj, which has just been allocated.
+func (vm *VM) alloc(obj object.Object) *object.Error {
+	if err := vm.Alloc.Alloc(object.SizeOf(obj)); err != nil {
+		return vm.newError(err.Kind, "%s", err.Message)
+	}
+
+	return nil
+}
+
+// newError creates an error at the instruction being executed along with a
+// stack trace of the calls currently in progress. Calls made by the host
+// rather than by the program end the trace.
+func (vm *VM) newError(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+	stack := make([]object.Frame, 0, len(vm.frames))
+
+	for i := len(vm.frames) - 1; i >= 0; i-- {
+		f := &vm.frames[i]
+		stack = append(stack, object.Frame{Name: f.name(), Pos: f.pos(), Elided: f.elided})
+
+		if f.host {
+			break
+		}
+	}
+
+	err := &object.Error{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, a...),
+		Stack:   stack,
+	}
+
+	if len(stack) > 0 {
+		err.Pos = stack[0].Pos
+	}
+
+	return err
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return TRUE
+	}
+
+	return FALSE
+}
@@ -0,0 +1,680 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/marcel/monkey/ast"
+	"github.com/marcel/monkey/code"
+	"github.com/marcel/monkey/compiler"
+	"github.com/marcel/monkey/evaluator"
+	"github.com/marcel/monkey/gas"
+	"github.com/marcel/monkey/lexer"
+	"github.com/marcel/monkey/object"
+	"github.com/marcel/monkey/parser"
+	"github.com/marcel/monkey/token"
+)
+
+const fibonacci = `
+let fibonacci = fn(x) {
+  if (x < 2) { x } else { fibonacci(x - 1) + fibonacci(x - 2) }
+};
+fibonacci(%d)`
+
+// arithmetic computes in a tail-recursive loop, without allocating
+// anything but integers.
+const arithmetic = `
+let loop = fn(n, acc) {
+  if (n == 0) { acc } else { loop(n - 1, (acc + n * 3 - n / 2) / 2 + (n - acc) / 7) }
+};
+loop(%d, 0)`
+
+type VMTestSuite struct {
+	suite.Suite
+}
+
+func TestVMTestSuite(t *testing.T) {
+	suite.Run(t, new(VMTestSuite))
+}
+
+func (s *VMTestSuite) TestIntegerArithmetic() {
+	s.runVMTests([]vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"4 / 2 * 3 - 1", 5},
+		{"-5 + 10", 5},
+		{"(5 + 10 * 2 + 15 / 3) * 2 + -10", 50},
+		{"9223372036854775807 + 1", -9223372036854775808},
+	})
+}
+
+func (s *VMTestSuite) TestBooleanExpressions() {
+	s.runVMTests([]vmTestCase{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"true == false", false},
+		{"(1 < 2) == true", true},
+		{"1 == true", false},
+		{`"a" == "a"`, true},
+		{"!5", false},
+		{"!!true", true},
+		{"!(if (false) { 5 })", true},
+	})
+}
+
+func (s *VMTestSuite) TestConditionals() {
+	s.runVMTests([]vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (false) { 10 }", nil},
+		{"if (1) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (true) { }", nil},
+		{"if (true) { let a = 1; }", nil},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"let a = 1; if (true) { let a = 2; a } + a", 3},
+	})
+}
+
+func (s *VMTestSuite) TestReturnStatements() {
+	s.runVMTests([]vmTestCase{
+		{"return 10; 9;", 10},
+		{"9; return 2 * 5; 9;", 10},
+		{"if (10 > 1) { if (10 > 1) { return 10; } return 1; }", 10},
+		{"let x = if (true) { return 10 }; 9;", 10},
+		{"let f = fn() { let x = if (true) { return 10 }; 9 }; f()", 10},
+		{"let x = 1;", nil},
+	})
+}
+
+func (s *VMTestSuite) TestGlobalLetStatements() {
+	s.runVMTests([]vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let a = 1; let a = a + 1; a", 2},
+		{"let f = fn() { x }; let x = 5; f()", 5},
+	})
+}
+
+func (s *VMTestSuite) TestStringsArraysAndHashes() {
+	s.runVMTests([]vmTestCase{
+		{`"mon" + "key"`, "monkey"},
+		{"[1, 2 * 3, 4 + 5]", []int64{1, 6, 9}},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][3]", nil},
+		{"[1][-1]", nil},
+		{`{"a": 1, 2: 3}["a"]`, 1},
+		{`{"a": 1, 2: 3}[2]`, 3},
+		{`{true: 1}[false]`, nil},
+	})
+}
+
+func (s *VMTestSuite) TestFunctions() {
+	s.runVMTests([]vmTestCase{
+		{"let f = fn() { 5 + 10 }; f()", 15},
+		{"let one = fn() { 1 }; let two = fn() { one() + one() }; two()", 2},
+		{"let f = fn() { }; f()", nil},
+		{"let f = fn(a, b) { let c = a + b; c }; f(1, 2) + f(3, 4)", 10},
+		{"let g = 50; let f = fn(a) { let g = a; g }; f(1) + g", 51},
+		{"fn(x) { x }(4)", 4},
+		{"let f = fn(a) { if (a) { let b = 1; b } else { let c = 2; c } }; f(true) + f(false)", 3},
+	})
+}
+
+func (s *VMTestSuite) TestClosures() {
+	s.runVMTests([]vmTestCase{
+		{"let adder = fn(a) { fn(b) { a + b } }; adder(2)(3)", 5},
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", 6},
+		{"let g = 1; let f = fn(a) { let b = 2; fn() { g + a + b } }; f(3)()", 6},
+		{`
+let countdown = fn(x) { if (x == 0) { return 0 } else { countdown(x - 1) } };
+let wrapper = fn() { countdown(1) };
+wrapper()`, 0},
+		{`
+let wrapper = fn() {
+  let inner = fn(x) { if (x == 0) { return 0 } else { 1 + inner(x - 1) } };
+  inner(3)
+};
+wrapper()`, 3},
+		{"let f = fn() { let g = fn() { h() }; let h = fn() { 1 }; g() }; f()", 1},
+		{"let f = fn() { let x = 1; let g = fn() { x }; let x = 2; g() }; f()", 2},
+		{"let x = 1; let f = fn() { let g = fn() { x }; let a = g(); let x = 2; [a, g()] }; f()", []int64{1, 2}},
+	})
+}
+
+func (s *VMTestSuite) TestBuiltinFunctions() {
+	s.runVMTests([]vmTestCase{
+		{`len("four")`, 4},
+		{"len([1, 2])", 2},
+		{"first([1, 2])", 1},
+		{"rest([1, 2, 3])", []int64{2, 3}},
+		{"push([], 1)", []int64{1}},
+		{`puts("hello")`, nil},
+		{"let len = fn(x) { 42 }; len([])", 42},
+		{"let f = fn(a) { len(a) }; f([1])", 1},
+	})
+}
+
+func (s *VMTestSuite) TestErrors() {
+	expectations := []struct {
+		Input   string
+		Kind    object.ErrorKind
+		Message string
+	}{
+		{"5 + true;", object.TypeMismatch, "1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", object.UnknownOperator, "1:1: unknown operator: -BOOLEAN"},
+		{"true + false;", object.UnknownOperator, "1:6: unknown operator: BOOLEAN + BOOLEAN"},
+		{`"a" - "b"`, object.UnknownOperator, "1:5: unknown operator: STRING - STRING"},
+		{"foobar", object.UnknownIdentifier, "1:1: identifier not found: foobar"},
+		{"10 / (5 - 5)", object.DivisionByZero, "1:4: division by zero"},
+		{"let x = 5; x()", object.NotAFunction, "1:12: not a function: INTEGER"},
+		{"fn(a, b) { a }(1)", object.ArityMismatch, "1:1: wrong number of arguments: want=2, got=1"},
+		{"len(1)", object.TypeMismatch, "1:1: argument to `len` not supported, got INTEGER"},
+		{"{[]: 1}", object.TypeMismatch, "1:1: unusable as hash key: ARRAY"},
+		{"1[0]", object.TypeMismatch, "1:2: index operator not supported: INTEGER[INTEGER]"},
+	}
+
+	for _, e := range expectations {
+		err, ok := s.run(e.Input).(*object.Error)
+		s.Require().True(ok, e.Input)
+		s.Equal(e.Kind, err.Kind, e.Input)
+		s.Equal(e.Message, err.Error(), e.Input)
+	}
+}
+
+func (s *VMTestSuite) TestErrorStackTrace() {
+	input := `let c = fn(x) { x + true };
+let b = fn(x) { c(x) + 0 };
+let a = fn(x) { fn() { b(x) + 0 }() + 0 };
+1; a(1)`
+
+	err, ok := s.run(input).(*object.Error)
+	s.Require().True(ok)
+	s.Equal(`1:19: type mismatch: INTEGER + BOOLEAN
+	at c (1:19)
+	at b (2:17)
+	at <anonymous> (3:24)
+	at a (3:17)
+	at <main> (4:4)`, err.StackTrace())
+
+	err, ok = s.run(strings.ReplaceAll(input, " + 0", "")).(*object.Error)
+	s.Require().True(ok)
+	s.Equal(`1:19: type mismatch: INTEGER + BOOLEAN
+	at c (1:19)
+	... 3 tail calls elided
+	at <main> (4:4)`, err.StackTrace())
+
+	err, ok = s.run("let b = fn(x) { x + true };\nlet a = fn(x) { b(x) };\n1 + a(1)").(*object.Error)
+	s.Require().True(ok)
+	s.Equal("1:19: type mismatch: INTEGER + BOOLEAN\n\tat b (1:19)\n\t... 1 tail call elided\n\tat <main> (3:5)", err.StackTrace())
+}
+
+// TestEvaluatorParity runs programs with both the evaluator and the VM and
+// expects the same results, errors, stack traces and gas.
+func (s *VMTestSuite) TestEvaluatorParity() {
+	inputs := []string{
+		"let a = [1, 2, 3]; let b = {\"x\": a}; b[\"x\"][2] * -a[0]",
+		"let map = fn(arr, f) { if (len(arr) == 0) { [] } else { let r = map(rest(arr), f); [f(first(arr))] + r } }; map([1, 2], fn(x) { x * 2 })",
+		"let reduce = fn(arr, acc, f) { if (len(arr) == 0) { acc } else { reduce(rest(arr), f(acc, first(arr)), f) } }; reduce([1, 2, 3, 4], 0, fn(a, b) { a + b })",
+		"let x = 10; let f = fn() { let x = x * 2; x }; [f(), x]",
+		"let f = fn(n) { if (n > 0) { f(n - 1) } }; f(3)",
+		"let f = fn() { 1 }; f == f",
+		"let f = fn(x) { fn(y) { x / y } }; f(1)(0)",
+		"let f = fn(x) { x[\"k\"] }; f({\"k\": fn() { missing }})()",
+		"type(fn() { 1 }) + \" \" + type(len)",
+		"str([1, \"a\", true, {1: 2}])",
+		"if (if (true) { false }) { 1 } else { 2 }",
+		"1; let a = 2; if (a > 1) { let a = 3; a + 1 }",
+		"let f = fn() { return 1; 2 }; f() + f()",
+		"let a = push(rest([1, 2, 3]), 4); str(a) + \"!\"",
+	}
+
+	for _, input := range inputs {
+		table := gas.Table{Infix: 1, Prefix: 2, Call: 3, Builtin: 4, Index: 5, Element: 6, Byte: 7}
+
+		e := evaluator.New()
+		e.Gas = gas.NewMeter(table, 0)
+		expected := e.Eval(s.parse(input), e.Globals)
+
+		vm := s.newVM(input)
+		vm.Gas = gas.NewMeter(table, 0)
+		actual := vm.Run()
+
+		s.Equal(expected.Inspect(), actual.Inspect(), input)
+		s.Equal(e.Gas.Used(), vm.Gas.Used(), input)
+
+		if err, ok := expected.(*object.Error); ok {
+			s.Require().IsType(err, actual, input)
+			s.Equal(err.StackTrace(), actual.(*object.Error).StackTrace(), input)
+		}
+	}
+}
+
+func (s *VMTestSuite) TestTailCalls() {
+	expectations := []vmTestCase{
+		{"let loop = fn(n) { if (n == 0) { return 0 }; loop(n - 1) }; loop(1000000)", 0},
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0)", 5000050000},
+		{`
+let even = fn(n) { if (n == 0) { return true }; return odd(n - 1) };
+let odd = fn(n) { if (n == 0) { return false }; return even(n - 1) };
+if (even(100001)) { 1 } else { 2 }`, 2},
+		{"let f = fn(n) { if (n > 0) { let m = n - 1; return f(m); }; 7 }; f(100000)", 7},
+	}
+
+	for _, e := range expectations {
+		vm := s.newVM(e.Input)
+		vm.MaxDepth = 10
+
+		s.testObject(vm.Run(), e.Expected, e.Input)
+		s.Equal(0, vm.sp, e.Input)
+	}
+}
+
+func (s *VMTestSuite) TestStackOverflow() {
+	vm := s.newVM("let f = fn(n) { 1 + f(n + 1) };\nf(0)")
+	vm.MaxDepth = 100
+
+	err, ok := vm.Run().(*object.Error)
+	s.Require().True(ok)
+	s.True(errors.Is(err, object.ErrStackOverflow))
+	s.Equal("1:21: stack overflow: maximum call depth of 100 exceeded", err.Error())
+	s.Len(err.Stack, 101)
+	s.Equal(object.Frame{Name: "<main>", Pos: token.Position{Offset: 32, Line: 2, Column: 1}}, err.Stack[100])
+
+	vm = s.newVM("let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } };\nf(100000)")
+	vm.MaxDepth = 0
+	s.testObject(vm.Run(), 100000, "the stack grows")
+
+	vm = s.newVM("let f = fn(n) { f(n + 1) };\nf(0)")
+	vm.MaxDepth = 100
+	vm.MaxSteps = 10000
+
+	err, ok = vm.Run().(*object.Error)
+	s.Require().True(ok)
+	s.True(errors.Is(err, object.ErrStepLimit), "tail calls never reach the depth limit")
+	s.Len(err.Stack, 2)
+	s.Positive(err.Stack[0].Elided)
+}
+
+func (s *VMTestSuite) TestStepLimit() {
+	vm := s.newVM("let f = fn(n) { if (n > 0) { f(n - 1) } }; f(10)")
+	vm.MaxSteps = 50
+
+	err, ok := vm.Run().(*object.Error)
+	s.Require().True(ok)
+	s.True(errors.Is(err, object.ErrStepLimit))
+	s.Equal(0, vm.sp)
+	s.Empty(vm.frames)
+
+	vm.MaxSteps = 0
+	s.Equal(NULL, vm.Run())
+}
+
+func (s *VMTestSuite) TestContextCancellation() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err, ok := s.newVM("let loop = fn(n) { loop(n + 1) }; loop(0)").RunContext(ctx).(*object.Error)
+	s.Require().True(ok)
+	s.True(errors.Is(err, object.ErrTimeout))
+	s.True(errors.Is(err, context.DeadlineExceeded))
+	s.Equal("execution stopped: context deadline exceeded", err.Message)
+}
+
+func (s *VMTestSuite) TestMemoryQuota() {
+	vm := s.newVM("let grow = fn(arr, n) { if (n > 0) { grow(push(arr, n), n - 1) } else { arr } };\ngrow([], 1000)")
+	vm.Alloc.Quota = 10000
+	vm.Builtins = object.CoreBuiltins(io.Discard, io.Discard, vm.Alloc)
+
+	err, ok := vm.Run().(*object.Error)
+	s.Require().True(ok)
+	s.True(errors.Is(err, object.ErrMemoryLimit))
+	s.Equal("memory quota of 10000 bytes exceeded", err.Message)
+	s.Equal("grow", err.Stack[0].Name)
+
+	vm = s.newVM("1 + 2")
+	vm.Run()
+	s.Equal(object.AllocStats{Objects: 1, Bytes: 24}, vm.Alloc.Stats())
+}
+
+func (s *VMTestSuite) TestOutOfGas() {
+	vm := s.newVM("let f = fn(n) { if (n > 0) { f(n - 1) } };\nf(20)")
+	vm.Gas = gas.NewMeter(gas.DefaultTable, 100)
+
+	err, ok := vm.Run().(*object.Error)
+	s.Require().True(ok)
+	s.True(errors.Is(err, object.ErrOutOfGas))
+	s.Equal("out of gas: limit of 100 exceeded", err.Message)
+}
+
+func (s *VMTestSuite) TestGlobalsAndApply() {
+	symbolTable := compiler.NewGlobalSymbolTable(compiler.DefaultBuiltins())
+	var constants []object.Object
+	var globals []object.Object
+
+	run := func(input string) object.Object {
+		c := compiler.NewWithState(symbolTable, constants)
+		s.Require().NoError(c.Compile(s.parse(input)))
+
+		bytecode := c.Bytecode()
+		constants = bytecode.Constants
+
+		vm := NewWithGlobals(bytecode, globals)
+		result := vm.Run()
+		globals = vm.Globals
+
+		return result
+	}
+
+	run("let add = fn(a, b) { a + b }; let base = 10;")
+	s.testObject(run("add(base, 1)"), 11, "globals persist")
+
+	symbol, ok := symbolTable.Resolve("add")
+	s.Require().True(ok)
+
+	vm := NewWithGlobals(&compiler.Bytecode{Globals: symbolTable.GlobalNames()}, globals)
+	s.testObject(vm.Apply(globals[symbol.Index], []object.Object{&object.Integer{Value: 2}, &object.Integer{Value: 3}}), 5, "apply")
+
+	err, ok := vm.Apply(globals[symbol.Index], []object.Object{&object.Integer{Value: 2}, TRUE}).(*object.Error)
+	s.Require().True(ok)
+	s.Equal([]object.Frame{{Name: "add", Pos: token.Position{Offset: 23, Line: 1, Column: 24}}}, err.Stack)
+	s.Equal(0, vm.sp)
+
+	builtin, _ := vm.Builtins.Lookup("len")
+	s.testObject(vm.Apply(builtin, []object.Object{&object.String{Value: "abc"}}), 3, "apply builtin")
+}
+
+func (s *VMTestSuite) TestVerify() {
+	function := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins...), NumLocals: numLocals}
+	}
+
+	expectations := []struct {
+		Bytecode *compiler.Bytecode
+		Kind     VerifyErrorKind
+		Expected string
+	}{
+		{
+			&compiler.Bytecode{Instructions: code.Instructions{255}},
+			InvalidOpcode,
+			"main program at 0000: invalid opcode: opcode 255 undefined",
+		},
+		{
+			&compiler.Bytecode{Instructions: code.Make(code.OpConstant, 0)[:2]},
+			TruncatedInstruction,
+			"main program at 0000: truncated instruction: OpConstant",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpConstant, 1), code.Make(code.OpReturnValue))},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: constant 1 of 0",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpGetGlobal, 0), code.Make(code.OpReturnValue)), Globals: []string{}},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: global 0 of 0",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpGetBuiltin, 3), code.Make(code.OpReturnValue)), Builtins: []string{"len"}},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: builtin 3 of 1",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpGetLocal, 0), code.Make(code.OpReturnValue))},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: local 0 of 0",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: free variable 0 of 0",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpReturnValue)),
+				Constants:    []object.Object{function(0, code.Make(code.OpGetFree, 1), code.Make(code.OpReturnValue))},
+			},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: function 0 refers to 2 free variables, closure has 0",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpConstant, 0), code.Make(code.OpReturnValue)),
+				Constants:    []object.Object{function(0, code.Make(code.OpReturn))},
+			},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: constant is a function",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpReturnValue)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: constant is not a function",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpHash, 1), code.Make(code.OpReturnValue))},
+			OperandOutOfRange,
+			"main program at 0000: operand out of range: odd number of hash elements",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpJump, 1), code.Make(code.OpReturn))},
+			InvalidJump,
+			"main program at 0000: invalid jump: target 0001 is not an instruction",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpJump, 3))},
+			InvalidJump,
+			"main program at 0000: invalid jump: target 0003 is not an instruction",
+		},
+		{
+			&compiler.Bytecode{
+				Constants:    []object.Object{function(0, code.Make(code.OpGetFreeIfBound, 0, 2), code.Make(code.OpReturnValue))},
+				Instructions: code.Make(code.OpReturn),
+			},
+			InvalidJump,
+			"function 0 at 0000: invalid jump: target 0002 is not an instruction",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpGetGlobalIfBound, 0, 5), code.Make(code.OpReturnValue)),
+				Globals:      []string{"x"},
+			},
+			StackMismatch,
+			"main program at 0005: stack mismatch: stack has 0 values on one path and 1 on another",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpAdd), code.Make(code.OpReturnValue))},
+			StackUnderflow,
+			"main program at 0001: stack underflow: OpAdd needs 2 values, stack has 1",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(
+					code.Make(code.OpTrue),
+					code.Make(code.OpJumpNotTruthy, 5),
+					code.Make(code.OpNull),
+					code.Make(code.OpReturn),
+				),
+			},
+			StackMismatch,
+			"main program at 0005: stack mismatch: stack has 0 values on one path and 1 on another",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpPop))},
+			MissingReturn,
+			"main program at 0001: missing return: execution continues past the end",
+		},
+		{
+			&compiler.Bytecode{Constants: []object.Object{function(0)}, Instructions: code.Make(code.OpReturn)},
+			MissingReturn,
+			"function 0: missing return: no instructions",
+		},
+		{
+			&compiler.Bytecode{Constants: []object.Object{function(300, code.Make(code.OpReturn))}, Instructions: code.Make(code.OpReturn)},
+			InvalidFunction,
+			"function 0: invalid function: 300 locals",
+		},
+		{
+			&compiler.Bytecode{
+				Constants: []object.Object{
+					&object.Integer{Value: 1},
+					function(1, code.Make(code.OpGetLocal, 1), code.Make(code.OpReturnValue)),
+				},
+				Instructions: code.Make(code.OpReturn),
+			},
+			OperandOutOfRange,
+			"function 1 at 0000: operand out of range: local 1 of 1",
+		},
+	}
+
+	for _, e := range expectations {
+		err := Verify(e.Bytecode)
+
+		var verifyErr *VerifyError
+		s.Require().True(errors.As(err, &verifyErr), e.Expected)
+		s.Equal(e.Kind, verifyErr.Kind, e.Expected)
+		s.Equal(e.Expected, err.Error())
+	}
+}
+
+// TestVerifiedProgramsDoNotPanic runs damaged copies of a program that
+// still pass Verify.
+func (s *VMTestSuite) TestVerifiedProgramsDoNotPanic() {
+	c := compiler.New()
+	s.Require().NoError(c.Compile(s.parse(fmt.Sprintf(fibonacci, 5) + `;
+let h = {"a": [1, 2, 3]};
+let f = fn(x) { fn(y) { x + y + h["a"][1] } };
+puts(len(rest(h["a"])), f(1)(2), -1 * 2 / 1, !true == false)`)))
+	original := c.Bytecode()
+
+	r := rand.New(rand.NewPCG(1, 2))
+	verified := 0
+
+	for i := 0; i < 5000; i++ {
+		bytecode := *original
+		bytecode.Instructions = bytes.Clone(original.Instructions)
+		bytecode.Instructions[r.IntN(len(bytecode.Instructions))] = byte(r.IntN(40))
+
+		if Verify(&bytecode) != nil {
+			continue
+		}
+		verified++
+
+		vm := New(&bytecode)
+		vm.Builtins = object.CoreBuiltins(io.Discard, io.Discard, vm.Alloc)
+		vm.MaxSteps = 10000
+		s.NotPanics(func() { vm.Run() }, bytecode.Instructions.String())
+	}
+
+	s.Positive(verified)
+}
+
+type vmTestCase struct {
+	Input    string
+	Expected interface{}
+}
+
+func (s *VMTestSuite) runVMTests(tests []vmTestCase) {
+	for _, tt := range tests {
+		vm := s.newVM(tt.Input)
+		vm.Builtins = object.CoreBuiltins(io.Discard, io.Discard, vm.Alloc)
+
+		s.testObject(vm.Run(), tt.Expected, tt.Input)
+		s.Equal(0, vm.sp, tt.Input)
+	}
+}
+
+func (s *VMTestSuite) testObject(obj object.Object, expected interface{}, input string) {
+	switch expected := expected.(type) {
+	case int:
+		s.Equal(&object.Integer{Value: int64(expected)}, obj, input)
+	case bool:
+		s.Equal(nativeBoolToBooleanObject(expected), obj, input)
+	case string:
+		s.Equal(&object.String{Value: expected}, obj, input)
+	case []int64:
+		array, ok := obj.(*object.Array)
+		s.Require().True(ok, "%s: %s", input, obj.Inspect())
+		s.Require().Len(array.Elements, len(expected), input)
+		for i, e := range expected {
+			s.Equal(&object.Integer{Value: e}, array.Elements[i], input)
+		}
+	case nil:
+		s.Equal(NULL, obj, input)
+	}
+}
+
+func (s *VMTestSuite) run(input string) object.Object {
+	return s.newVM(input).Run()
+}
+
+func (s *VMTestSuite) newVM(input string) *VM {
+	c := compiler.New()
+	s.Require().NoError(c.Compile(s.parse(input)), input)
+	s.Require().NoError(Verify(c.Bytecode()), input)
+
+	return New(c.Bytecode())
+}
+
+func (s *VMTestSuite) parse(input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	s.Require().Empty(p.Errors(), input)
+
+	return program
+}
+
+func BenchmarkFibonacci(b *testing.B) {
+	benchmarkEngines(b, fmt.Sprintf(fibonacci, 30))
+}
+
+func BenchmarkArithmetic(b *testing.B) {
+	benchmarkEngines(b, fmt.Sprintf(arithmetic, 100000))
+}
+
+func benchmarkEngines(b *testing.B, input string) {
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	b.Run("tree-walking", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e := evaluator.New()
+			e.Eval(program, e.Globals)
+		}
+	})
+
+	b.Run("vm", func(b *testing.B) {
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			b.Fatal(err)
+		}
+		bytecode := c.Bytecode()
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			New(bytecode).Run()
+		}
+	})
+}
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+
+	return out
+}